@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+	grblMod "github.com/fornellas/cgs/grbl"
+)
+
+var ErrLimitsViolation = errors.New("program moves outside machine travel limits")
+
+// checkLimits returns all motion from programReader that leaves the machine travel limits. When
+// the machine position is unknown, it is assumed to be homed.
+func checkLimits(
+	programReader io.Reader,
+	settings grblMod.Settings,
+	parameters gcode.MachineParameters,
+	position *gcode.Vector,
+) ([]gcode.LimitsViolation, error) {
+	limits, err := settings.Limits(homingForceSetOrigin)
+	if err != nil {
+		return nil, err
+	}
+	arcTolerance, err := settings.GetFloat("12")
+	if err != nil {
+		return nil, err
+	}
+	machinePosition := limits.Home
+	if position != nil {
+		machinePosition = *position
+	}
+	return gcode.CheckLimits(gcode.NewParser(programReader), gcode.NewMachine(parameters, machinePosition), limits, arcTolerance)
+}
+
+// CheckLimits returns all motion from the g-code at path that leaves the machine travel limits,
+// using settings, G-code parameters and machine position as set by AddCheckLimitsFlags. When the
+// machine position is unknown, it is assumed to be homed.
+func CheckLimits(ctx context.Context, path string) (violations []gcode.LimitsViolation, err error) {
+	settings, parameters, position, err := GetSettings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+
+	return checkLimits(f, settings, parameters, position)
+}
+
+// CheckLimitsGate checks the program from programReader against the machine travel limits, and
+// errors with ErrLimitsViolation when any motion leaves them. It is meant to be called right before
+// streaming the program, using the same connection to Grbl, so that the machine position is the one
+// the program starts from. Settings and G-code parameters are read from the file set by
+// AddCheckLimitsFlags, or from Grbl.
+func CheckLimitsGate(ctx context.Context, grbl *grblMod.Grbl, programReader io.Reader) error {
+	settings, parameters, position, err := readSettingsConnectedGrbl(ctx, grbl)
+	if err != nil {
+		return err
+	}
+	if settingsPath != "" {
+		log.MustLogger(ctx).Info("Reading settings", "path", settingsPath)
+		settings, parameters, _, err = readSettingsFile(settingsPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	violations, err := checkLimits(programReader, settings, parameters, position)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+	logger := log.MustLogger(ctx)
+	for _, violation := range violations {
+		logger.Error("Limits violation", "violation", violation.String())
+	}
+	return fmt.Errorf("%w: %d violation(s), first at line %d", ErrLimitsViolation, len(violations), violations[0].Segment.Line)
+}
+
+var CheckLimitsCmd = &cobra.Command{
+	Use:   "check-limits path",
+	Short: "Check whether the g-code at given path moves outside the machine travel limits, given by Grbl's max travel and homing settings and current work coordinates.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"settings", settingsPath,
+			"port-name", portName,
+			"address", address,
+			"homing-force-set-origin", homingForceSetOrigin,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		violations, err := CheckLimits(ctx, path)
+		if err != nil {
+			return err
+		}
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		for _, violation := range violations {
+			if _, err := fmt.Fprintln(w, violation.String()); err != nil {
+				return err
+			}
+		}
+		if len(violations) > 0 {
+			return fmt.Errorf("%w: %d violation(s)", ErrLimitsViolation, len(violations))
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var homingForceSetOrigin bool
+var defaultHomingForceSetOrigin = false
+
+// AddCheckLimitsFlags adds flags required by CheckLimits.
+func AddCheckLimitsFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVarP(&homingForceSetOrigin, "homing-force-set-origin", "", defaultHomingForceSetOrigin, "Whether Grbl was compiled with HOMING_FORCE_SET_ORIGIN, which sets machine origin at the homed position")
+	AddSettingsFlags(cmd)
+}
+
+func init() {
+	AddCheckLimitsFlags(CheckLimitsCmd)
+	AddOutputFlags(CheckLimitsCmd)
+	RootCmd.AddCommand(CheckLimitsCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		homingForceSetOrigin = defaultHomingForceSetOrigin
+	})
+}
@@ -51,7 +51,7 @@ var CompactCmd = &cobra.Command{
 			if block == nil {
 				continue
 			}
-			line := block.String()
+			line := block.StringWithoutComments()
 			n, err := fmt.Fprintln(w, line)
 			if err != nil {
 				return err
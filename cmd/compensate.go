@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var CompensateCmd = &cobra.Command{
+	Use:   "compensate path",
+	Short: "Read g-code from given path and apply cutter radius compensation (G41 / G42), offsetting XY motion by the tool radius.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"diameter", compensateDiameter,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		parser := gcode.NewParser(f)
+		if err := WriteTransform(w, gcode.NewCutterCompensation(parser, compensateDiameter)); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var compensateDiameter float64
+var defaultCompensateDiameter float64 = 0
+
+func init() {
+	CompensateCmd.PersistentFlags().Float64VarP(&compensateDiameter, "diameter", "d", defaultCompensateDiameter, "Tool diameter in millimeters, for G41 / G42 blocks without a D word")
+
+	AddOutputFlags(CompensateCmd)
+	RootCmd.AddCommand(CompensateCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		compensateDiameter = defaultCompensateDiameter
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var EstimateCmd = &cobra.Command{
+	Use:   "estimate path",
+	Short: "Estimate the run time of the g-code at given path, using Grbl acceleration and max rate settings.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"settings", settingsPath,
+			"port-name", portName,
+			"address", address,
+			"segments", estimateSegments,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		settings, parameters, position, err := GetSettings(ctx)
+		if err != nil {
+			return err
+		}
+		plannerSettings, err := settings.PlannerSettings()
+		if err != nil {
+			return err
+		}
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		machinePosition := gcode.Vector{}
+		if position != nil {
+			machinePosition = *position
+		}
+		estimate, err := gcode.EstimateProgram(
+			gcode.NewParser(f), plannerSettings, gcode.NewMachine(parameters, machinePosition),
+		)
+		if err != nil {
+			return err
+		}
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		if estimateSegments {
+			for _, segmentEstimate := range estimate.Segments {
+				segment := segmentEstimate.Segment
+				if _, err := fmt.Fprintf(
+					w, "line %d: %s %.4fmm %s\n",
+					segment.Line, segment.Type, segment.Length(), segmentEstimate.Duration.Round(time.Millisecond),
+				); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := fmt.Fprintf(
+			w, "Total: %s\nCutting: %s\nRapid: %s\nDwell: %s\n",
+			estimate.Total.Round(time.Second),
+			estimate.Cutting.Round(time.Second),
+			estimate.Rapid.Round(time.Second),
+			estimate.Dwell.Round(time.Second),
+		); err != nil {
+			return err
+		}
+		if estimate.Pauses > 0 {
+			if _, err := fmt.Fprintf(w, "Pauses: %d (not included in total)\n", estimate.Pauses); err != nil {
+				return err
+			}
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var estimateSegments bool
+var defaultEstimateSegments = false
+
+func init() {
+	EstimateCmd.PersistentFlags().BoolVarP(&estimateSegments, "segments", "", defaultEstimateSegments, "Output the estimate for each motion segment")
+	AddSettingsFlags(EstimateCmd)
+	AddOutputFlags(EstimateCmd)
+	RootCmd.AddCommand(EstimateCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		estimateSegments = defaultEstimateSegments
+	})
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var ExpandCyclesCmd = &cobra.Command{
+	Use:   "expand-cycles path",
+	Short: "Read g-code from given path and expand canned drilling cycles (G73, G81, G82 and G83) to G0, G1 and G4 blocks.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		parser := gcode.NewParser(f)
+		if err := WriteTransform(w, gcode.NewCannedCycles(parser)); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+func init() {
+	AddOutputFlags(ExpandCyclesCmd)
+	RootCmd.AddCommand(ExpandCyclesCmd)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var FmtCmd = &cobra.Command{
+	Use:   "fmt path",
+	Short: "Read g-code from given path and format it consistently: number precision, spacing, casing, line numbers, comments and redundant modal words.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"precision", fmtPrecision,
+			"spaces", fmtSpaces,
+			"uppercase", fmtUppercase,
+			"line-numbers", fmtLineNumbers,
+			"line-number-start", fmtLineNumberStart,
+			"line-number-increment", fmtLineNumberIncrement,
+			"comments", fmtComments,
+			"remove-redundant-modal-words", fmtRemoveRedundantModalWords,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		lineNumbers, err := gcode.NewLineNumbers(fmtLineNumbers)
+		if err != nil {
+			return err
+		}
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		formatter := gcode.NewFormatter(gcode.NewParser(f), gcode.FormatterOptions{
+			Precision:                 fmtPrecision,
+			Spaces:                    fmtSpaces,
+			Uppercase:                 fmtUppercase,
+			LineNumbers:               lineNumbers,
+			LineNumberStart:           fmtLineNumberStart,
+			LineNumberIncrement:       fmtLineNumberIncrement,
+			Comments:                  fmtComments,
+			RemoveRedundantModalWords: fmtRemoveRedundantModalWords,
+		})
+		if err := WriteTransform(w, formatter); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var fmtPrecision int
+var defaultFmtPrecision = gcode.DefaultFormatterOptions.Precision
+
+var fmtSpaces bool
+var defaultFmtSpaces = gcode.DefaultFormatterOptions.Spaces
+
+var fmtUppercase bool
+var defaultFmtUppercase = gcode.DefaultFormatterOptions.Uppercase
+
+var fmtLineNumbers string
+var defaultFmtLineNumbers = gcode.DefaultFormatterOptions.LineNumbers.String()
+
+var fmtLineNumberStart uint
+var defaultFmtLineNumberStart = gcode.DefaultFormatterOptions.LineNumberStart
+
+var fmtLineNumberIncrement uint
+var defaultFmtLineNumberIncrement = gcode.DefaultFormatterOptions.LineNumberIncrement
+
+var fmtComments bool
+var defaultFmtComments = gcode.DefaultFormatterOptions.Comments
+
+var fmtRemoveRedundantModalWords bool
+var defaultFmtRemoveRedundantModalWords = gcode.DefaultFormatterOptions.RemoveRedundantModalWords
+
+func init() {
+	FmtCmd.PersistentFlags().IntVarP(&fmtPrecision, "precision", "", defaultFmtPrecision, "Decimal places for argument values, with trailing zeros removed; negative keeps values as written")
+	FmtCmd.PersistentFlags().BoolVarP(&fmtSpaces, "spaces", "", defaultFmtSpaces, "Separate words with spaces")
+	FmtCmd.PersistentFlags().BoolVarP(&fmtUppercase, "uppercase", "", defaultFmtUppercase, "Use uppercase letters; if disabled, letters are kept as written")
+	FmtCmd.PersistentFlags().StringVarP(&fmtLineNumbers, "line-numbers", "", defaultFmtLineNumbers, "Line numbers (N words): keep, remove or renumber")
+	FmtCmd.PersistentFlags().UintVarP(&fmtLineNumberStart, "line-number-start", "", defaultFmtLineNumberStart, "First line number, when renumbering")
+	FmtCmd.PersistentFlags().UintVarP(&fmtLineNumberIncrement, "line-number-increment", "", defaultFmtLineNumberIncrement, "Line number increment, when renumbering")
+	FmtCmd.PersistentFlags().BoolVarP(&fmtComments, "comments", "", defaultFmtComments, "Keep comments")
+	FmtCmd.PersistentFlags().BoolVarP(&fmtRemoveRedundantModalWords, "remove-redundant-modal-words", "", defaultFmtRemoveRedundantModalWords, "Remove G/M words for modes already set by previous blocks")
+
+	AddOutputFlags(FmtCmd)
+	RootCmd.AddCommand(FmtCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		fmtPrecision = defaultFmtPrecision
+		fmtSpaces = defaultFmtSpaces
+		fmtUppercase = defaultFmtUppercase
+		fmtLineNumbers = defaultFmtLineNumbers
+		fmtLineNumberStart = defaultFmtLineNumberStart
+		fmtLineNumberIncrement = defaultFmtLineNumberIncrement
+		fmtComments = defaultFmtComments
+		fmtRemoveRedundantModalWords = defaultFmtRemoveRedundantModalWords
+	})
+}
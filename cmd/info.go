@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+// writeInfo writes info as plain text.
+func writeInfo(w io.Writer, info *gcode.Info) error {
+	var lines []string
+	if info.BoundingBox != nil {
+		min, max := info.BoundingBox.Min, info.BoundingBox.Max
+		lines = append(lines,
+			"Bounding box (work coordinates):",
+			fmt.Sprintf("  X: %.4f .. %.4f mm (%.4f mm)", min.X, max.X, max.X-min.X),
+			fmt.Sprintf("  Y: %.4f .. %.4f mm (%.4f mm)", min.Y, max.Y, max.Y-min.Y),
+			fmt.Sprintf("  Z: %.4f .. %.4f mm (%.4f mm)", min.Z, max.Z, max.Z-min.Z),
+		)
+	}
+	lines = append(lines,
+		fmt.Sprintf("Cutting distance: %.4f mm", info.CuttingDistance),
+		fmt.Sprintf("Rapid distance: %.4f mm", info.RapidDistance),
+	)
+	if info.FeedRate != nil {
+		lines = append(lines, fmt.Sprintf("Feed rate: %.4f .. %.4f mm/min", info.FeedRate.Min, info.FeedRate.Max))
+	}
+	if info.SpindleSpeed != nil {
+		lines = append(lines, fmt.Sprintf("Spindle speed: %.0f .. %.0f", info.SpindleSpeed.Min, info.SpindleSpeed.Max))
+	}
+	if len(info.Tools) > 0 {
+		lines = append(lines, fmt.Sprintf("Tools: %v", info.Tools))
+	}
+	if len(info.Words) > 0 {
+		lines = append(lines, "Words:")
+		for _, w := range info.Words {
+			lines = append(lines, fmt.Sprintf("  %s (%s): %d", w.Word, w.Name, w.Count))
+		}
+	}
+	if len(info.Pauses) > 0 {
+		lines = append(lines, "Pauses:")
+		for _, pause := range info.Pauses {
+			line := fmt.Sprintf("  line %d", pause.Line)
+			if pause.Comment != "" {
+				line += ": " + pause.Comment
+			}
+			lines = append(lines, line)
+		}
+	}
+	if len(info.Metadata) > 0 {
+		lines = append(lines, "Metadata:")
+		for _, metadata := range info.Metadata {
+			lines = append(lines, fmt.Sprintf("  %s: %s", metadata.Key, metadata.Value))
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var InfoCmd = &cobra.Command{
+	Use:   "info path",
+	Short: "Print information about the g-code at given path: bounding box, distances, feed rates, words used, pauses, tools and header metadata.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"json", infoJSON,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		info, err := gcode.GetInfo(gcode.NewParser(f), gcode.NewMachineAtUnknownPosition(gcode.MachineParameters{}))
+		if err != nil {
+			return err
+		}
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		if infoJSON {
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(info); err != nil {
+				return err
+			}
+		} else {
+			if err := writeInfo(w, info); err != nil {
+				return err
+			}
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var infoJSON bool
+var defaultInfoJSON = false
+
+func init() {
+	InfoCmd.PersistentFlags().BoolVarP(&infoJSON, "json", "", defaultInfoJSON, "Output as JSON")
+	AddOutputFlags(InfoCmd)
+	RootCmd.AddCommand(InfoCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		infoJSON = defaultInfoJSON
+	})
+}
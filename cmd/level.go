@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+	grblMod "github.com/fornellas/cgs/grbl"
+)
+
+var LevelCmd = &cobra.Command{
+	Use:   "level path",
+	Short: "Read g-code from given path and compensate Z coordinates using a saved height map.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"height-map", levelHeightMapPath,
+			"max-segment-length", levelMaxSegmentLength,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		heightMapBytes, err := os.ReadFile(levelHeightMapPath)
+		if err != nil {
+			return err
+		}
+		var heightMap grblMod.HeightMap
+		if err := json.Unmarshal(heightMapBytes, &heightMap); err != nil {
+			return err
+		}
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		parser := gcode.NewParser(f)
+		if err := WriteTransform(w, gcode.NewLevelZ(parser, &heightMap, levelMaxSegmentLength)); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var levelHeightMapPath string
+var defaultLevelHeightMapPath = ""
+
+var levelMaxSegmentLength float64
+var defaultLevelMaxSegmentLength float64 = 1
+
+func init() {
+	LevelCmd.PersistentFlags().StringVarP(&levelHeightMapPath, "height-map", "m", defaultLevelHeightMapPath, "Path to height map saved from the TUI")
+	if err := LevelCmd.MarkPersistentFlagRequired("height-map"); err != nil {
+		panic(err)
+	}
+	LevelCmd.PersistentFlags().Float64VarP(&levelMaxSegmentLength, "max-segment-length", "s", defaultLevelMaxSegmentLength, "Maximum XY length of each feed rate motion segment; 0 disables splitting")
+
+	AddOutputFlags(LevelCmd)
+	RootCmd.AddCommand(LevelCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		levelHeightMapPath = defaultLevelHeightMapPath
+		levelMaxSegmentLength = defaultLevelMaxSegmentLength
+	})
+}
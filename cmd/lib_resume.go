@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var resumeLine uint
+var defaultResumeLine = gcode.DefaultResumeOptions.Line
+
+var resumeSafeZ float64
+var defaultResumeSafeZ = gcode.DefaultResumeOptions.SafeZ
+
+var resumeSpindleDwell float64
+var defaultResumeSpindleDwell = gcode.DefaultResumeOptions.SpindleDwell
+
+var resumePlungeFeedRate float64
+var defaultResumePlungeFeedRate = gcode.DefaultResumeOptions.PlungeFeedRate
+
+// AddResumeFlags adds flags to resume a program at a given line.
+func AddResumeFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().UintVarP(&resumeLine, "start-at-line", "", defaultResumeLine, "Start the program at this line: previous lines are not executed, but their modal state and position are restored by a preamble")
+	cmd.PersistentFlags().Float64VarP(&resumeSafeZ, "safe-z", "", defaultResumeSafeZ, "When starting at a line, work Z coordinate in millimeters to retract to before moving to the start position")
+	cmd.PersistentFlags().Float64VarP(&resumeSpindleDwell, "spindle-dwell", "", defaultResumeSpindleDwell, "When starting at a line, seconds to wait for the spindle to reach its speed")
+	cmd.PersistentFlags().Float64VarP(&resumePlungeFeedRate, "plunge-feed-rate", "", defaultResumePlungeFeedRate, "When starting at a line, feed rate in millimeters per minute to plunge to the start position, or 0 to use the program feed rate")
+}
+
+// GetResumeOptions returns gcode.ResumeOptions, as set by AddResumeFlags.
+func GetResumeOptions() gcode.ResumeOptions {
+	return gcode.ResumeOptions{
+		Line:           resumeLine,
+		SafeZ:          resumeSafeZ,
+		SpindleDwell:   resumeSpindleDwell,
+		PlungeFeedRate: resumePlungeFeedRate,
+	}
+}
+
+func init() {
+	resetFlagsFns = append(resetFlagsFns, func() {
+		resumeLine = defaultResumeLine
+		resumeSafeZ = defaultResumeSafeZ
+		resumeSpindleDwell = defaultResumeSpindleDwell
+		resumePlungeFeedRate = defaultResumePlungeFeedRate
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+	grblMod "github.com/fornellas/cgs/grbl"
+)
+
+var settingsPath string
+var defaultSettingsPath = ""
+
+// AddSettingsFlags adds flags to get Grbl settings, either from a file or from Grbl.
+func AddSettingsFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&settingsPath, "settings", "", defaultSettingsPath, "Path to Grbl settings saved with \"settings save\"; if unset, read settings from Grbl via --port-name or --address")
+	AddPortFlags(cmd)
+}
+
+// readSettingsFile reads settings and G-code parameters from a file saved with "settings save".
+func readSettingsFile(path string) (settings grblMod.Settings, parameters gcode.MachineParameters, position *gcode.Vector, err error) {
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, parameters, nil, err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+
+	settings, err = grblMod.ReadSettings(f)
+	if err != nil {
+		return nil, parameters, nil, err
+	}
+
+	if _, err = f.Seek(0, 0); err != nil {
+		return nil, parameters, nil, err
+	}
+	// G-code parameters are saved as G-code that restores them.
+	blocks, err := gcode.NewParser(f).Blocks()
+	if err != nil {
+		return nil, parameters, nil, err
+	}
+	machine := gcode.NewMachine(gcode.MachineParameters{}, gcode.Vector{})
+	for _, block := range blocks {
+		if _, err = machine.Execute(block); err != nil {
+			return nil, parameters, nil, err
+		}
+	}
+	return settings, machine.Parameters, nil, nil
+}
+
+// readSettingsGrbl reads settings, G-code parameters and the machine position from Grbl.
+func readSettingsGrbl(ctx context.Context) (settings grblMod.Settings, parameters gcode.MachineParameters, position *gcode.Vector, err error) {
+	openPortFn, err := GetOpenPortFn()
+	if err != nil {
+		return nil, parameters, nil, err
+	}
+
+	grbl := grblMod.NewGrbl(openPortFn)
+	pushMessageCh, err := grbl.Connect(ctx)
+	if err != nil {
+		return nil, parameters, nil, err
+	}
+	defer func() { err = errors.Join(err, grbl.Disconnect(ctx)) }()
+
+	for _, fn := range []func(context.Context) error{
+		grbl.SendGrblCommandViewGrblSettings,
+		grbl.SendGrblCommandViewGcodeParameters,
+	} {
+		sendCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+		err = fn(sendCtx)
+		cancel()
+		if err != nil {
+			return nil, parameters, nil, err
+		}
+	}
+	if err = grbl.SendRealTimeCommand(grblMod.RealTimeCommandStatusReportQuery); err != nil {
+		return nil, parameters, nil, err
+	}
+
+	// Settings arrive before the response message, but the status report is asynchronous.
+	settings = grblMod.Settings{}
+	timeout := time.After(1 * time.Second)
+	for {
+		select {
+		case msg, ok := <-pushMessageCh:
+			if !ok {
+				return nil, parameters, nil, fmt.Errorf("push message channel closed unexpectedly")
+			}
+			switch pushMessage := msg.(type) {
+			case *grblMod.SettingPushMessage:
+				settings.Update(pushMessage)
+			case *grblMod.StatusReportPushMessage:
+				if coordinates := pushMessage.GetMachineCoordinates(grbl); coordinates != nil {
+					position = &gcode.Vector{X: coordinates.X, Y: coordinates.Y, Z: coordinates.Z}
+				}
+				return settings, grbl.GetLastGcodeParameters().MachineParameters(), position, nil
+			}
+		case <-timeout:
+			return nil, parameters, nil, fmt.Errorf("timeout waiting for status report")
+		}
+	}
+}
+
+// readSettingsConnectedGrbl reads settings, G-code parameters and the machine position from a
+// connected Grbl, whose push messages are read elsewhere.
+func readSettingsConnectedGrbl(ctx context.Context, grbl *grblMod.Grbl) (settings grblMod.Settings, parameters gcode.MachineParameters, position *gcode.Vector, err error) {
+	for _, fn := range []func(context.Context) error{
+		grbl.SendGrblCommandViewGrblSettings,
+		grbl.SendGrblCommandViewGcodeParameters,
+	} {
+		sendCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+		err = fn(sendCtx)
+		cancel()
+		if err != nil {
+			return nil, parameters, nil, err
+		}
+	}
+
+	// Settings and parameters arrive before the response message, but the status report is
+	// asynchronous.
+	previous := grbl.GetLastStatusReport()
+	if err = grbl.SendRealTimeCommand(grblMod.RealTimeCommandStatusReportQuery); err != nil {
+		return nil, parameters, nil, err
+	}
+	timeout := time.After(1 * time.Second)
+	for {
+		if statusReport := grbl.GetLastStatusReport(); statusReport != nil && statusReport != previous {
+			if coordinates := statusReport.GetMachineCoordinates(grbl); coordinates != nil {
+				position = &gcode.Vector{X: coordinates.X, Y: coordinates.Y, Z: coordinates.Z}
+			}
+			return grbl.GetLastSettings(), grbl.GetLastGcodeParameters().MachineParameters(), position, nil
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-timeout:
+			return nil, parameters, nil, fmt.Errorf("timeout waiting for status report")
+		case <-ctx.Done():
+			return nil, parameters, nil, ctx.Err()
+		}
+	}
+}
+
+// GetSettings returns Grbl settings, G-code parameters and the machine position, as set by
+// AddSettingsFlags. The machine position is only known when reading from Grbl.
+func GetSettings(ctx context.Context) (grblMod.Settings, gcode.MachineParameters, *gcode.Vector, error) {
+	if settingsPath != "" {
+		log.MustLogger(ctx).Info("Reading settings", "path", settingsPath)
+		return readSettingsFile(settingsPath)
+	}
+	log.MustLogger(ctx).Info("Reading settings from Grbl")
+	return readSettingsGrbl(ctx)
+}
+
+func init() {
+	resetFlagsFns = append(resetFlagsFns, func() {
+		settingsPath = defaultSettingsPath
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	grblMod "github.com/fornellas/cgs/grbl"
+)
+
+var toolChangeMacroPath string
+var defaultToolChangeMacroPath = ""
+
+var toolLengthMode string
+var defaultToolLengthMode = grblMod.ToolLengthModeOffset.String()
+
+// AddToolChangeFlags adds flags to configure tool changes (M6).
+func AddToolChangeFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVarP(&toolChangeMacroPath, "tool-change-macro", "", defaultToolChangeMacroPath, fmt.Sprintf(
+		"Path to tool change (M6) macro: one command per line, %q replaced by the tool number, or directives: \"%s message\" waits for operator confirmation, \"%s command\" probes the reference tool length (once), \"%s command\" probes the new tool length and applies it",
+		grblMod.ToolChangeMacroToolPlaceholder,
+		grblMod.ToolChangeDirectiveWait,
+		grblMod.ToolChangeDirectiveReference,
+		grblMod.ToolChangeDirectiveProbe,
+	))
+	cmd.PersistentFlags().StringVarP(&toolLengthMode, "tool-length-mode", "", defaultToolLengthMode, fmt.Sprintf(
+		"How to apply measured tool length: %q sets a tool length offset (G43.1), %q sets the Z of the active coordinate system (G10 L2)",
+		grblMod.ToolLengthModeOffset, grblMod.ToolLengthModeZero,
+	))
+}
+
+// GetToolChangeMacro returns the tool change macro and tool length mode, as set by
+// AddToolChangeFlags. The macro is nil when not set.
+func GetToolChangeMacro() (macro []string, mode grblMod.ToolLengthMode, err error) {
+	mode, err = grblMod.NewToolLengthMode(toolLengthMode)
+	if err != nil {
+		return nil, mode, err
+	}
+	if toolChangeMacroPath == "" {
+		return nil, mode, nil
+	}
+
+	var f *os.File
+	f, err = os.Open(toolChangeMacroPath)
+	if err != nil {
+		return nil, mode, err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+
+	macro, err = grblMod.ReadToolChangeMacro(f)
+	if err != nil {
+		return nil, mode, fmt.Errorf("%s: %w", toolChangeMacroPath, err)
+	}
+	return macro, mode, nil
+}
+
+// newConfirmFn returns a function that shows a message to the operator at w, and waits for enter to
+// be pressed at r. It must be created once per command run, and shared by all confirmations: lines
+// are read from r by a single goroutine at a time, and a read pending from a cancelled confirmation
+// is used by the next one.
+func newConfirmFn(r io.Reader, w io.Writer) func(ctx context.Context, message string) error {
+	reader := bufio.NewReader(r)
+	var mu sync.Mutex
+	var readCh chan error
+	return func(ctx context.Context, message string) error {
+		if _, err := fmt.Fprintf(w, "%s\nPress enter to continue...", message); err != nil {
+			return err
+		}
+		mu.Lock()
+		if readCh == nil {
+			readCh = make(chan error, 1)
+			go func(readCh chan<- error) {
+				_, err := reader.ReadString('\n')
+				readCh <- err
+			}(readCh)
+		}
+		pendingReadCh := readCh
+		mu.Unlock()
+		select {
+		case err := <-pendingReadCh:
+			mu.Lock()
+			readCh = nil
+			mu.Unlock()
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// GetToolChangeFn returns a grblMod.ToolChangeFn for given Grbl, as set by AddToolChangeFlags,
+// which asks for operator confirmation with confirmFn, from newConfirmFn. Returns nil when no macro
+// is set.
+func GetToolChangeFn(
+	grbl *grblMod.Grbl,
+	confirmFn func(ctx context.Context, message string) error,
+) (grblMod.ToolChangeFn, error) {
+	macro, mode, err := GetToolChangeMacro()
+	if err != nil {
+		return nil, err
+	}
+	if macro == nil {
+		return nil, nil
+	}
+	return grblMod.NewToolChange(grbl, macro, mode, confirmFn).Run, nil
+}
+
+func init() {
+	resetFlagsFns = append(resetFlagsFns, func() {
+		toolChangeMacroPath = defaultToolChangeMacroPath
+		toolLengthMode = defaultToolLengthMode
+	})
+}
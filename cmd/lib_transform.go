@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+// WriteTransform writes all lines produced by given transform to w.
+func WriteTransform(w io.Writer, transform gcode.Transform) error {
+	for {
+		line, err := transform.Next()
+		if err != nil {
+			return err
+		}
+		if line == nil {
+			return nil
+		}
+		n, err := io.WriteString(w, *line)
+		if err != nil {
+			return err
+		}
+		if n != len(*line) {
+			return fmt.Errorf("short write")
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var LinearizeCmd = &cobra.Command{
+	Use:   "linearize path",
+	Short: "Read g-code from given path and convert G2/G3 arcs to G1 segments.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"tolerance", linearizeTolerance,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		parser := gcode.NewParser(f)
+		if err := WriteTransform(w, gcode.NewLinearize(parser, linearizeTolerance)); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var linearizeTolerance float64
+var defaultLinearizeTolerance float64 = 0.002
+
+func init() {
+	LinearizeCmd.PersistentFlags().Float64VarP(&linearizeTolerance, "tolerance", "t", defaultLinearizeTolerance, "Maximum distance in millimeters between arcs and their G1 segments (same as Grbl's $12 default)")
+
+	AddOutputFlags(LinearizeCmd)
+	RootCmd.AddCommand(LinearizeCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		linearizeTolerance = defaultLinearizeTolerance
+	})
+}
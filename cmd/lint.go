@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+	grblMod "github.com/fornellas/cgs/grbl"
+)
+
+var ErrLintFindings = errors.New("program has errors that Grbl would report")
+
+var LintCmd = &cobra.Command{
+	Use:   "lint path",
+	Short: "Check g-code at given path for problems that Grbl would report as errors, such as unsupported commands, modal group violations or undefined feed rate.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		findings, err := gcode.Lint(gcode.NewParser(f))
+		if err != nil {
+			return err
+		}
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		for _, finding := range findings {
+			if _, err := fmt.Fprintf(w, "%s: %s (%s)\n", path, finding, grblMod.ErrResponseMessage(finding.Code).Error()); err != nil {
+				return err
+			}
+		}
+		if len(findings) > 0 {
+			return fmt.Errorf("%w: %d finding(s)", ErrLintFindings, len(findings))
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+func init() {
+	AddOutputFlags(LintCmd)
+	RootCmd.AddCommand(LintCmd)
+}
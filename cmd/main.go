@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"log/slog"
 	"os"
 	"runtime/debug"
@@ -13,8 +14,22 @@ import (
 // This is to be used in place of os.Exit() to aid writing test assertions on exit code.
 var Exit func(int) = func(code int) { os.Exit(code) }
 
+// ExitCodeError is an error that sets the exit code when returned to GetRunFn.
+type ExitCodeError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitCodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitCodeError) Unwrap() error {
+	return e.Err
+}
+
 // GetRunFn returns a function suitable for usage with cobra.Command.Run. It runs fn, and if it
-// errors, the error is logged then Exit(1) is called.
+// errors, the error is logged then Exit(1) is called, or Exit with the code of ExitCodeError.
 func GetRunFn(fn func(cmd *cobra.Command, args []string) error) func(cmd *cobra.Command, args []string) {
 	return func(cmd *cobra.Command, args []string) {
 		logger := log.MustLogger(cmd.Context())
@@ -29,7 +44,12 @@ func GetRunFn(fn func(cmd *cobra.Command, args []string) error) func(cmd *cobra.
 
 		if err := fn(cmd, args); err != nil {
 			logger.Error(err.Error())
-			Exit(1)
+			code := 1
+			var exitCodeErr *ExitCodeError
+			if errors.As(err, &exitCodeErr) {
+				code = exitCodeErr.Code
+			}
+			Exit(code)
 		}
 
 		logger.Info("Success")
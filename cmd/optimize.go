@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var OptimizeCmd = &cobra.Command{
+	Use:   "optimize path",
+	Short: "Read g-code from given path and reorder plunge-cut-retract units, such as drill holes, to minimize rapid travel. The distance saved is reported to stderr.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		optimizer := gcode.NewOptimizer(gcode.NewParser(f))
+		if err := WriteTransform(w, optimizer); err != nil {
+			return err
+		}
+
+		original, optimized := optimizer.TravelDistance()
+		saved := original - optimized
+		percent := 0.0
+		if original > 0 {
+			percent = saved / original * 100
+		}
+		if _, err := fmt.Fprintf(
+			cmd.ErrOrStderr(), "Rapid travel: %.1fmm -> %.1fmm, saved %.1fmm (%.1f%%)\n",
+			original, optimized, saved, percent,
+		); err != nil {
+			return err
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+func init() {
+	AddOutputFlags(OptimizeCmd)
+	RootCmd.AddCommand(OptimizeCmd)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+	grblMod "github.com/fornellas/cgs/grbl"
+)
+
+var PlotCmd = &cobra.Command{
+	Use:   "plot path",
+	Short: "Read g-code from given path and plot its XY toolpath as SVG, with cutting motion colored by Z and dashed rapids.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"grid", plotGrid,
+			"height-map", plotHeightMapPath,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		options := gcode.DefaultPlotOptions
+		options.Grid = plotGrid
+		if plotHeightMapPath != "" {
+			heightMapBytes, err := os.ReadFile(plotHeightMapPath)
+			if err != nil {
+				return err
+			}
+			var heightMap grblMod.HeightMap
+			if err := json.Unmarshal(heightMapBytes, &heightMap); err != nil {
+				return err
+			}
+			options.HeightMap = &heightMap
+		}
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		if err := gcode.Plot(w, gcode.NewParser(f), gcode.NewMachineAtUnknownPosition(gcode.MachineParameters{}), options); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var plotGrid float64
+var defaultPlotGrid = gcode.DefaultPlotOptions.Grid
+
+var plotHeightMapPath string
+var defaultPlotHeightMapPath = ""
+
+func init() {
+	PlotCmd.PersistentFlags().Float64VarP(&plotGrid, "grid", "g", defaultPlotGrid, "Grid spacing in millimeters; 0 disables the grid")
+	PlotCmd.PersistentFlags().StringVarP(&plotHeightMapPath, "height-map", "m", defaultPlotHeightMapPath, "Path to height map saved from the TUI, to overlay")
+
+	AddOutputFlags(PlotCmd)
+	RootCmd.AddCommand(PlotCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		plotGrid = defaultPlotGrid
+		plotHeightMapPath = defaultPlotHeightMapPath
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var ResumeCmd = &cobra.Command{
+	Use:   "resume path",
+	Short: "Read g-code from given path and write it starting at a given line, with a preamble that restores the modal state and position at that line: units, plane, coordinate system, spindle, coolant, then retracts to a safe Z, moves to XY and plunges at feed rate.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+		options := GetResumeOptions()
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"line", options.Line,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		if err := WriteTransform(w, gcode.NewResume(gcode.NewParser(f), options)); err != nil {
+			return err
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+func init() {
+	AddResumeFlags(ResumeCmd)
+	AddOutputFlags(ResumeCmd)
+	RootCmd.AddCommand(ResumeCmd)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"io"
 	"math"
 	"os"
@@ -47,25 +46,11 @@ var RotateCmd = &cobra.Command{
 
 		parser := gcode.NewParser(f)
 		radians := rotateDegrees * math.Pi / 180.0
-		rotateXY := gcode.NewRotateXY(parser, rotateX, rotateY, radians)
-		for {
-			line, err := rotateXY.Next()
-			if err != nil {
-				return err
-			}
-			if line == nil {
-				logger.Info("Complete")
-				return nil
-			}
-			var n int
-			n, err = fmt.Fprintln(w, *line)
-			if err != nil {
-				return err
-			}
-			if n != len(*line)+1 {
-				return fmt.Errorf("%s: short write", outputValue)
-			}
+		if err := WriteTransform(w, gcode.NewRotateXY(parser, rotateX, rotateY, radians)); err != nil {
+			return err
 		}
+		logger.Info("Complete")
+		return nil
 	}),
 }
 
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+var SimplifyCmd = &cobra.Command{
+	Use:   "simplify path",
+	Short: "Read g-code from given path and simplify it: merge collinear G1 segments within a tolerance, optionally fit G2/G3 arcs, and remove zero-length moves and redundant modal words.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"tolerance", simplifyTolerance,
+			"arcs", simplifyArcs,
+			"remove-zero-length-moves", simplifyRemoveZeroLengthMoves,
+			"remove-redundant-modal-words", simplifyRemoveRedundantModalWords,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		simplify := gcode.NewSimplify(gcode.NewParser(f), gcode.SimplifyOptions{
+			Tolerance:                 simplifyTolerance,
+			Arcs:                      simplifyArcs,
+			RemoveZeroLengthMoves:     simplifyRemoveZeroLengthMoves,
+			RemoveRedundantModalWords: simplifyRemoveRedundantModalWords,
+		})
+		if err := WriteTransform(w, simplify); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+var simplifyTolerance float64
+var defaultSimplifyTolerance = gcode.DefaultSimplifyOptions.Tolerance
+
+var simplifyArcs bool
+var defaultSimplifyArcs = gcode.DefaultSimplifyOptions.Arcs
+
+var simplifyRemoveZeroLengthMoves bool
+var defaultSimplifyRemoveZeroLengthMoves = gcode.DefaultSimplifyOptions.RemoveZeroLengthMoves
+
+var simplifyRemoveRedundantModalWords bool
+var defaultSimplifyRemoveRedundantModalWords = gcode.DefaultSimplifyOptions.RemoveRedundantModalWords
+
+func init() {
+	SimplifyCmd.PersistentFlags().Float64VarP(&simplifyTolerance, "tolerance", "t", defaultSimplifyTolerance, "Maximum distance in millimeters between the original and the simplified toolpath")
+	SimplifyCmd.PersistentFlags().BoolVarP(&simplifyArcs, "arcs", "", defaultSimplifyArcs, "Fit G2/G3 arcs to G1 segments at the XY plane")
+	SimplifyCmd.PersistentFlags().BoolVarP(&simplifyRemoveZeroLengthMoves, "remove-zero-length-moves", "", defaultSimplifyRemoveZeroLengthMoves, "Remove G0/G1 moves that do not change the position")
+	SimplifyCmd.PersistentFlags().BoolVarP(&simplifyRemoveRedundantModalWords, "remove-redundant-modal-words", "", defaultSimplifyRemoveRedundantModalWords, "Remove G/M words for modes already active and F words for the current feed rate")
+
+	AddOutputFlags(SimplifyCmd)
+	RootCmd.AddCommand(SimplifyCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		simplifyTolerance = defaultSimplifyTolerance
+		simplifyArcs = defaultSimplifyArcs
+		simplifyRemoveZeroLengthMoves = defaultSimplifyRemoveZeroLengthMoves
+		simplifyRemoveRedundantModalWords = defaultSimplifyRemoveRedundantModalWords
+	})
+}
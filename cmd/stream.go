@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+	grblMod "github.com/fornellas/cgs/grbl"
+	iFmt "github.com/fornellas/cgs/internal/fmt"
+)
+
+var ErrAlarm = errors.New("grbl alarm")
+
+// Exit codes for the stream command. Other errors exit with 1.
+const (
+	StreamExitCodeAlarm         = 2
+	StreamExitCodeErrorResponse = 3
+	StreamExitCodeAborted       = 4
+)
+
+var streamPreJobCommands []string
+var defaultStreamPreJobCommands = []string{}
+
+var streamCheckMode bool
+var defaultStreamCheckMode = false
+
+var streamCheckLimits bool
+var defaultStreamCheckLimits = false
+
+var streamErrorPolicy string
+var defaultStreamErrorPolicy = grblMod.ResponseErrorPolicyAbort.String()
+
+var streamStatusInterval time.Duration
+var defaultStreamStatusInterval = 1 * time.Second
+
+// streamMonitor reads push messages, printing status reports and tracking alarms, while
+// periodically querying status reports.
+type streamMonitor struct {
+	grbl *grblMod.Grbl
+	w    io.Writer
+
+	syncCh chan chan struct{}
+	doneCh chan struct{}
+
+	mu       sync.Mutex
+	alarm    *grblMod.AlarmPushMessage
+	progress *grblMod.StreamProgress
+}
+
+func newStreamMonitor(grbl *grblMod.Grbl, w io.Writer) *streamMonitor {
+	return &streamMonitor{
+		grbl:   grbl,
+		w:      w,
+		syncCh: make(chan chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+func (m *streamMonitor) setProgress(progress grblMod.StreamProgress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.progress = &progress
+}
+
+func (m *streamMonitor) getAlarm() *grblMod.AlarmPushMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.alarm
+}
+
+// clearAlarm forgets previous alarms, such as the one cleared by unlocking ($X).
+func (m *streamMonitor) clearAlarm() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.alarm = nil
+}
+
+func (m *streamMonitor) printStatusReport(statusReport *grblMod.StatusReportPushMessage) {
+	state := string(statusReport.MachineState.State)
+	if subState := statusReport.MachineState.SubStateString(); subState != "" {
+		state += ":" + subState
+	}
+	text := state
+	if coordinates := statusReport.GetWorkCoordinates(m.grbl); coordinates != nil {
+		text += fmt.Sprintf(
+			" WPos:%s,%s,%s",
+			iFmt.SprintFloat(coordinates.X, 3),
+			iFmt.SprintFloat(coordinates.Y, 3),
+			iFmt.SprintFloat(coordinates.Z, 3),
+		)
+	}
+	if statusReport.LineNumber != nil {
+		text += fmt.Sprintf(" Ln:%d", *statusReport.LineNumber)
+	}
+	m.mu.Lock()
+	if m.progress != nil {
+		text += " " + m.progress.String()
+	}
+	m.mu.Unlock()
+	fmt.Fprintln(m.w, text)
+}
+
+func (m *streamMonitor) handlePushMessage(ctx context.Context, pushMessage grblMod.PushMessage) {
+	logger := log.MustLogger(ctx)
+	switch message := pushMessage.(type) {
+	case *grblMod.StatusReportPushMessage:
+		m.printStatusReport(message)
+	case *grblMod.AlarmPushMessage:
+		m.mu.Lock()
+		m.alarm = message
+		m.mu.Unlock()
+		logger.Error("Alarm", "message", message)
+	case *grblMod.FeedbackPushMessage:
+		logger.Info("Feedback", "message", message)
+	default:
+		logger.Debug("Push message", "message", message)
+	}
+}
+
+func (m *streamMonitor) run(ctx context.Context, pushMessageCh <-chan grblMod.PushMessage, interval time.Duration) {
+	defer close(m.doneCh)
+	logger := log.MustLogger(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.grbl.SendRealTimeCommand(grblMod.RealTimeCommandStatusReportQuery); err != nil {
+				logger.Warn("Failed to query status report", "err", err)
+			}
+		case syncedCh := <-m.syncCh:
+			for len(pushMessageCh) > 0 {
+				m.handlePushMessage(ctx, <-pushMessageCh)
+			}
+			close(syncedCh)
+		case pushMessage, ok := <-pushMessageCh:
+			if !ok {
+				return
+			}
+			m.handlePushMessage(ctx, pushMessage)
+		}
+	}
+}
+
+// sync waits for push messages already received from Grbl to be handled: push messages, such as
+// alarms, may be received before a response message, but handled after it.
+func (m *streamMonitor) sync() {
+	syncedCh := make(chan struct{})
+	select {
+	case m.syncCh <- syncedCh:
+	case <-m.doneCh:
+		return
+	}
+	select {
+	case <-syncedCh:
+	case <-m.doneCh:
+	}
+}
+
+// exitCodeError wraps err with the exit code for the stream command, once alarms received before
+// it are known.
+func (m *streamMonitor) exitCodeError(err error) error {
+	m.sync()
+	return streamExitCodeError(err, m.getAlarm())
+}
+
+// resumedProgram returns the program at path, starting at the line set by AddResumeFlags, and the
+// function that maps its lines to lines at path.
+func resumedProgram(path string) (program []byte, sourceLineFn func(uint) uint, err error) {
+	var f *os.File
+	f, err = os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+
+	resume := gcode.NewResume(gcode.NewParser(f), GetResumeOptions())
+	program, err = io.ReadAll(gcode.NewTransformReader(resume))
+	if err != nil {
+		return nil, nil, err
+	}
+	return program, resume.SourceLine, nil
+}
+
+// estimateProgram returns the estimate of program, using settings and the machine position from
+// Grbl, or nil if they are not available.
+func estimateProgram(ctx context.Context, grbl *grblMod.Grbl, program []byte) (*grblMod.ProgramEstimate, error) {
+	logger := log.MustLogger(ctx)
+	settings, parameters, position, err := readSettingsConnectedGrbl(ctx, grbl)
+	if err != nil {
+		logger.Warn("Failed to read settings, progress will not be estimated", "err", err)
+		return nil, nil
+	}
+	plannerSettings, err := settings.PlannerSettings()
+	if err != nil {
+		logger.Warn("Failed to read planner settings, progress will not be estimated", "err", err)
+		return nil, nil
+	}
+	machinePosition := gcode.Vector{}
+	if position != nil {
+		machinePosition = *position
+	}
+	return grblMod.NewProgramEstimate(bytes.NewReader(program), plannerSettings, gcode.NewMachine(parameters, machinePosition))
+}
+
+// streamExitCodeError wraps err with the exit code for the stream command.
+func streamExitCodeError(err error, alarm *grblMod.AlarmPushMessage) error {
+	var streamAlarmErr *grblMod.StreamAlarmError
+	if errors.As(err, &streamAlarmErr) {
+		return &ExitCodeError{Code: StreamExitCodeAlarm, Err: fmt.Errorf("%w: %w", ErrAlarm, err)}
+	}
+	if alarm != nil {
+		return &ExitCodeError{Code: StreamExitCodeAlarm, Err: errors.Join(fmt.Errorf("%w: %s", ErrAlarm, alarm), err)}
+	}
+	if errors.Is(err, grblMod.ErrStreamAborted) {
+		return &ExitCodeError{Code: StreamExitCodeAborted, Err: err}
+	}
+	var errResponseMessage grblMod.ErrResponseMessage
+	if errors.As(err, &errResponseMessage) {
+		return &ExitCodeError{Code: StreamExitCodeErrorResponse, Err: err}
+	}
+	return err
+}
+
+var StreamCmd = &cobra.Command{
+	Use:   "stream path",
+	Short: "Open Grbl serial connection and stream the g-code at given path, printing status reports. Interrupt (Ctrl+C) aborts safely: feed hold, then soft reset once stopped. Exit codes: 2 on alarm, 3 on error response, 4 when aborted, 1 for other errors.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"port-name", portName,
+			"address", address,
+			"pre-job-command", streamPreJobCommands,
+			"check-mode", streamCheckMode,
+			"check-limits", streamCheckLimits,
+			"start-at-line", resumeLine,
+			"on-error", streamErrorPolicy,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		errorPolicy, err := grblMod.NewResponseErrorPolicy(streamErrorPolicy)
+		if err != nil {
+			return err
+		}
+
+		program, sourceLineFn, err := resumedProgram(path)
+		if err != nil {
+			return err
+		}
+
+		openPortFn, err := GetOpenPortFn()
+		if err != nil {
+			return err
+		}
+
+		grbl := grblMod.NewGrbl(openPortFn)
+		confirmFn := newConfirmFn(cmd.InOrStdin(), cmd.OutOrStdout())
+		toolChangeFn, err := GetToolChangeFn(grbl, confirmFn)
+		if err != nil {
+			return err
+		}
+
+		pushMessageCh, err := grbl.Connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, grbl.Disconnect(ctx)) }()
+
+		monitor := newStreamMonitor(grbl, cmd.OutOrStdout())
+		monitorCtx, monitorCancel := context.WithCancel(ctx)
+		defer monitorCancel()
+		go monitor.run(monitorCtx, pushMessageCh, streamStatusInterval)
+
+		for _, command := range streamPreJobCommands {
+			logger.Info("Sending pre-job command", "command", command)
+			if err := grbl.SendCommand(ctx, command); err != nil {
+				return monitor.exitCodeError(fmt.Errorf("%s: %w", command, err))
+			}
+		}
+		monitor.clearAlarm()
+
+		if streamCheckLimits {
+			if err := CheckLimitsGate(ctx, grbl, bytes.NewReader(program)); err != nil {
+				return err
+			}
+		}
+
+		estimate, err := estimateProgram(ctx, grbl, program)
+		if err != nil {
+			return err
+		}
+
+		if streamCheckMode {
+			logger.Info("Enabling check mode")
+			if err := grbl.SendGrblCommandCheckGcodeMode(ctx); err != nil {
+				return monitor.exitCodeError(err)
+			}
+		}
+
+		// Interrupt aborts safely, a second one cancels streaming right away.
+		streamCtx, streamCancel := context.WithCancel(ctx)
+		defer streamCancel()
+		controller := grblMod.NewStreamController(grbl)
+		signalCh := make(chan os.Signal, 1)
+		signal.Notify(signalCh, os.Interrupt)
+		defer signal.Stop(signalCh)
+		abortDoneCh := make(chan error, 1)
+		go func() {
+			select {
+			case <-signalCh:
+			case <-streamCtx.Done():
+				return
+			}
+			logger.Warn("Interrupted, aborting: interrupt again to stop right away")
+			go func() {
+				select {
+				case <-signalCh:
+					streamCancel()
+				case <-streamCtx.Done():
+				}
+			}()
+			report, err := controller.Abort(streamCtx)
+			if report != nil {
+				attrs := []any{"last-queued-line", report.LastQueuedLine}
+				if report.LineNumber != nil {
+					attrs = append(attrs, "line-number", *report.LineNumber)
+				}
+				logger.Warn("Aborted", attrs...)
+			}
+			abortDoneCh <- err
+		}()
+
+		streamErr := grbl.StreamProgram(streamCtx, bytes.NewReader(program), grblMod.StreamOptions{
+			ToolChangeFn: toolChangeFn,
+			ErrorPolicy:  errorPolicy,
+			ConfirmFn:    confirmFn,
+			Estimate:     estimate,
+			ProgressFn:   monitor.setProgress,
+			Controller:   controller,
+			SourceLineFn: sourceLineFn,
+		})
+		if errors.Is(streamErr, grblMod.ErrStreamAborted) {
+			streamErr = errors.Join(streamErr, <-abortDoneCh)
+		} else if streamCheckMode {
+			logger.Info("Disabling check mode")
+			streamErr = errors.Join(streamErr, grbl.SendGrblCommandCheckGcodeMode(ctx))
+		}
+		if streamErr != nil {
+			return monitor.exitCodeError(streamErr)
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+func init() {
+	StreamCmd.PersistentFlags().StringSliceVarP(&streamPreJobCommands, "pre-job-command", "", defaultStreamPreJobCommands, "Command to send before streaming, such as $H to home or $X to unlock; can be repeated")
+	StreamCmd.PersistentFlags().BoolVarP(&streamCheckMode, "check-mode", "", defaultStreamCheckMode, "Stream in check mode ($C): Grbl parses the program without motion")
+	StreamCmd.PersistentFlags().BoolVarP(&streamCheckLimits, "check-limits", "", defaultStreamCheckLimits, "Check whether the program moves outside the machine travel limits before streaming")
+	StreamCmd.PersistentFlags().StringVarP(&streamErrorPolicy, "on-error", "", defaultStreamErrorPolicy, fmt.Sprintf(
+		"What to do when Grbl responds with an error: %q feed holds and stops, %q feed holds and waits for confirmation, %q keeps streaming",
+		grblMod.ResponseErrorPolicyAbort, grblMod.ResponseErrorPolicyPause, grblMod.ResponseErrorPolicyContinue,
+	))
+	StreamCmd.PersistentFlags().DurationVarP(&streamStatusInterval, "status-interval", "", defaultStreamStatusInterval, "Interval to query and print status reports")
+
+	AddCheckLimitsFlags(StreamCmd)
+	AddResumeFlags(StreamCmd)
+	AddToolChangeFlags(StreamCmd)
+	RootCmd.AddCommand(StreamCmd)
+
+	resetFlagsFns = append(resetFlagsFns, func() {
+		streamPreJobCommands = defaultStreamPreJobCommands
+		streamCheckMode = defaultStreamCheckMode
+		streamCheckLimits = defaultStreamCheckLimits
+		streamErrorPolicy = defaultStreamErrorPolicy
+		streamStatusInterval = defaultStreamStatusInterval
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	grblMod "github.com/fornellas/cgs/grbl"
+)
+
+var ToolChangeCmd = &cobra.Command{
+	Use:   "tool-change tool",
+	Short: "Open Grbl serial connection and change to given tool number, running the tool change macro.",
+	Args:  cobra.ExactArgs(1),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"tool", args[0],
+			"port-name", portName,
+			"address", address,
+			"tool-change-macro", toolChangeMacroPath,
+			"tool-length-mode", toolLengthMode,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		tool, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return fmt.Errorf("invalid tool number: %w", err)
+		}
+
+		openPortFn, err := GetOpenPortFn()
+		if err != nil {
+			return err
+		}
+
+		grbl := grblMod.NewGrbl(openPortFn)
+		toolChangeFn, err := GetToolChangeFn(grbl, newConfirmFn(cmd.InOrStdin(), cmd.OutOrStdout()))
+		if err != nil {
+			return err
+		}
+		if toolChangeFn == nil {
+			return fmt.Errorf("--tool-change-macro must be set")
+		}
+
+		pushMessageCh, err := grbl.Connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, grbl.Disconnect(ctx)) }()
+
+		pushMessageCtx, pushMessageCancel := context.WithCancel(ctx)
+		defer pushMessageCancel()
+		go func() {
+			for {
+				select {
+				case <-pushMessageCtx.Done():
+					return
+				case pushMessage, ok := <-pushMessageCh:
+					if !ok {
+						return
+					}
+					logger.Debug("Push message", "message", pushMessage)
+				}
+			}
+		}()
+
+		if err := toolChangeFn(ctx, grbl, &tool); err != nil {
+			return err
+		}
+
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+func init() {
+	AddPortFlags(ToolChangeCmd)
+	AddToolChangeFlags(ToolChangeCmd)
+
+	RootCmd.AddCommand(ToolChangeCmd)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/spf13/cobra"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+// parseTransformOperation parses an operation given as name=values, with comma separated values.
+//
+//gocyclo:ignore
+func parseTransformOperation(operation string) (gcode.Matrix, error) {
+	name, valuesStr, _ := strings.Cut(operation, "=")
+	var values []float64
+	if valuesStr != "" {
+		for valueStr := range strings.SplitSeq(valuesStr, ",") {
+			value, err := strconv.ParseFloat(valueStr, 64)
+			if err != nil {
+				return gcode.Matrix{}, fmt.Errorf("%s: invalid value: %w", operation, err)
+			}
+			values = append(values, value)
+		}
+	}
+
+	switch name {
+	case "translate":
+		switch len(values) {
+		case 2:
+			return gcode.NewTranslateMatrix(values[0], values[1], 0), nil
+		case 3:
+			return gcode.NewTranslateMatrix(values[0], values[1], values[2]), nil
+		}
+	case "scale":
+		switch len(values) {
+		case 1:
+			return gcode.NewScaleMatrix(values[0], values[0], 1), nil
+		case 2:
+			return gcode.NewScaleMatrix(values[0], values[1], 1), nil
+		case 3:
+			return gcode.NewScaleMatrix(values[0], values[1], values[2]), nil
+		}
+	case "mirror-x":
+		switch len(values) {
+		case 0:
+			return gcode.NewMirrorXMatrix(0), nil
+		case 1:
+			return gcode.NewMirrorXMatrix(values[0]), nil
+		}
+	case "mirror-y":
+		switch len(values) {
+		case 0:
+			return gcode.NewMirrorYMatrix(0), nil
+		case 1:
+			return gcode.NewMirrorYMatrix(values[0]), nil
+		}
+	case "rotate":
+		switch len(values) {
+		case 1:
+			return gcode.NewRotateXYMatrix(0, 0, values[0]*math.Pi/180.0), nil
+		case 3:
+			return gcode.NewRotateXYMatrix(values[1], values[2], values[0]*math.Pi/180.0), nil
+		}
+	default:
+		return gcode.Matrix{}, fmt.Errorf("%s: unknown operation: %s", operation, name)
+	}
+	return gcode.Matrix{}, fmt.Errorf("%s: invalid number of values: %d", operation, len(values))
+}
+
+var TransformCmd = &cobra.Command{
+	Use:   "transform path operation...",
+	Short: "Read g-code from given path and transform work coordinates by a sequence of operations.",
+	Long: "Read g-code from given path and transform work coordinates by a sequence of operations, applied in the given order. Operations are:\n" +
+		"  translate=X,Y[,Z]\n" +
+		"  scale=S | scale=X,Y[,Z] (relative to origin)\n" +
+		"  mirror-x[=X] (flips X coordinates about X, 0 by default)\n" +
+		"  mirror-y[=Y] (flips Y coordinates about Y, 0 by default)\n" +
+		"  rotate=DEGREES[,X,Y] (counterclockwise, about X,Y, 0,0 by default)\n" +
+		"Translation values are in the units set at the start of the g-code (G21 millimeters by default).",
+	Args: cobra.MinimumNArgs(2),
+	Run: GetRunFn(func(cmd *cobra.Command, args []string) (err error) {
+		path := args[0]
+		operations := args[1:]
+
+		ctx, logger := log.MustWithAttrs(
+			cmd.Context(),
+			"path", path,
+			"operations", operations,
+			"output", outputValue,
+		)
+		cmd.SetContext(ctx)
+		logger.Info("Running")
+
+		matrix := gcode.NewIdentityMatrix()
+		for _, operation := range operations {
+			operationMatrix, err := parseTransformOperation(operation)
+			if err != nil {
+				return err
+			}
+			matrix = matrix.Then(operationMatrix)
+		}
+
+		var f *os.File
+		f, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, f.Close()) }()
+
+		var w io.WriteCloser
+		w, err = outputValue.WriterCloser()
+		if err != nil {
+			return err
+		}
+		defer func() { err = errors.Join(err, w.Close()) }()
+
+		parser := gcode.NewParser(f)
+		if err := WriteTransform(w, gcode.NewAffine(parser, matrix)); err != nil {
+			return err
+		}
+		logger.Info("Complete")
+		return nil
+	}),
+}
+
+func init() {
+	AddOutputFlags(TransformCmd)
+	RootCmd.AddCommand(TransformCmd)
+}
@@ -22,9 +22,16 @@ var TuiCmd = &cobra.Command{
 			"address", address,
 			"timeout", timeout,
 			"display-status-comms", displayStatusComms,
+			"tool-change-macro", toolChangeMacroPath,
+			"tool-length-mode", toolLengthMode,
 		)
 		cmd.SetContext(ctx)
 
+		toolChangeMacro, toolLengthMode, err := GetToolChangeMacro()
+		if err != nil {
+			return err
+		}
+
 		openPortFn, err := GetOpenPortFn()
 		if err != nil {
 			return err
@@ -35,6 +42,8 @@ var TuiCmd = &cobra.Command{
 		tui := tuiMod.NewTui(grbl, &tuiMod.TuiOptions{
 			DisplayStatusComms: displayStatusComms,
 			AppLogger:          logDebugFileLogger,
+			ToolChangeMacro:    toolChangeMacro,
+			ToolLengthMode:     toolLengthMode,
 		})
 
 		return tui.Run(ctx)
@@ -43,6 +52,7 @@ var TuiCmd = &cobra.Command{
 
 func init() {
 	AddPortFlags(TuiCmd)
+	AddToolChangeFlags(TuiCmd)
 
 	TuiCmd.Flags().BoolVar(
 		&displayStatusComms,
@@ -0,0 +1,336 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+)
+
+// Matrix is a 3D affine transformation matrix. Each row gives the X, Y and Z coefficients and the
+// translation for one of the X, Y and Z axis respectively.
+type Matrix [3][4]float64
+
+// NewIdentityMatrix creates a Matrix that does not change coordinates.
+func NewIdentityMatrix() Matrix {
+	return Matrix{
+		{1, 0, 0, 0},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+	}
+}
+
+// NewTranslateMatrix creates a Matrix that moves coordinates by x, y and z.
+func NewTranslateMatrix(x, y, z float64) Matrix {
+	return Matrix{
+		{1, 0, 0, x},
+		{0, 1, 0, y},
+		{0, 0, 1, z},
+	}
+}
+
+// NewScaleMatrix creates a Matrix that scales coordinates by x, y and z, relative to the origin.
+func NewScaleMatrix(x, y, z float64) Matrix {
+	return Matrix{
+		{x, 0, 0, 0},
+		{0, y, 0, 0},
+		{0, 0, z, 0},
+	}
+}
+
+// NewMirrorXMatrix creates a Matrix that mirrors X coordinates about the line at given x.
+func NewMirrorXMatrix(x float64) Matrix {
+	return Matrix{
+		{-1, 0, 0, 2 * x},
+		{0, 1, 0, 0},
+		{0, 0, 1, 0},
+	}
+}
+
+// NewMirrorYMatrix creates a Matrix that mirrors Y coordinates about the line at given y.
+func NewMirrorYMatrix(y float64) Matrix {
+	return Matrix{
+		{1, 0, 0, 0},
+		{0, -1, 0, 2 * y},
+		{0, 0, 1, 0},
+	}
+}
+
+// NewRotateXYMatrix creates a Matrix that rotates coordinates at the XY plane, with same parameters
+// as NewRotateXY.
+func NewRotateXYMatrix(cx, cy, radians float64) Matrix {
+	sin, cos := math.Sin(radians), math.Cos(radians)
+	return Matrix{
+		{cos, -sin, 0, cx - cx*cos + cy*sin},
+		{sin, cos, 0, cy - cx*sin - cy*cos},
+		{0, 0, 1, 0},
+	}
+}
+
+// Then returns a Matrix equivalent to applying m first, then n.
+func (m Matrix) Then(n Matrix) Matrix {
+	var r Matrix
+	for i := range 3 {
+		for j := range 4 {
+			for k := range 3 {
+				r[i][j] += n[i][k] * m[k][j]
+			}
+		}
+		r[i][3] += n[i][3]
+	}
+	return r
+}
+
+// Apply returns the transformed x, y and z coordinates.
+func (m Matrix) Apply(x, y, z float64) (float64, float64, float64) {
+	return m[0][0]*x + m[0][1]*y + m[0][2]*z + m[0][3],
+		m[1][0]*x + m[1][1]*y + m[1][2]*z + m[1][3],
+		m[2][0]*x + m[2][1]*y + m[2][2]*z + m[2][3]
+}
+
+// Affine applies an affine transformation Matrix to work coordinates. Machine coordinates are not
+// affected. Both distance modes and unit changes are supported, with incremental motion being
+// transformed as vectors. Arcs are supported as long as the matrix keeps them circular at their
+// plane: mirroring flips their direction (G2 / G3).
+type Affine struct {
+	parser          *Parser
+	matrix          Matrix
+	units           string
+	positionTracker *positionTracker
+}
+
+// NewAffine creates a new Affine. The matrix translation is in the units active when Affine is
+// created.
+func NewAffine(parser *Parser, matrix Matrix) *Affine {
+	return &Affine{
+		parser:          parser,
+		matrix:          matrix,
+		units:           parser.ModalGroup.Units.NormalizedString(),
+		positionTracker: newPositionTracker(),
+	}
+}
+
+// getMatrix returns the matrix with translation in the current units.
+func (a *Affine) getMatrix() Matrix {
+	m := a.matrix
+	units := a.parser.ModalGroup.Units.NormalizedString()
+	if units == a.units {
+		return m
+	}
+	factor := 25.4
+	if units == "G20" {
+		factor = 1 / 25.4
+	}
+	for i := range 3 {
+		m[i][3] *= factor
+	}
+	return m
+}
+
+// transformAxes transforms the XYZ words of block, either as a point or as a vector when
+// incremental. Axes absent from block are taken from start.
+func (a *Affine) transformAxes(block *Block, m Matrix, start position, incremental bool) error {
+	axes, err := getAxes(block)
+	if err != nil {
+		return err
+	}
+	given := [3]*float64{axes.x, axes.y, axes.z}
+	startValues := [3]*float64{start.x, start.y, start.z}
+
+	var values [3]*float64
+	for i := range 3 {
+		var transform bool
+		for j := range 3 {
+			if given[j] != nil && m[i][j] != 0 {
+				transform = true
+			}
+		}
+		if !transform {
+			continue
+		}
+		var v float64
+		if !incremental {
+			v = m[i][3]
+		}
+		for j := range 3 {
+			if m[i][j] == 0 {
+				continue
+			}
+			switch {
+			case given[j] != nil:
+				v += m[i][j] * *given[j]
+			case incremental:
+			case startValues[j] != nil:
+				v += m[i][j] * *startValues[j]
+			default:
+				return fmt.Errorf("%s: transformation requires %c position, which is unknown", block, axisLetters[j])
+			}
+		}
+		if given[i] != nil && *given[i] == v {
+			continue
+		}
+		values[i] = &v
+	}
+
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		if err := block.setArgumentNumber(axisLetters[i], *v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transformArc transforms the arc parameters of block: center offsets, radius and direction.
+//
+//gocyclo:ignore
+func (a *Affine) transformArc(block *Block, m Matrix, clockwise bool) error {
+	planeSelection := a.parser.ModalGroup.PlaneSelection
+	plane, ok := arcPlanes[planeSelection.NormalizedString()]
+	if !ok {
+		return fmt.Errorf("%s: unsupported plane selection: %s", block, planeSelection)
+	}
+	a0, a1, l := plane.axis0, plane.axis1, plane.linear
+	if m[a0][l] != 0 || m[a1][l] != 0 || m[l][a0] != 0 || m[l][a1] != 0 {
+		return fmt.Errorf("%s: transformation moves arc out of plane %s", block, planeSelection)
+	}
+	const epsilon = 1e-9
+	m00, m01, m10, m11 := m[a0][a0], m[a0][a1], m[a1][a0], m[a1][a1]
+	// Determinant at the arc plane: it is negative when the plane is mirrored, which flips the arc
+	// direction. Mirroring the linear axis only does not.
+	determinant := m00*m11 - m01*m10
+	rotation := math.Abs(m00-m11) < epsilon && math.Abs(m01+m10) < epsilon
+	reflection := math.Abs(m00+m11) < epsilon && math.Abs(m01-m10) < epsilon
+	if !rotation && !reflection {
+		return fmt.Errorf("%s: transformation does not keep arcs circular at plane %s", block, planeSelection)
+	}
+
+	offset0, err := block.GetArgumentNumber(plane.offset0)
+	if err != nil {
+		return err
+	}
+	offset1, err := block.GetArgumentNumber(plane.offset1)
+	if err != nil {
+		return err
+	}
+	if offset0 != nil || offset1 != nil {
+		var o0, o1 float64
+		if offset0 != nil {
+			o0 = *offset0
+		}
+		if offset1 != nil {
+			o1 = *offset1
+		}
+		if err := block.setArgumentNumber(plane.offset0, m00*o0+m01*o1); err != nil {
+			return err
+		}
+		if err := block.setArgumentNumber(plane.offset1, m10*o0+m11*o1); err != nil {
+			return err
+		}
+	}
+
+	r, err := block.GetArgumentNumber('R')
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		if scale := math.Sqrt(math.Abs(determinant)); scale != 1 {
+			if err := block.SetArgumentNumber('R', *r*scale); err != nil {
+				return err
+			}
+		}
+	}
+
+	if determinant < 0 {
+		arcWord := NewWord('G', 3)
+		if !clockwise {
+			arcWord = NewWord('G', 2)
+		}
+		var replaced bool
+		for i, w := range block.words {
+			switch w.NormalizedString() {
+			case "G2", "G3":
+				block.words[i] = arcWord
+				replaced = true
+			}
+		}
+		if !replaced {
+			block.words = append([]*Word{arcWord}, block.words...)
+		}
+	}
+	return nil
+}
+
+// affineBlock returns the blocks that replace the given block, or nil if it must be kept unchanged.
+//
+//gocyclo:ignore
+func (a *Affine) affineBlock(block *Block) ([]*Block, error) {
+	// Transformation happens after, as the tracked position is for the original coordinates.
+	start, motion, err := a.positionTracker.next(&a.parser.ModalGroup, block)
+	if err != nil {
+		return nil, err
+	}
+
+	if block.IsSystem() {
+		return nil, nil
+	}
+
+	m := a.getMatrix()
+	incremental := a.parser.ModalGroup.DistanceMode.NormalizedString() == "G91"
+	original := block.String()
+
+	for _, w := range block.Commands() {
+		switch w.NormalizedString() {
+		case "G53":
+			return nil, nil
+		case "G10", "G28", "G30":
+			axes, err := getAxes(block)
+			if err != nil {
+				return nil, err
+			}
+			if axes.x != nil || axes.y != nil || axes.z != nil {
+				return nil, fmt.Errorf("%s: transformation unsupported for %s with axis words", block, w)
+			}
+			return nil, nil
+		case "G92":
+			// Coordinates are always absolute, regardless of distance mode.
+			if err := a.transformAxes(block, m, start, false); err != nil {
+				return nil, err
+			}
+			if block.String() == original {
+				return nil, nil
+			}
+			return []*Block{block}, nil
+		}
+	}
+
+	switch a.parser.ModalGroup.Motion.NormalizedString() {
+	case "G0", "G1":
+	case "G2", "G3":
+		if motion {
+			if err := a.transformArc(block, m, a.parser.ModalGroup.Motion.NormalizedString() == "G2"); err != nil {
+				return nil, err
+			}
+		}
+	case "G38.2", "G38.3", "G38.4", "G38.5":
+		// Probing moves in a straight line as G1, but isn't tracked as motion.
+		motion = true
+	}
+	if !motion {
+		return nil, nil
+	}
+
+	if err := a.transformAxes(block, m, start, incremental); err != nil {
+		return nil, err
+	}
+	if block.String() == original {
+		return nil, nil
+	}
+	return []*Block{block}, nil
+}
+
+// Next returns the next line of G-code with coordinates transformed. Lines without motion are passed
+// through unchanged. Returns nil when the end of input is reached.
+func (a *Affine) Next() (*string, error) {
+	return blockTransformNext(a.parser, a.affineBlock)
+}
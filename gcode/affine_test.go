@@ -0,0 +1,109 @@
+package gcode
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatrix(t *testing.T) {
+	m := NewTranslateMatrix(1, 2, 3).
+		Then(NewScaleMatrix(2, 2, 1)).
+		Then(NewRotateXYMatrix(0, 0, math.Pi/2)).
+		Then(NewMirrorXMatrix(1))
+	x, y, z := m.Apply(1, 1, 1)
+	// Translate: 2, 3, 4; scale: 4, 6, 4; rotate: -6, 4, 4; mirror: 8, 4, 4.
+	require.InDelta(t, 8, x, 1e-9)
+	require.InDelta(t, 4, y, 1e-9)
+	require.InDelta(t, 4, z, 1e-9)
+
+	x, y, z = NewIdentityMatrix().Then(NewMirrorYMatrix(-1)).Apply(1, 1, 1)
+	require.Equal(t, []float64{1, -3, 1}, []float64{x, y, z})
+}
+
+func affine(gcode string, matrix Matrix) (string, error) {
+	return transformString(NewAffine(NewParser(strings.NewReader(gcode)), matrix))
+}
+
+func TestAffine(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		gcode         string
+		matrix        Matrix
+		expected      string
+		errorContains string
+	}{
+		{
+			name:     "translate",
+			gcode:    "(start)\nG0 Z5\nG0 X1 Y0\nG1 X2 F100\nG53 G0 Z0\n",
+			matrix:   NewTranslateMatrix(10, 20, 0),
+			expected: "(start)\nG0 Z5\nG0X11Y20\nG1X12F100\nG53 G0 Z0\n",
+		},
+		{
+			name:          "translate unknown position",
+			gcode:         "G0 X1\n",
+			matrix:        NewRotateXYMatrix(0, 0, math.Pi/2),
+			errorContains: "transformation requires Y position, which is unknown",
+		},
+		{
+			name:     "scale incremental",
+			gcode:    "G91\nG1 X1 Y2 Z3\n",
+			matrix:   NewTranslateMatrix(5, 5, 5).Then(NewScaleMatrix(2, 3, 1)),
+			expected: "G91\nG1X2Y6Z3\n",
+		},
+		{
+			name:     "mirror arc",
+			gcode:    "G0 X1 Y0\nG2 X0 Y-1 I-1 J0\nX-1 Y0 I0 J1\nG3 X1 Y0 R-1\n",
+			matrix:   NewMirrorXMatrix(0),
+			expected: "G0X-1Y0\nG3X0Y-1I1J0\nG3X1Y0I0J1\nG2X-1Y0R-1\n",
+		},
+		{
+			name:     "mirror arc single axis",
+			gcode:    "G0 X0 Y0\nG2 X10 Y0 I5 J0\n",
+			matrix:   NewMirrorYMatrix(0),
+			expected: "G0 X0 Y0\nG3X10Y0I5J0\n",
+		},
+		{
+			name:     "mirror arc linear axis",
+			gcode:    "G0 X0 Y0 Z1\nG2 X10 Y0 I5 J0\n",
+			matrix:   NewScaleMatrix(1, 1, -1),
+			expected: "G0X0Y0Z-1\nG2 X10 Y0 I5 J0\n",
+		},
+		{
+			name:     "scale arc",
+			gcode:    "G0 X1 Y0\nG2 X-1 Y0 R1\n",
+			matrix:   NewScaleMatrix(2, 2, 1),
+			expected: "G0X2Y0\nG2X-2Y0R2\n",
+		},
+		{
+			name:          "non uniform scale arc",
+			gcode:         "G0 X1 Y0\nG2 X-1 Y0 R1\n",
+			matrix:        NewScaleMatrix(2, 1, 1),
+			errorContains: "transformation does not keep arcs circular at plane G17",
+		},
+		{
+			name:     "units",
+			gcode:    "G0 X0 Y0\nG20\nG0 X1 Y1\n",
+			matrix:   NewTranslateMatrix(25.4, 0, 0),
+			expected: "G0X25.4Y0\nG20\nG0X2Y1\n",
+		},
+		{
+			name:     "coordinate offset",
+			gcode:    "G92 X0 Y0\n",
+			matrix:   NewTranslateMatrix(1, 2, 0),
+			expected: "G92X1Y2\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := affine(tc.gcode, tc.matrix)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gcode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// arcPlane maps the axes of an arc plane to XYZ (0, 1 and 2 respectively).
+type arcPlane struct {
+	// The first and second axis of the plane, and the linear (helix) axis.
+	axis0, axis1, linear int
+	// IJK offset letters for axis0 and axis1.
+	offset0, offset1 rune
+}
+
+var arcPlanes = map[string]arcPlane{
+	"G17": {axis0: 0, axis1: 1, linear: 2, offset0: 'I', offset1: 'J'},
+	"G18": {axis0: 2, axis1: 0, linear: 1, offset0: 'K', offset1: 'I'},
+	"G19": {axis0: 1, axis1: 2, linear: 0, offset0: 'J', offset1: 'K'},
+}
+
+// getArcCenterOffset returns the offset from start to the arc center at the plane, for the R form.
+// Same as Grbl's gc_execute_line.
+func getArcCenterOffset(x, y, r float64, clockwise bool) (float64, float64, error) {
+	if x == 0 && y == 0 {
+		return 0, 0, errors.New("arc radius form requires the end point to differ from the start point")
+	}
+	hx2DivD := 4.0*r*r - x*x - y*y
+	if hx2DivD < 0 {
+		return 0, 0, errors.New("arc radius is too small to reach the end point")
+	}
+	hx2DivD = -math.Sqrt(hx2DivD) / math.Hypot(x, y)
+	if !clockwise {
+		hx2DivD = -hx2DivD
+	}
+	// Negative R is the long arc, over 180 degrees.
+	if r < 0 {
+		hx2DivD = -hx2DivD
+	}
+	return 0.5 * (x - y*hx2DivD), 0.5 * (y + x*hx2DivD), nil
+}
+
+// arcGeometry is the geometry of an arc relative to its start.
+type arcGeometry struct {
+	// Offset from the start to the center, for the plane axes.
+	offset [2]float64
+	radius float64
+	// Angular travel in radians, negative when clockwise.
+	angularTravel float64
+}
+
+// getArcGeometry computes the geometry of the arc at block, given the end relative to the start.
+// unitsFactor converts millimeters to the block units.
+//
+//gocyclo:ignore
+func getArcGeometry(block *Block, plane arcPlane, end [3]float64, clockwise bool, unitsFactor float64) (arcGeometry, error) {
+	var geometry arcGeometry
+	var hasOffset bool
+	for i, letter := range []rune{plane.offset0, plane.offset1} {
+		v, err := block.GetArgumentNumber(letter)
+		if err != nil {
+			return geometry, err
+		}
+		if v != nil {
+			geometry.offset[i] = *v
+			hasOffset = true
+		}
+	}
+	r, err := block.GetArgumentNumber('R')
+	if err != nil {
+		return geometry, err
+	}
+
+	e0, e1 := end[plane.axis0], end[plane.axis1]
+	switch {
+	case r != nil:
+		geometry.offset[0], geometry.offset[1], err = getArcCenterOffset(e0, e1, *r, clockwise)
+		if err != nil {
+			return geometry, err
+		}
+		geometry.radius = math.Abs(*r)
+	case hasOffset:
+		geometry.radius = math.Hypot(geometry.offset[0], geometry.offset[1])
+		endRadius := math.Hypot(e0-geometry.offset[0], e1-geometry.offset[1])
+		// Same as Grbl's error 33: invalid target.
+		delta := math.Abs(endRadius - geometry.radius)
+		if delta > 0.005*unitsFactor && delta > 0.001*geometry.radius {
+			return geometry, fmt.Errorf("arc end point is not at the arc radius: start %.4f, end %.4f", geometry.radius, endRadius)
+		}
+	default:
+		return geometry, errors.New("arc requires IJK offsets or R")
+	}
+	if geometry.radius == 0 {
+		return geometry, errors.New("arc radius is zero")
+	}
+
+	// Same angular travel computation as Grbl's mc_arc.
+	rs0, rs1 := -geometry.offset[0], -geometry.offset[1]
+	rt0, rt1 := e0-geometry.offset[0], e1-geometry.offset[1]
+	geometry.angularTravel = math.Atan2(rs0*rt1-rs1*rt0, rs0*rt0+rs1*rt1)
+	const angularTravelEpsilon = 5e-7
+	if clockwise {
+		if geometry.angularTravel >= -angularTravelEpsilon {
+			geometry.angularTravel -= 2 * math.Pi
+		}
+	} else {
+		if geometry.angularTravel <= angularTravelEpsilon {
+			geometry.angularTravel += 2 * math.Pi
+		}
+	}
+
+	return geometry, nil
+}
+
+// segments returns the number of line segments required to approximate the arc within the chordal
+// tolerance. Grbl's mc_arc segment count may slightly exceed the tolerance, so the maximum segment
+// angle for the chordal error is used instead.
+func (g arcGeometry) segments(tolerance float64) int {
+	segments := 1
+	if tolerance > 0 && tolerance < g.radius {
+		segmentAngle := 2 * math.Acos(1-tolerance/g.radius)
+		segments = max(1, int(math.Ceil(math.Abs(g.angularTravel)/segmentAngle)))
+	}
+	return segments
+}
+
+// point returns the point of the arc relative to its start at t, from 0 (start) to 1 (end).
+func (g arcGeometry) point(plane arcPlane, end [3]float64, t float64) [3]float64 {
+	rs0, rs1 := -g.offset[0], -g.offset[1]
+	cos, sin := math.Cos(g.angularTravel*t), math.Sin(g.angularTravel*t)
+	var point [3]float64
+	point[plane.axis0] = g.offset[0] + rs0*cos - rs1*sin
+	point[plane.axis1] = g.offset[1] + rs0*sin + rs1*cos
+	point[plane.linear] = end[plane.linear] * t
+	return point
+}
@@ -0,0 +1,392 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+)
+
+// cannedCycleCommands are the canned cycles supported by CannedCycles.
+var cannedCycleCommands = map[string]bool{
+	"G73": true, // Drilling cycle with chip breaking
+	"G81": true, // Drilling cycle
+	"G82": true, // Drilling cycle, dwell
+	"G83": true, // Peck drilling cycle
+}
+
+// cannedCycleModalCommands are commands that may be at the same block as a canned cycle, as they
+// are applied before it.
+var cannedCycleModalCommands = map[string]bool{
+	"G17": true,
+	"G20": true,
+	"G21": true,
+	"G90": true,
+	"G91": true,
+	"G94": true,
+}
+
+// CannedCycles expands canned drilling cycles (G73, G81, G82 and G83), which Grbl does not support,
+// into G0, G1 and G4 blocks. It follows LinuxCNC semantics: R plane, return level (G98 / G99), peck
+// depth (Q), dwell (P), repetitions (L) and both distance modes are supported. While a cycle is
+// active, blocks with axis words repeat it at the new position (modal repetition), until it is
+// cancelled by G80 or another motion command. All other blocks are passed through unchanged.
+type CannedCycles struct {
+	parser          *Parser
+	positionTracker *positionTracker
+	// Active canned cycle, or nil.
+	cycle *Word
+	// Whether to retract to the R plane (G99) instead of the initial level (G98).
+	retractToR bool
+	// Initial level in millimeters, captured when the active cycle starts, or nil if unknown.
+	initialZMm *float64
+	// Whether initialZMm was captured for the active cycle.
+	initialZCaptured bool
+	// Sticky cycle parameters.
+	z *float64
+	r *float64
+	q *float64
+	p *float64
+}
+
+// NewCannedCycles creates a new CannedCycles.
+func NewCannedCycles(parser *Parser) *CannedCycles {
+	return &CannedCycles{
+		parser:          parser,
+		positionTracker: newPositionTracker(),
+	}
+}
+
+// cannedCycleMoves accumulates the blocks for a canned cycle, tracking the position in the
+// coordinates of the block: absolute, or relative to the block start when incremental.
+type cannedCycleMoves struct {
+	incremental bool
+	position    position
+	blocks      []*Block
+}
+
+func (m *cannedCycleMoves) move(motion float64, x, y, z *float64, words ...*Word) {
+	blockWords := []*Word{NewWord('G', motion)}
+	for i, v := range [3]*float64{x, y, z} {
+		if v == nil {
+			continue
+		}
+		current := [3]**float64{&m.position.x, &m.position.y, &m.position.z}[i]
+		if m.incremental {
+			// Rounded first, so that increments add up exactly.
+			blockWords = append(blockWords, NewWord(axisLetters[i], roundArgument(*v)-roundArgument(**current)))
+		} else {
+			blockWords = append(blockWords, NewWord(axisLetters[i], *v))
+		}
+		value := *v
+		*current = &value
+	}
+	m.blocks = append(m.blocks, NewBlockCommand(append(blockWords, words...)...))
+}
+
+func (m *cannedCycleMoves) rapidZ(z float64) {
+	if *m.position.z != z {
+		m.move(0, nil, nil, &z)
+	}
+}
+
+func (m *cannedCycleMoves) feedZ(z float64) {
+	m.move(1, nil, nil, &z)
+}
+
+// updateCycle updates the canned cycle modal state from block commands, returning whether the
+// block must be expanded as a canned cycle.
+func (c *CannedCycles) updateCycle(block *Block) (bool, error) {
+	var cycleCommand, otherCommand bool
+	for _, w := range block.Commands() {
+		commandStr := w.NormalizedString()
+		switch {
+		case cannedCycleCommands[commandStr]:
+			if c.cycle == nil || c.cycle.NormalizedString() != commandStr {
+				c.initialZCaptured = false
+			}
+			c.cycle = w
+			cycleCommand = true
+		case commandStr == "G98":
+			c.retractToR = false
+		case commandStr == "G99":
+			c.retractToR = true
+		case cannedCycleModalCommands[commandStr]:
+		default:
+			if w.Letter() == 'G' {
+				otherCommand = true
+			}
+			switch commandStr {
+			case "G0", "G1", "G2", "G3", "G38.2", "G38.3", "G38.4", "G38.5", "G80":
+				c.cycle = nil
+				c.initialZCaptured = false
+			}
+		}
+	}
+	if c.cycle == nil {
+		return false, nil
+	}
+	if otherCommand {
+		if cycleCommand {
+			return false, fmt.Errorf("%s: unsupported commands with canned cycle", block)
+		}
+		return false, nil
+	}
+
+	axes, err := getAxes(block)
+	if err != nil {
+		return false, err
+	}
+	for _, letter := range []rune{'Z', 'R', 'Q', 'P'} {
+		v, err := block.GetArgumentNumber(letter)
+		if err != nil {
+			return false, err
+		}
+		if v == nil {
+			continue
+		}
+		switch letter {
+		case 'Z':
+			c.z = v
+		case 'R':
+			c.r = v
+		case 'Q':
+			c.q = v
+		case 'P':
+			c.p = v
+		}
+	}
+	return cycleCommand || axes.x != nil || axes.y != nil || axes.z != nil, nil
+}
+
+// preamble returns a block with the words of block not related to the canned cycle, or nil if
+// there's none.
+func (c *CannedCycles) preamble(block *Block) *Block {
+	var words []*Word
+	for _, w := range block.Words() {
+		switch w.Letter() {
+		case 'X', 'Y', 'Z', 'R', 'Q', 'P', 'L':
+			continue
+		case 'G':
+			commandStr := w.NormalizedString()
+			if cannedCycleCommands[commandStr] || commandStr == "G98" || commandStr == "G99" {
+				continue
+			}
+		}
+		words = append(words, w)
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	return NewBlockCommand(words...)
+}
+
+// expand returns the blocks for a canned cycle block, starting at given position.
+//
+//gocyclo:ignore
+func (c *CannedCycles) expand(block *Block, start position) ([]*Block, error) {
+	modalGroup := &c.parser.ModalGroup
+	cycle := c.cycle.NormalizedString()
+	if modalGroup.PlaneSelection.NormalizedString() != "G17" {
+		return nil, fmt.Errorf("%s: canned cycle unsupported for plane %s", block, modalGroup.PlaneSelection)
+	}
+	if modalGroup.FeedRateMode.NormalizedString() == "G93" {
+		return nil, fmt.Errorf("%s: canned cycle unsupported in inverse time mode", block)
+	}
+	if c.z == nil {
+		return nil, fmt.Errorf("%s: canned cycle requires Z", block)
+	}
+	if c.r == nil {
+		return nil, fmt.Errorf("%s: canned cycle requires R", block)
+	}
+	if (cycle == "G73" || cycle == "G83") && (c.q == nil || *c.q <= 0) {
+		return nil, fmt.Errorf("%s: %s requires Q greater than zero", block, c.cycle)
+	}
+	if cycle == "G82" && (c.p == nil || *c.p < 0) {
+		return nil, fmt.Errorf("%s: %s requires P", block, c.cycle)
+	}
+	axes, err := getAxes(block)
+	if err != nil {
+		return nil, err
+	}
+	l, err := block.GetArgumentNumber('L')
+	if err != nil {
+		return nil, err
+	}
+	repetitions := 1
+	if l != nil {
+		if *l < 1 || *l != math.Trunc(*l) {
+			return nil, fmt.Errorf("%s: L must be a positive integer", block)
+		}
+		repetitions = int(*l)
+	}
+
+	moves := &cannedCycleMoves{
+		incremental: modalGroup.DistanceMode.NormalizedString() == "G91",
+	}
+	if moves.incremental {
+		// Coordinates are relative to the block start.
+		var zero [3]float64
+		moves.position = position{x: &zero[0], y: &zero[1], z: &zero[2]}
+	} else {
+		if start.z == nil {
+			return nil, fmt.Errorf("%s: canned cycle requires known Z position", block)
+		}
+		moves.position = start
+	}
+
+	// The initial level is where Z was when the cycle started, not at each repetition.
+	if !c.initialZCaptured {
+		c.initialZMm = nil
+		if start.z != nil {
+			v := *start.z * unitsFactor(modalGroup)
+			c.initialZMm = &v
+		}
+		c.initialZCaptured = true
+	}
+
+	// Levels, at the block coordinates.
+	rPlane := *c.r
+	bottom := *c.z
+	if moves.incremental {
+		rPlane = *moves.position.z + *c.r
+		bottom = rPlane + *c.z
+	}
+	if bottom > rPlane {
+		return nil, fmt.Errorf("%s: canned cycle Z must be below R", block)
+	}
+	clearZ := rPlane
+	if !c.retractToR {
+		if c.initialZMm == nil || start.z == nil {
+			return nil, fmt.Errorf("%s: G98 requires known Z position when the canned cycle starts", block)
+		}
+		initialZ := *c.initialZMm / unitsFactor(modalGroup)
+		if moves.incremental {
+			initialZ -= *start.z
+		}
+		clearZ = math.Max(initialZ, rPlane)
+	}
+	// Clearance for rapid motion back into a peck drilled hole (0.01 inch).
+	clearance := 0.254
+	if modalGroup.Units.NormalizedString() == "G20" {
+		clearance = 0.01
+	}
+
+	if preamble := c.preamble(block); preamble != nil {
+		moves.blocks = append(moves.blocks, preamble)
+	}
+	for range repetitions {
+		var x, y *float64
+		if axes.x != nil {
+			v := *axes.x
+			if moves.incremental {
+				v += *moves.position.x
+			}
+			x = &v
+		}
+		if axes.y != nil {
+			v := *axes.y
+			if moves.incremental {
+				v += *moves.position.y
+			}
+			y = &v
+		}
+
+		if *moves.position.z < rPlane {
+			moves.rapidZ(rPlane)
+		}
+		if x != nil || y != nil {
+			moves.move(0, x, y, nil)
+		}
+		moves.rapidZ(rPlane)
+
+		switch cycle {
+		case "G81", "G82":
+			moves.feedZ(bottom)
+			if cycle == "G82" {
+				moves.blocks = append(moves.blocks, NewBlockCommand(NewWord('G', 4), NewWord('P', *c.p)))
+			}
+		case "G73", "G83":
+			depth := rPlane
+			for {
+				depth = math.Max(depth-*c.q, bottom)
+				moves.feedZ(depth)
+				if depth <= bottom {
+					break
+				}
+				if cycle == "G83" {
+					moves.rapidZ(rPlane)
+				}
+				moves.rapidZ(depth + clearance)
+			}
+		}
+		moves.rapidZ(clearZ)
+	}
+
+	// Update the tracked position with the end position.
+	end := moves.position
+	if moves.incremental {
+		current := c.positionTracker.current(&c.parser.ModalGroup)
+		for _, axis := range []struct {
+			delta   *float64
+			current **float64
+		}{
+			{end.x, &current.x},
+			{end.y, &current.y},
+			{end.z, &current.z},
+		} {
+			if *axis.current != nil {
+				v := **axis.current + *axis.delta
+				*axis.current = &v
+			}
+		}
+		end = current
+	}
+	c.positionTracker.set(&c.parser.ModalGroup, end)
+
+	return moves.blocks, nil
+}
+
+// cannedCyclesBlock returns the blocks that replace the given block, or nil if it must be kept
+// unchanged.
+func (c *CannedCycles) cannedCyclesBlock(block *Block) ([]*Block, error) {
+	if block.IsSystem() {
+		if _, _, err := c.positionTracker.next(&c.parser.ModalGroup, block); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	expand, err := c.updateCycle(block)
+	if err != nil {
+		return nil, err
+	}
+	if expand {
+		// Canned cycle words are not motion for the position tracker, which is updated by expand.
+		return c.expand(block, c.positionTracker.current(&c.parser.ModalGroup))
+	}
+
+	if _, _, err := c.positionTracker.next(&c.parser.ModalGroup, block); err != nil {
+		return nil, err
+	}
+	// Grbl does not support return level commands.
+	var words []*Word
+	for _, w := range block.Words() {
+		switch w.NormalizedString() {
+		case "G98", "G99":
+			continue
+		}
+		words = append(words, w)
+	}
+	if len(words) == len(block.Words()) {
+		return nil, nil
+	}
+	if len(words) == 0 {
+		return []*Block{}, nil
+	}
+	return []*Block{NewBlockCommand(words...)}, nil
+}
+
+// Next returns the next line(s) of G-code, with canned cycles expanded. Returns nil when the end of
+// input is reached.
+func (c *CannedCycles) Next() (*string, error) {
+	return blockTransformNext(c.parser, c.cannedCyclesBlock)
+}
@@ -0,0 +1,121 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func expandCannedCycles(gcode string) (string, error) {
+	return transformString(NewCannedCycles(NewParser(strings.NewReader(gcode))))
+}
+
+func TestCannedCycles(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		gcode         string
+		expected      string
+		errorContains string
+	}{
+		{
+			name:  "G81 return to initial level with modal repetition",
+			gcode: "G0 Z5\nG98 G81 X1 Y2 Z-3 R1 F100\nX3\nG80\nG0 X0\n",
+			expected: "G0 Z5\n" +
+				"F100\n" +
+				"G0X1Y2\nG0Z1\nG1Z-3\nG0Z5\n" +
+				"G0X3\nG0Z1\nG1Z-3\nG0Z5\n" +
+				"G80\n" +
+				"G0 X0\n",
+		},
+		{
+			name:  "G82 return to R level",
+			gcode: "G0 Z5\nG99 G82 X1 Z-3 R1 P0.5\n",
+			expected: "G0 Z5\n" +
+				"G0X1\nG0Z1\nG1Z-3\nG4P0.5\nG0Z1\n",
+		},
+		{
+			name:  "G83 peck",
+			gcode: "G0 Z5\nG83 X1 Z-2 R1 Q1.5\n",
+			expected: "G0 Z5\n" +
+				"G0X1\nG0Z1\n" +
+				"G1Z-0.5\nG0Z1\nG0Z-0.246\n" +
+				"G1Z-2\nG0Z5\n",
+		},
+		{
+			name:  "G73 chip breaking",
+			gcode: "G0 Z5\nG73 X1 Z-2 R1 Q1.5\n",
+			expected: "G0 Z5\n" +
+				"G0X1\nG0Z1\n" +
+				"G1Z-0.5\nG0Z-0.246\n" +
+				"G1Z-2\nG0Z5\n",
+		},
+		{
+			name:  "initial level below R",
+			gcode: "G0 Z0\nG98 G81 X1 Z-1 R2\n",
+			expected: "G0 Z0\n" +
+				"G0Z2\nG0X1\nG1Z-1\nG0Z2\n",
+		},
+		{
+			name:  "incremental with repetitions",
+			gcode: "G91 G99 G81 X10 Z-2 R-1 L2\n",
+			expected: "G91\n" +
+				"G0X10\nG0Z-1\nG1Z-2\nG0Z2\n" +
+				"G0X10\nG1Z-2\nG0Z2\n",
+		},
+		{
+			name:  "G98 returns to the level where the cycle started",
+			gcode: "G0 Z5\nG99 G81 X1 Z-3 R1\nG98 X2\n",
+			expected: "G0 Z5\n" +
+				"G0X1\nG0Z1\nG1Z-3\nG0Z1\n" +
+				"G0X2\nG1Z-3\nG0Z5\n",
+		},
+		{
+			name:  "initial level is captured again after G80",
+			gcode: "G0 Z5\nG81 X1 Z-3 R1\nG80\nG0 Z10\nG81 X2 Z-3 R1\n",
+			expected: "G0 Z5\n" +
+				"G0X1\nG0Z1\nG1Z-3\nG0Z5\n" +
+				"G80\n" +
+				"G0 Z10\n" +
+				"G0X2\nG0Z1\nG1Z-3\nG0Z10\n",
+		},
+		{
+			name:  "incremental return to initial level",
+			gcode: "G0 Z5\nG91 G98 G81 X10 Z-2 R-1 L2\n",
+			expected: "G0 Z5\n" +
+				"G91\n" +
+				"G0X10\nG0Z-1\nG1Z-2\nG0Z3\n" +
+				"G0X10\nG0Z-1\nG1Z-2\nG0Z3\n",
+		},
+		{
+			name:     "return level words are removed",
+			gcode:    "G98\nG0 G99 X1\n",
+			expected: "G0X1\n",
+		},
+		{
+			name:          "missing R",
+			gcode:         "G0 Z5\nG81 X1 Z-3\n",
+			errorContains: "requires R",
+		},
+		{
+			name:          "missing Q",
+			gcode:         "G0 Z5\nG83 X1 Z-3 R1\n",
+			errorContains: "requires Q",
+		},
+		{
+			name:          "unknown Z",
+			gcode:         "G81 X1 Z-3 R1\n",
+			errorContains: "requires known Z position",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := expandCannedCycles(tc.gcode)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
@@ -0,0 +1,530 @@
+package gcode
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// point2 is a point or vector at the XY plane.
+type point2 [2]float64
+
+func (p point2) add(o point2) point2            { return point2{p[0] + o[0], p[1] + o[1]} }
+func (p point2) sub(o point2) point2            { return point2{p[0] - o[0], p[1] - o[1]} }
+func (p point2) scale(factor float64) point2    { return point2{p[0] * factor, p[1] * factor} }
+func (p point2) dot(o point2) float64           { return p[0]*o[0] + p[1]*o[1] }
+func (p point2) cross(o point2) float64         { return p[0]*o[1] - p[1]*o[0] }
+func (p point2) length() float64                { return math.Hypot(p[0], p[1]) }
+func (p point2) normalize() point2              { return p.scale(1 / p.length()) }
+func (p point2) left() point2                   { return point2{-p[1], p[0]} }
+func (p point2) distance(o point2) float64      { return p.sub(o).length() }
+func (p point2) equal(o point2, e float64) bool { return p.distance(o) < e }
+
+// cutterCompensationEpsilon is the distance below which points are considered the same, and the
+// cross product of unit vectors below which directions are considered parallel.
+const cutterCompensationEpsilon = 1e-9
+
+// cutterCompensationSegment is a G0, G1, G2 or G3 motion at the XY plane, in absolute coordinates.
+type cutterCompensationSegment struct {
+	block  *Block
+	motion string
+	// Programmed start and end.
+	start, end point2
+	// Arc center and radius, for G2 / G3.
+	center point2
+	radius float64
+	// Whether this is the entry move, from the uncompensated position.
+	entry bool
+	// Whether the block is in incremental distance mode.
+	incremental bool
+	// Compensated start and end.
+	compensatedStart, compensatedEnd point2
+}
+
+func (s *cutterCompensationSegment) arc() bool {
+	return s.motion == "G2" || s.motion == "G3"
+}
+
+// tangent returns the unit direction of motion at given point of the segment.
+func (s *cutterCompensationSegment) tangent(p point2) point2 {
+	if !s.arc() {
+		return s.end.sub(s.start).normalize()
+	}
+	radial := p.sub(s.center).normalize()
+	if s.motion == "G2" {
+		return point2{radial[1], -radial[0]}
+	}
+	return radial.left()
+}
+
+// CutterCompensation applies cutter radius compensation (G41 / G42), which Grbl does not support, to
+// motion at the XY plane (G17), offsetting G0, G1, G2 and G3 moves by the tool radius to the left
+// (G41) or right (G42) of the programmed path. It follows LinuxCNC semantics: the first XY move after
+// G41 / G42 is the entry move, which must be a line, and ends offset from the next move start. The
+// first XY move after G40 is the exit move, which must also be a line, from the compensated position
+// to the programmed position. Outside corners are joined with arcs around the programmed corner, and
+// inside corners are trimmed at the intersection of the offset moves. The tool diameter is given by
+// the D word at the G41 / G42 block, in the current units, or is a default in millimeters. Both
+// distance modes are supported.
+type CutterCompensation struct {
+	parser          *Parser
+	diameter        float64
+	positionTracker *positionTracker
+	// Compensation side: 1 for left (G41), -1 for right (G42) or 0 when off (G40).
+	side   float64
+	radius float64
+	entry  bool
+	// Last XY segment, pending the next one to compute its compensated end.
+	pending *cutterCompensationSegment
+	// Blocks without XY motion after pending.
+	queued []*Block
+	// Position the tool was last commanded to at the XY plane, when different from the programmed
+	// position due to compensation.
+	compensatedPosition *point2
+	eof                 bool
+}
+
+// NewCutterCompensation creates a new CutterCompensation. diameter is the default tool diameter in
+// millimeters, used when G41 / G42 are not given a D word.
+func NewCutterCompensation(parser *Parser, diameter float64) *CutterCompensation {
+	return &CutterCompensation{
+		parser:          parser,
+		diameter:        diameter,
+		positionTracker: newPositionTracker(),
+	}
+}
+
+// offset returns p offset by the tool radius, to the compensation side of given tangent.
+func (c *CutterCompensation) offset(p, tangent point2) point2 {
+	return p.add(tangent.left().scale(c.side * c.radius))
+}
+
+// offsetRadius returns the radius of the compensated arc segment.
+func (c *CutterCompensation) offsetRadius(segment *cutterCompensationSegment) float64 {
+	// The left side of a counterclockwise arc is towards the center.
+	if segment.motion == "G3" {
+		return segment.radius - c.side*c.radius
+	}
+	return segment.radius + c.side*c.radius
+}
+
+// intersection returns the intersection of the compensated segments a (at its end) and b (at its
+// start) nearest to the programmed corner.
+//
+//gocyclo:ignore
+func (c *CutterCompensation) intersection(a, b *cutterCompensationSegment) (point2, error) {
+	corner := a.end
+	ea := c.offset(corner, a.tangent(corner))
+	sb := c.offset(corner, b.tangent(corner))
+
+	var candidates []point2
+	switch {
+	case !a.arc() && !b.arc():
+		ta, tb := a.tangent(corner), b.tangent(corner)
+		denominator := ta.cross(tb)
+		t := sb.sub(ea).cross(tb) / denominator
+		candidates = append(candidates, ea.add(ta.scale(t)))
+	case a.arc() && b.arc():
+		candidates = circlesIntersection(a.center, c.offsetRadius(a), b.center, c.offsetRadius(b))
+	case a.arc():
+		candidates = lineCircleIntersection(sb, b.tangent(corner), a.center, c.offsetRadius(a))
+	default:
+		candidates = lineCircleIntersection(ea, a.tangent(corner), b.center, c.offsetRadius(b))
+	}
+	if len(candidates) == 0 {
+		return point2{}, errors.New("inside corner too tight for the tool radius")
+	}
+	nearest := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.distance(corner) < nearest.distance(corner) {
+			nearest = candidate
+		}
+	}
+	return nearest, nil
+}
+
+// lineCircleIntersection returns the intersections of the line through p with direction d (a unit
+// vector) and the circle at center with radius.
+func lineCircleIntersection(p, d, center point2, radius float64) []point2 {
+	f := p.sub(center)
+	b := f.dot(d)
+	discriminant := b*b - (f.dot(f) - radius*radius)
+	if discriminant < 0 {
+		return nil
+	}
+	sqrt := math.Sqrt(discriminant)
+	return []point2{p.add(d.scale(-b - sqrt)), p.add(d.scale(-b + sqrt))}
+}
+
+// circlesIntersection returns the intersections of two circles.
+func circlesIntersection(c0 point2, r0 float64, c1 point2, r1 float64) []point2 {
+	d := c1.sub(c0)
+	distance := d.length()
+	if distance < cutterCompensationEpsilon || distance > r0+r1 || distance < math.Abs(r0-r1) {
+		return nil
+	}
+	a := (r0*r0 - r1*r1 + distance*distance) / (2 * distance)
+	h := math.Sqrt(math.Max(0, r0*r0-a*a))
+	u := d.scale(1 / distance)
+	m := c0.add(u.scale(a))
+	return []point2{m.add(u.left().scale(h)), m.sub(u.left().scale(h))}
+}
+
+// segmentWords returns the words of block that are not replaced for compensated motion.
+func segmentWords(block *Block) []*Word {
+	var words []*Word
+	for _, w := range block.Words() {
+		switch w.Letter() {
+		case 'X', 'Y', 'I', 'J', 'R', 'D':
+			continue
+		case 'G':
+			switch w.NormalizedString() {
+			case "G0", "G1", "G2", "G3", "G41", "G42":
+				continue
+			}
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// motionBlock returns a block moving from the compensated position to end, with other words.
+func (c *CutterCompensation) motionBlock(motion string, incremental bool, end point2, center *point2, words []*Word) *Block {
+	var start point2
+	if c.compensatedPosition != nil {
+		start = *c.compensatedPosition
+	}
+	motionWord := map[string]*Word{
+		"G0": NewWord('G', 0),
+		"G1": NewWord('G', 1),
+		"G2": NewWord('G', 2),
+		"G3": NewWord('G', 3),
+	}[motion]
+	blockWords := []*Word{motionWord}
+	if incremental {
+		blockWords = append(blockWords,
+			NewWord('X', roundArgument(end[0])-roundArgument(start[0])),
+			NewWord('Y', roundArgument(end[1])-roundArgument(start[1])),
+		)
+	} else {
+		blockWords = append(blockWords, NewWord('X', end[0]), NewWord('Y', end[1]))
+	}
+	if center != nil {
+		blockWords = append(blockWords, NewWord('I', center[0]-start[0]), NewWord('J', center[1]-start[1]))
+	}
+	position := end
+	c.compensatedPosition = &position
+	return NewBlockCommand(append(blockWords, words...)...)
+}
+
+// finishPending computes the compensated end of the pending segment, given the next segment, or nil
+// if there's none, returning the blocks for the pending segment, queued blocks and the outside corner
+// arc, if any.
+func (c *CutterCompensation) finishPending(next *cutterCompensationSegment) ([]*Block, error) {
+	pending := c.pending
+	if pending == nil {
+		return nil, nil
+	}
+	c.pending = nil
+
+	corner := pending.end
+	tangent := pending.tangent(corner)
+	pending.compensatedEnd = c.offset(corner, tangent)
+	var cornerMotion string
+	if next != nil {
+		nextTangent := next.tangent(corner)
+		cross := tangent.cross(nextTangent)
+		switch {
+		case pending.entry:
+			pending.compensatedEnd = c.offset(corner, nextTangent)
+			next.compensatedStart = pending.compensatedEnd
+		case math.Abs(cross) < cutterCompensationEpsilon && tangent.dot(nextTangent) > 0:
+			// Tangent
+			next.compensatedStart = pending.compensatedEnd
+		case c.side*cross > 0:
+			// Inside corner
+			intersection, err := c.intersection(pending, next)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", next.block, err)
+			}
+			pending.compensatedEnd = intersection
+			next.compensatedStart = intersection
+		default:
+			// Outside corner, joined by an arc around it.
+			next.compensatedStart = c.offset(corner, nextTangent)
+			cornerMotion = "G3"
+			if c.side > 0 {
+				cornerMotion = "G2"
+			}
+		}
+	}
+
+	blocks, err := c.segmentBlocks(pending)
+	if err != nil {
+		return nil, err
+	}
+	// Queued blocks run at the end of the pending segment, under its motion mode, before the corner.
+	blocks = append(blocks, c.queued...)
+	c.queued = nil
+	if cornerMotion != "" {
+		blocks = append(blocks, c.motionBlock(cornerMotion, pending.incremental, next.compensatedStart, &corner, nil))
+	}
+	return blocks, nil
+}
+
+// segmentBlocks returns the block for a segment with compensated start and end computed.
+func (c *CutterCompensation) segmentBlocks(segment *cutterCompensationSegment) ([]*Block, error) {
+	if !segment.arc() {
+		compensated := segment.compensatedEnd.sub(segment.compensatedStart)
+		if !segment.entry && compensated.dot(segment.end.sub(segment.start)) <= 0 {
+			return nil, fmt.Errorf("%s: move too short for the tool radius", segment.block)
+		}
+		return []*Block{c.motionBlock(segment.motion, segment.incremental, segment.compensatedEnd, nil, segmentWords(segment.block))}, nil
+	}
+	if c.offsetRadius(segment) <= 0 {
+		return nil, fmt.Errorf("%s: arc radius too small for the tool radius", segment.block)
+	}
+	return []*Block{c.motionBlock(segment.motion, segment.incremental, segment.compensatedEnd, &segment.center, segmentWords(segment.block))}, nil
+}
+
+// newSegment returns the XY segment for block, moving from start to end, or nil if block has no
+// XY motion.
+func (c *CutterCompensation) newSegment(block *Block, start, end position) (*cutterCompensationSegment, error) {
+	motion := c.parser.ModalGroup.Motion.NormalizedString()
+	arc := motion == "G2" || motion == "G3"
+	if start.x == nil || start.y == nil || end.x == nil || end.y == nil {
+		return nil, fmt.Errorf("%s: cutter compensation requires known X and Y positions", block)
+	}
+	segment := &cutterCompensationSegment{
+		block:  block,
+		motion: motion,
+		start:  point2{*start.x, *start.y},
+		end:    point2{*end.x, *end.y},
+		// Output blocks are in the same distance mode.
+		incremental: c.parser.ModalGroup.DistanceMode.NormalizedString() == "G91",
+	}
+	if !arc {
+		if segment.start.equal(segment.end, cutterCompensationEpsilon) {
+			return nil, nil
+		}
+		return segment, nil
+	}
+	unitsFactor := 1.0
+	if c.parser.ModalGroup.Units.NormalizedString() == "G20" {
+		unitsFactor = 1 / 25.4
+	}
+	var z float64
+	if start.z != nil && end.z != nil {
+		z = *end.z - *start.z
+	}
+	delta := segment.end.sub(segment.start)
+	geometry, err := getArcGeometry(block, arcPlanes["G17"], [3]float64{delta[0], delta[1], z}, motion == "G2", unitsFactor)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", block, err)
+	}
+	segment.center = segment.start.add(point2(geometry.offset))
+	segment.radius = geometry.radius
+	return segment, nil
+}
+
+// withoutCompensationWords returns block without G41, G42 and D words, or nil if it has no other
+// words.
+func withoutCompensationWords(block *Block) *Block {
+	var words []*Word
+	for _, w := range block.Words() {
+		switch w.NormalizedString() {
+		case "G41", "G42":
+			continue
+		}
+		if w.Letter() == 'D' {
+			continue
+		}
+		words = append(words, w)
+	}
+	if len(words) == len(block.Words()) {
+		return block
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	return NewBlockCommand(words...)
+}
+
+// cutterCompensationUnsupportedCommands are commands unsupported while compensation is active.
+var cutterCompensationUnsupportedCommands = map[string]bool{
+	"G10": true, "G28": true, "G30": true, "G53": true, "G92": true, "G92.1": true,
+	"G38.2": true, "G38.3": true, "G38.4": true, "G38.5": true,
+	"G18": true, "G19": true, "G20": true, "G21": true,
+}
+
+// updateSide updates the compensation side and radius from block commands, returning whether
+// compensation was started (G41 / G42) or stopped (G40).
+func (c *CutterCompensation) updateSide(block *Block) (bool, bool, error) {
+	var start, stop bool
+	for _, w := range block.Commands() {
+		switch w.NormalizedString() {
+		case "G41", "G42":
+			if c.parser.ModalGroup.PlaneSelection.NormalizedString() != "G17" {
+				return false, false, fmt.Errorf("%s: cutter compensation unsupported for plane %s", block, c.parser.ModalGroup.PlaneSelection)
+			}
+			start = true
+			c.side = 1
+			if w.NormalizedString() == "G42" {
+				c.side = -1
+			}
+			d, err := block.GetArgumentNumber('D')
+			if err != nil {
+				return false, false, err
+			}
+			if d != nil {
+				c.radius = *d / 2
+			} else {
+				c.radius = c.diameter / 2
+				if c.parser.ModalGroup.Units.NormalizedString() == "G20" {
+					c.radius /= 25.4
+				}
+			}
+			if c.radius <= 0 {
+				return false, false, fmt.Errorf("%s: cutter compensation requires a tool diameter", block)
+			}
+		case "G40":
+			stop = true
+		}
+	}
+	if start && stop {
+		return false, false, fmt.Errorf("%s: G40 with G41 / G42", block)
+	}
+	return start, stop, nil
+}
+
+// cutterCompensationBlock returns the blocks that replace the given block, or nil if it must be
+// kept unchanged.
+//
+//gocyclo:ignore
+func (c *CutterCompensation) cutterCompensationBlock(block *Block) ([]*Block, error) {
+	start, motion, err := c.positionTracker.next(&c.parser.ModalGroup, block)
+	if err != nil {
+		return nil, err
+	}
+	end := c.positionTracker.current(&c.parser.ModalGroup)
+
+	if block.IsSystem() {
+		if c.side != 0 {
+			return nil, fmt.Errorf("%s: unsupported while cutter compensation is active", block)
+		}
+		c.compensatedPosition = nil
+		return nil, nil
+	}
+
+	active := c.side != 0
+	compensationStart, compensationStop, err := c.updateSide(block)
+	if err != nil {
+		return nil, err
+	}
+	if active && !compensationStart {
+		for _, w := range block.Commands() {
+			if cutterCompensationUnsupportedCommands[w.NormalizedString()] {
+				return nil, fmt.Errorf("%s: %s unsupported while cutter compensation is active", block, w)
+			}
+		}
+	}
+
+	var segment *cutterCompensationSegment
+	if motion && (c.side != 0 || c.compensatedPosition != nil) {
+		segment, err = c.newSegment(block, start, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var blocks []*Block
+	switch {
+	case compensationStart:
+		// Switching sides finishes the previous compensated motion.
+		if blocks, err = c.finishPending(nil); err != nil {
+			return nil, err
+		}
+		c.entry = true
+	case compensationStop:
+		if blocks, err = c.finishPending(nil); err != nil {
+			return nil, err
+		}
+		c.side = 0
+	}
+
+	if c.side == 0 {
+		if segment != nil && c.compensatedPosition != nil {
+			// Exit move
+			if segment.arc() {
+				return nil, fmt.Errorf("%s: cutter compensation exit move must be a line", block)
+			}
+			blocks = append(blocks, c.motionBlock(segment.motion, segment.incremental, segment.end, nil, segmentWords(block)))
+			c.compensatedPosition = nil
+			return blocks, nil
+		}
+		if blocks == nil {
+			return nil, nil
+		}
+		return append(blocks, block), nil
+	}
+
+	if segment == nil {
+		strippedBlock := withoutCompensationWords(block)
+		if c.pending != nil {
+			if strippedBlock != nil {
+				c.queued = append(c.queued, strippedBlock)
+			}
+			return append([]*Block{}, blocks...), nil
+		}
+		if strippedBlock == block && blocks == nil {
+			return nil, nil
+		}
+		if strippedBlock != nil {
+			blocks = append(blocks, strippedBlock)
+		}
+		return append([]*Block{}, blocks...), nil
+	}
+
+	if c.entry {
+		if segment.arc() {
+			return nil, fmt.Errorf("%s: cutter compensation entry move must be a line", block)
+		}
+		segment.entry = true
+		segment.compensatedStart = segment.start
+		if c.compensatedPosition == nil {
+			c.compensatedPosition = &segment.start
+		}
+		c.entry = false
+	}
+	finished, err := c.finishPending(segment)
+	if err != nil {
+		return nil, err
+	}
+	c.pending = segment
+	return append(append([]*Block{}, blocks...), finished...), nil
+}
+
+// Next returns the next line(s) of G-code, with cutter compensation applied. Returns nil when the
+// end of input is reached.
+func (c *CutterCompensation) Next() (*string, error) {
+	if c.eof {
+		return nil, nil
+	}
+	line, err := blockTransformNext(c.parser, c.cutterCompensationBlock)
+	if err != nil || line != nil {
+		return line, err
+	}
+	c.eof = true
+	blocks, err := c.finishPending(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(blocks) == 0 {
+		return nil, nil
+	}
+	var lines string
+	for _, b := range blocks {
+		lines += b.String() + "\n"
+	}
+	return &lines, nil
+}
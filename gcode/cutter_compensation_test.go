@@ -0,0 +1,101 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func compensate(gcode string, diameter float64) (string, error) {
+	return transformString(NewCutterCompensation(NewParser(strings.NewReader(gcode)), diameter))
+}
+
+func TestCutterCompensation(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		gcode         string
+		diameter      float64
+		expected      string
+		errorContains string
+	}{
+		{
+			name:  "left inside square",
+			gcode: "G0 X-5 Y-5\nG41 D2 G1 X0 Y0 F100\nX10\nY10\nX0\nY0\nG40 X-5 Y-5\n",
+			expected: "G0 X-5 Y-5\n" +
+				"G1X0Y1F100\n" +
+				"G1X9Y1\n" +
+				"G1X9Y9\n" +
+				"G1X1Y9\n" +
+				"G1X1Y0\n" +
+				"G1X-5Y-5G40\n",
+		},
+		{
+			name:     "right outside corners with default diameter",
+			gcode:    "G0 X-5 Y-5\nG42 G1 X0 Y0 F100\nX10\nZ-1\nY10\nG40 X15 Y15\n",
+			diameter: 2,
+			expected: "G0 X-5 Y-5\n" +
+				"G1X0Y-1F100\n" +
+				"G1X10Y-1\n" +
+				"Z-1\n" +
+				"G3X11Y0I0J1\n" +
+				"G1X11Y10\n" +
+				"G1X15Y15G40\n",
+		},
+		{
+			name:  "left outside corner with Z move",
+			gcode: "G0 X-5 Y-5\nG41 D2 G1 X0 Y0 F100\nY10\nZ-1\nX10\nG40 X15 Y15\n",
+			expected: "G0 X-5 Y-5\n" +
+				"G1X-1Y0F100\n" +
+				"G1X-1Y10\n" +
+				"Z-1\n" +
+				"G2X0Y11I1J0\n" +
+				"G1X10Y11\n" +
+				"G1X15Y15G40\n",
+		},
+		{
+			name:  "arc to line inside corner",
+			gcode: "G0 X-10 Y0\nG41 D2\nG1 X0 Y0 F100\nG2 X10 Y0 I5 J0\nG1 X20\nG40 G0 X30\n",
+			expected: "G0 X-10 Y0\n" +
+				"G1X-1Y0F100\n" +
+				"G2X10.9161Y1I6J0\n" +
+				"G1X20Y1\n" +
+				"G0X30Y0G40\n",
+		},
+		{
+			name:  "incremental",
+			gcode: "G0 X-5 Y-5\nG91\nG41 D2 G1 X5 Y5 F100\nX10\nY10\nG40 X5 Y5\n",
+			expected: "G0 X-5 Y-5\n" +
+				"G91\n" +
+				"G1X5Y6F100\n" +
+				"G1X9Y0\n" +
+				"G1X0Y9\n" +
+				"G1X6Y5G40\n",
+		},
+		{
+			name:          "arc entry move",
+			gcode:         "G0 X0 Y0\nG41 D2 G2 X10 Y0 I5 J0\n",
+			errorContains: "entry move must be a line",
+		},
+		{
+			name:          "inside corner too tight",
+			gcode:         "G0 X-5 Y-5\nG41 D4 G1 X0 Y0 F100\nX1\nY10\n",
+			errorContains: "move too short for the tool radius",
+		},
+		{
+			name:          "missing diameter",
+			gcode:         "G0 X0 Y0\nG41 G1 X10\n",
+			errorContains: "requires a tool diameter",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := compensate(tc.gcode, tc.diameter)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// PlannerSettings holds the machine kinematics settings used by Grbl's motion planner.
+type PlannerSettings struct {
+	// Maximum rate for each axis in millimeters per minute ($110, $111 and $112).
+	MaxRate Vector
+	// Acceleration for each axis in millimeters per second squared ($120, $121 and $122).
+	Acceleration Vector
+	// Junction deviation in millimeters ($11).
+	JunctionDeviation float64
+	// Arc tolerance in millimeters ($12).
+	ArcTolerance float64
+}
+
+// SegmentEstimate is the estimated execution time of a Segment.
+type SegmentEstimate struct {
+	Segment  Segment
+	Duration time.Duration
+}
+
+// Estimate is the estimated execution time of a program.
+type Estimate struct {
+	// Total time, including cutting, rapids and dwell.
+	Total time.Duration
+	// Time for motion at feed rate, including arcs and probing.
+	Cutting time.Duration
+	// Time for motion at rapid rate.
+	Rapid time.Duration
+	// Time for dwell (G4).
+	Dwell time.Duration
+	// Number of program pauses (M0, M1), for which time can not be estimated.
+	Pauses int
+	// Estimate for each segment, in program order.
+	Segments []SegmentEstimate
+}
+
+// plannerBlock is a straight line motion at Grbl's planner.
+type plannerBlock struct {
+	segmentIndex int
+	length       float64
+	// Unit vector of the motion direction.
+	unit Vector
+	// Nominal speed and acceleration, in millimeters per second (squared).
+	nominalSpeed float64
+	acceleration float64
+	// Maximum entry speed, in millimeters per second.
+	maxEntrySpeed float64
+	entrySpeed    float64
+}
+
+// limitByAxisMaximum returns the maximum value along unit vector, such that no axis exceeds its
+// maximum. Same as Grbl's limit_value_by_axis_maximum.
+func limitByAxisMaximum(maxValue, unit Vector) float64 {
+	limit := math.Inf(1)
+	for i := range 3 {
+		if u := math.Abs(unit.axis(i)); u > 0 {
+			limit = math.Min(limit, maxValue.axis(i)/u)
+		}
+	}
+	return limit
+}
+
+// trapezoidTime returns the time in seconds to travel length, starting at entrySpeed, ending at
+// exitSpeed, at a maximum nominalSpeed with given acceleration.
+func trapezoidTime(length, entrySpeed, exitSpeed, nominalSpeed, acceleration float64) float64 {
+	accelerateDistance := (nominalSpeed*nominalSpeed - entrySpeed*entrySpeed) / (2 * acceleration)
+	decelerateDistance := (nominalSpeed*nominalSpeed - exitSpeed*exitSpeed) / (2 * acceleration)
+	if accelerateDistance+decelerateDistance <= length {
+		return (nominalSpeed-entrySpeed)/acceleration +
+			(nominalSpeed-exitSpeed)/acceleration +
+			(length-accelerateDistance-decelerateDistance)/nominalSpeed
+	}
+	// Triangle profile: nominal speed is never reached.
+	peakSpeed := math.Sqrt((2*acceleration*length + entrySpeed*entrySpeed + exitSpeed*exitSpeed) / 2)
+	return (peakSpeed-entrySpeed)/acceleration + (peakSpeed-exitSpeed)/acceleration
+}
+
+// Estimator estimates the execution time of a program, by simulating Grbl's trapezoidal motion
+// planner with look ahead over the whole program.
+type Estimator struct {
+	settings PlannerSettings
+	estimate Estimate
+	blocks   []plannerBlock
+	// Whether the next block starts from a stop.
+	stopped bool
+}
+
+// NewEstimator creates a new Estimator.
+func NewEstimator(settings PlannerSettings) *Estimator {
+	return &Estimator{
+		settings: settings,
+		stopped:  true,
+	}
+}
+
+// stop makes the machine come to a full stop after the last planned block, as Grbl does when
+// synchronizing (eg: dwell or spindle changes).
+func (e *Estimator) stop() {
+	e.stopped = true
+}
+
+// junctionMaxSpeed returns the maximum speed at the junction between the previous block and
+// given block. Same as Grbl's plan_buffer_line.
+func (e *Estimator) junctionMaxSpeed(block *plannerBlock) float64 {
+	if e.stopped {
+		return 0
+	}
+	previous := e.blocks[len(e.blocks)-1]
+	previousUnit := previous.unit
+	cosTheta := -(previousUnit.X*block.unit.X + previousUnit.Y*block.unit.Y + previousUnit.Z*block.unit.Z)
+	var speed float64
+	switch {
+	case cosTheta > 0.999999:
+		// Reversal: minimum junction speed.
+		speed = 0
+	case cosTheta < -0.999999:
+		// Straight line.
+		speed = math.Inf(1)
+	default:
+		junctionUnit := block.unit.Sub(previousUnit)
+		junctionLength := junctionUnit.Length()
+		junctionUnit = Vector{X: junctionUnit.X / junctionLength, Y: junctionUnit.Y / junctionLength, Z: junctionUnit.Z / junctionLength}
+		junctionAcceleration := limitByAxisMaximum(e.settings.Acceleration, junctionUnit)
+		sinThetaD2 := math.Sqrt(0.5 * (1 - cosTheta))
+		speed = math.Sqrt(junctionAcceleration * e.settings.JunctionDeviation * sinThetaD2 / (1 - sinThetaD2))
+	}
+	return math.Min(speed, math.Min(previous.nominalSpeed, block.nominalSpeed))
+}
+
+// addLine plans a straight line motion from start to end.
+func (e *Estimator) addLine(segmentIndex int, start, end Vector, feedRate float64, rapid bool) error {
+	delta := end.Sub(start)
+	length := delta.Length()
+	if length == 0 {
+		return nil
+	}
+	unit := Vector{X: delta.X / length, Y: delta.Y / length, Z: delta.Z / length}
+
+	nominalSpeed := limitByAxisMaximum(e.settings.MaxRate, unit) / 60
+	if !rapid {
+		if feedRate <= 0 {
+			return fmt.Errorf("%s: undefined feed rate", e.estimate.Segments[segmentIndex].Segment.Block)
+		}
+		nominalSpeed = math.Min(nominalSpeed, feedRate/60)
+	}
+	acceleration := limitByAxisMaximum(e.settings.Acceleration, unit)
+	if nominalSpeed <= 0 || math.IsInf(nominalSpeed, 0) || acceleration <= 0 || math.IsInf(acceleration, 0) {
+		return fmt.Errorf("invalid planner settings: max rate %v, acceleration %v", e.settings.MaxRate, e.settings.Acceleration)
+	}
+
+	block := plannerBlock{
+		segmentIndex: segmentIndex,
+		length:       length,
+		unit:         unit,
+		nominalSpeed: nominalSpeed,
+		acceleration: acceleration,
+	}
+	block.maxEntrySpeed = e.junctionMaxSpeed(&block)
+	e.blocks = append(e.blocks, block)
+	e.stopped = false
+	return nil
+}
+
+// Add plans given block, and the segments it generated when executed by a Machine.
+func (e *Estimator) Add(block *Block, segments []Segment) error {
+	if block.IsCommand() {
+		for _, w := range block.Commands() {
+			switch w.NormalizedString() {
+			case "G4":
+				p, err := block.GetArgumentNumber('P')
+				if err != nil {
+					return err
+				}
+				if p != nil {
+					e.estimate.Dwell += time.Duration(*p * float64(time.Second))
+				}
+				e.stop()
+			case "M0", "M1":
+				e.estimate.Pauses++
+				e.stop()
+			case "M2", "M3", "M4", "M5", "M7", "M8", "M9", "M30":
+				e.stop()
+			}
+		}
+	}
+
+	for _, segment := range segments {
+		segmentIndex := len(e.estimate.Segments)
+		e.estimate.Segments = append(e.estimate.Segments, SegmentEstimate{Segment: segment})
+		if segment.Type == SegmentTypeProbe {
+			e.stop()
+		}
+		start := segment.Start
+		for _, point := range segment.Points(e.settings.ArcTolerance) {
+			if err := e.addLine(segmentIndex, start, point, segment.FeedRate, segment.Type == SegmentTypeRapid); err != nil {
+				return err
+			}
+			start = point
+		}
+		if segment.Type == SegmentTypeProbe {
+			e.stop()
+		}
+	}
+	return nil
+}
+
+// Estimate returns the estimate for all blocks executed so far, with the machine coming to a stop
+// at the end.
+func (e *Estimator) Estimate() *Estimate {
+	blocks := e.blocks
+
+	// Reverse pass: entry speeds that allow decelerating to the next block entry speed.
+	var nextEntrySpeed float64
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := &blocks[i]
+		maxSpeed := math.Sqrt(nextEntrySpeed*nextEntrySpeed + 2*block.acceleration*block.length)
+		block.entrySpeed = math.Min(block.maxEntrySpeed, maxSpeed)
+		nextEntrySpeed = block.entrySpeed
+	}
+
+	// Forward pass: entry speeds that can be reached by accelerating from the previous block.
+	for i := 1; i < len(blocks); i++ {
+		previous := &blocks[i-1]
+		maxSpeed := math.Sqrt(previous.entrySpeed*previous.entrySpeed + 2*previous.acceleration*previous.length)
+		blocks[i].entrySpeed = math.Min(blocks[i].entrySpeed, maxSpeed)
+	}
+
+	estimate := e.estimate
+	estimate.Segments = append([]SegmentEstimate{}, e.estimate.Segments...)
+	estimate.Total = estimate.Dwell
+	for i, block := range blocks {
+		var exitSpeed float64
+		if i+1 < len(blocks) {
+			exitSpeed = blocks[i+1].entrySpeed
+		}
+		seconds := trapezoidTime(block.length, block.entrySpeed, exitSpeed, block.nominalSpeed, block.acceleration)
+		duration := time.Duration(seconds * float64(time.Second))
+		segmentEstimate := &estimate.Segments[block.segmentIndex]
+		segmentEstimate.Duration += duration
+		if segmentEstimate.Segment.Type == SegmentTypeRapid {
+			estimate.Rapid += duration
+		} else {
+			estimate.Cutting += duration
+		}
+		estimate.Total += duration
+	}
+	return &estimate
+}
+
+// EstimateProgram estimates the execution time of all blocks from parser, executed with given
+// Machine.
+func EstimateProgram(parser *Parser, settings PlannerSettings, machine *Machine) (*Estimate, error) {
+	estimator := NewEstimator(settings)
+	for {
+		line := parser.Lexer.Line
+		eof, block, segments, err := machine.Next(parser)
+		if err != nil {
+			return nil, err
+		}
+		if block != nil {
+			if err := estimator.Add(block, segments); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if eof {
+			return estimator.Estimate(), nil
+		}
+	}
+}
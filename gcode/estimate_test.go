@@ -0,0 +1,96 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateProgram(t *testing.T) {
+	settings := PlannerSettings{
+		MaxRate:           Vector{X: 6000, Y: 6000, Z: 600},
+		Acceleration:      Vector{X: 10, Y: 10, Z: 10},
+		JunctionDeviation: 0.01,
+		ArcTolerance:      0.002,
+	}
+
+	for _, tc := range []struct {
+		name     string
+		gcode    string
+		total    time.Duration
+		cutting  time.Duration
+		rapid    time.Duration
+		dwell    time.Duration
+		segments int
+	}{
+		{
+			name:     "trapezoid",
+			gcode:    "G1 X100 F600\n",
+			total:    11 * time.Second,
+			cutting:  11 * time.Second,
+			segments: 1,
+		},
+		{
+			name:     "triangle",
+			gcode:    "G0 X10\n",
+			total:    2 * time.Second,
+			rapid:    2 * time.Second,
+			segments: 1,
+		},
+		{
+			name:     "straight junction",
+			gcode:    "G1 X50 F600\nX100\n",
+			total:    11 * time.Second,
+			cutting:  11 * time.Second,
+			segments: 2,
+		},
+		{
+			name:     "dwell stop",
+			gcode:    "G1 X50 F600\nG4 P1.5\nX100\n",
+			total:    12*time.Second + 1500*time.Millisecond,
+			cutting:  12 * time.Second,
+			dwell:    1500 * time.Millisecond,
+			segments: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := NewParser(strings.NewReader(tc.gcode))
+			estimate, err := EstimateProgram(parser, settings, NewMachine(MachineParameters{}, Vector{}))
+			require.NoError(t, err)
+			require.InDelta(t, tc.total, estimate.Total, float64(time.Millisecond))
+			require.InDelta(t, tc.cutting, estimate.Cutting, float64(time.Millisecond))
+			require.InDelta(t, tc.rapid, estimate.Rapid, float64(time.Millisecond))
+			require.Equal(t, tc.dwell, estimate.Dwell)
+			require.Len(t, estimate.Segments, tc.segments)
+			var total time.Duration
+			for _, segmentEstimate := range estimate.Segments {
+				total += segmentEstimate.Duration
+			}
+			require.InDelta(t, tc.total-tc.dwell, total, float64(time.Millisecond))
+		})
+	}
+}
+
+func TestEstimateProgramJunction(t *testing.T) {
+	settings := PlannerSettings{
+		MaxRate:           Vector{X: 6000, Y: 6000, Z: 600},
+		Acceleration:      Vector{X: 10, Y: 10, Z: 10},
+		JunctionDeviation: 0.01,
+		ArcTolerance:      0.002,
+	}
+	estimate := func(gcode string) time.Duration {
+		parser := NewParser(strings.NewReader(gcode))
+		estimate, err := EstimateProgram(parser, settings, NewMachine(MachineParameters{}, Vector{}))
+		require.NoError(t, err)
+		return estimate.Total
+	}
+	straight := estimate("G1 X50 F600\nX100\n")
+	corner := estimate("G1 X50 F600\nY50\n")
+	reversal := estimate("G1 X50 F600\nX0\n")
+	require.Less(t, straight, corner)
+	require.Less(t, corner, reversal)
+	// Reversal requires a full stop.
+	require.InDelta(t, 12*time.Second, reversal, float64(time.Millisecond))
+}
@@ -0,0 +1,240 @@
+package gcode
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	iFmt "github.com/fornellas/cgs/internal/fmt"
+)
+
+// LineNumbers is how a Formatter handles line numbers (N words).
+type LineNumbers int
+
+const (
+	// Keep existing line numbers.
+	LineNumbersKeep LineNumbers = iota
+	// Remove all line numbers.
+	LineNumbersRemove
+	// Number all command lines, replacing existing line numbers.
+	LineNumbersRenumber
+)
+
+var lineNumbersNames = map[LineNumbers]string{
+	LineNumbersKeep:     "keep",
+	LineNumbersRemove:   "remove",
+	LineNumbersRenumber: "renumber",
+}
+
+func (l LineNumbers) String() string {
+	name, ok := lineNumbersNames[l]
+	if !ok {
+		return fmt.Sprintf("unknown line numbers (%d)", int(l))
+	}
+	return name
+}
+
+// NewLineNumbers returns the LineNumbers for given name ("keep", "remove" or "renumber").
+func NewLineNumbers(name string) (LineNumbers, error) {
+	for lineNumbers, lineNumbersName := range lineNumbersNames {
+		if lineNumbersName == name {
+			return lineNumbers, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown line numbers: %#v", name)
+}
+
+// FormatterOptions configures a Formatter.
+type FormatterOptions struct {
+	// Decimal places for argument values, with trailing zeros removed. When negative, values are
+	// kept as written.
+	Precision int
+	// Separate words and comments with a space.
+	Spaces bool
+	// Use uppercase letters. When false, letters are kept as written.
+	Uppercase bool
+	// How to handle line numbers (N words).
+	LineNumbers LineNumbers
+	// First line number and increment, for LineNumbersRenumber.
+	LineNumberStart     uint
+	LineNumberIncrement uint
+	// Keep comments. When false, lines with only comments are removed.
+	Comments bool
+	// Remove G/M words for modes that are already active, such as G1 for consecutive feed moves.
+	// Only modes set by previous blocks are considered, as the initial machine state is unknown.
+	RemoveRedundantModalWords bool
+}
+
+// DefaultFormatterOptions are sensible defaults for FormatterOptions.
+var DefaultFormatterOptions = FormatterOptions{
+	Precision:           4,
+	Spaces:              true,
+	Uppercase:           true,
+	LineNumbers:         LineNumbersKeep,
+	LineNumberStart:     10,
+	LineNumberIncrement: 10,
+	Comments:            true,
+}
+
+// activeModalWord returns the word active at modalGroup for the modal group of word, or nil if
+// unknown or if word is not modal.
+//
+//gocyclo:ignore
+func activeModalWord(modalGroup *ModalGroup, word *Word) *Word {
+	switch word.NormalizedString() {
+	case "G0", "G1", "G2", "G3", "G80":
+		return modalGroup.Motion
+	case "G17", "G18", "G19":
+		return modalGroup.PlaneSelection
+	case "G90", "G91":
+		return modalGroup.DistanceMode
+	case "G91.1":
+		return modalGroup.ArcIjkDistanceMode
+	case "G93", "G94":
+		return modalGroup.FeedRateMode
+	case "G20", "G21":
+		return modalGroup.Units
+	case "G40":
+		return modalGroup.CutterDiameterCompensation
+	case "G49":
+		if modalGroup.ToolLengthOffset == nil {
+			return nil
+		}
+		return modalGroup.ToolLengthOffset.Words()[0]
+	case "G54", "G55", "G56", "G57", "G58", "G59":
+		return modalGroup.CoordinateSystemSelect
+	case "G61":
+		return modalGroup.ControlMode
+	case "M3", "M4", "M5":
+		return modalGroup.Spindle
+	}
+	return nil
+}
+
+// Formatter formats G-code consistently, as configured by FormatterOptions. Comments are placed
+// after all words of their line. Empty lines are removed.
+type Formatter struct {
+	parser  *Parser
+	options FormatterOptions
+	// Modal state set by previous blocks; nil fields are unknown.
+	modalGroup ModalGroup
+	lineNumber uint
+	eof        bool
+}
+
+// NewFormatter creates a new Formatter.
+func NewFormatter(parser *Parser, options FormatterOptions) *Formatter {
+	return &Formatter{
+		parser:     parser,
+		options:    options,
+		lineNumber: options.LineNumberStart,
+	}
+}
+
+func (f *Formatter) formatWord(word *Word) string {
+	var letter rune
+	var number string
+	if word.originalStr != nil {
+		letter = []rune(*word.originalStr)[0]
+		number = (*word.originalStr)[len(string(letter)):]
+	} else {
+		normalized := word.NormalizedString()
+		letter = word.Letter()
+		number = normalized[1:]
+	}
+	if f.options.Uppercase {
+		letter = unicode.ToUpper(letter)
+	}
+	if f.options.Precision >= 0 {
+		switch {
+		case word.IsCommand():
+			number = word.NormalizedString()[1:]
+		case word.Letter() == 'N':
+			number = iFmt.SprintFloat(word.Number(), 0)
+		default:
+			number = iFmt.SprintFloat(word.Number(), uint(f.options.Precision))
+		}
+	}
+	return string(letter) + number
+}
+
+// formatBlock returns the formatted words of block. Redundant modal words are checked against the
+// modal state from previous blocks.
+func (f *Formatter) formatBlock(block *Block) []string {
+	if block.IsSystem() {
+		return []string{block.StringWithoutComments()}
+	}
+
+	words := []string{}
+	for _, word := range block.Words() {
+		if word.Letter() == 'N' && f.options.LineNumbers != LineNumbersKeep {
+			continue
+		}
+		if f.options.RemoveRedundantModalWords {
+			if active := activeModalWord(&f.modalGroup, word); active != nil && active.Equal(word) {
+				continue
+			}
+		}
+		words = append(words, f.formatWord(word))
+	}
+	if len(words) > 0 && f.options.LineNumbers == LineNumbersRenumber {
+		words = append([]string{"N" + fmt.Sprint(f.lineNumber)}, words...)
+		f.lineNumber += f.options.LineNumberIncrement
+	}
+	return words
+}
+
+// updateModalGroup updates the modal state set by previous blocks with given block.
+func (f *Formatter) updateModalGroup(block *Block) error {
+	if !block.IsCommand() {
+		return nil
+	}
+	for _, word := range block.Commands() {
+		switch word.NormalizedString() {
+		case "M2", "M30":
+			// Program end resets modes to defaults, which may differ from the ones at the start.
+			f.modalGroup = ModalGroup{}
+			return nil
+		}
+	}
+	return f.modalGroup.UpdateFromBlock(block)
+}
+
+// Next returns the next formatted line of G-code, including line ending. Returns nil when the end
+// of input is reached.
+func (f *Formatter) Next() (*string, error) {
+	for !f.eof {
+		lineNumber := f.parser.Lexer.Line
+		eof, block, tokens, err := f.parser.Next()
+		if err != nil {
+			return nil, err
+		}
+		f.eof = eof
+
+		var items []string
+		if block != nil {
+			items = f.formatBlock(block)
+			if err := f.updateModalGroup(block); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+		}
+		if f.options.Comments {
+			for _, token := range tokens {
+				if token.Type == TokenTypeComment {
+					items = append(items, token.String())
+				}
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+
+		separator := ""
+		if f.options.Spaces {
+			separator = " "
+		}
+		line := strings.Join(items, separator) + "\n"
+		return &line, nil
+	}
+	return nil, nil
+}
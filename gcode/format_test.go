@@ -0,0 +1,87 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func format(gcode string, options FormatterOptions) (string, error) {
+	return transformString(NewFormatter(NewParser(strings.NewReader(gcode)), options))
+}
+
+func TestFormatter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		gcode    string
+		options  func(*FormatterOptions)
+		expected string
+	}{
+		{
+			name:     "defaults",
+			gcode:    "(header)\n\ng0x1.23456y2.0\n$H\ng1 z-1 f100 ; plunge\n",
+			expected: "(header)\nG0 X1.2346 Y2\n$H\nG1 Z-1 F100 ; plunge\n",
+		},
+		{
+			name:  "compact",
+			gcode: "G0 X1.5 (move)\n(comment)\n",
+			options: func(o *FormatterOptions) {
+				o.Spaces = false
+				o.Comments = false
+			},
+			expected: "G0X1.5\n",
+		},
+		{
+			name:  "keep as written",
+			gcode: "g0 x1.50000\n",
+			options: func(o *FormatterOptions) {
+				o.Precision = -1
+				o.Uppercase = false
+			},
+			expected: "g0 x1.50000\n",
+		},
+		{
+			name:  "precision",
+			gcode: "G1 X1.26 F100\n",
+			options: func(o *FormatterOptions) {
+				o.Precision = 1
+			},
+			expected: "G1 X1.3 F100\n",
+		},
+		{
+			name:  "renumber",
+			gcode: "N5 G0 X1\n(comment)\nN7 G0 X2\n$X\n",
+			options: func(o *FormatterOptions) {
+				o.LineNumbers = LineNumbersRenumber
+			},
+			expected: "N10 G0 X1\n(comment)\nN20 G0 X2\n$X\n",
+		},
+		{
+			name:  "remove line numbers",
+			gcode: "N5 G0 X1\n",
+			options: func(o *FormatterOptions) {
+				o.LineNumbers = LineNumbersRemove
+			},
+			expected: "G0 X1\n",
+		},
+		{
+			name:  "remove redundant modal words",
+			gcode: "G21 G90 G0 X1\nG21 G0 X2\nG1 Z-1 F100\nG1 X3\nG0\nM3 S1000\nM3 S2000\nM30\nG21 G0 X1\n",
+			options: func(o *FormatterOptions) {
+				o.RemoveRedundantModalWords = true
+			},
+			expected: "G21 G90 G0 X1\nX2\nG1 Z-1 F100\nX3\nG0\nM3 S1000\nS2000\nM30\nG21 G0 X1\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			options := DefaultFormatterOptions
+			if tc.options != nil {
+				tc.options(&options)
+			}
+			output, err := format(tc.gcode, options)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
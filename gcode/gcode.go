@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 	"unicode"
 
 	iFmt "github.com/fornellas/cgs/internal/fmt"
@@ -60,6 +61,8 @@ var wordToName = map[string]string{
 	"G91.1": "Incremental Distance Mode for IJK",
 	"G93":   "Feed Rate Mode Inverse Time",
 	"G94":   "Feed Rate Mode Units per Minute",
+	"G98":   "Canned Cycle Return To Initial Level",
+	"G99":   "Canned Cycle Return To R Level",
 	"M0":    "Program Stop",
 	"M1":    "Optional Program Stop",
 	"M2":    "Program End",
@@ -248,10 +251,39 @@ func (w *Word) IsCommand() bool {
 	return w.letter == 'G' || w.letter == 'M'
 }
 
+// Comment is a parenthesis "(...)" or semicolon ";..." comment, including its delimiters.
+type Comment string
+
+// Text returns the comment text, without delimiters and surrounding spaces.
+func (c Comment) Text() string {
+	text := string(c)
+	switch {
+	case strings.HasPrefix(text, "("):
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "("), ")")
+	case strings.HasPrefix(text, ";"):
+		text = strings.TrimPrefix(text, ";")
+	}
+	return strings.TrimSpace(text)
+}
+
+// Message returns the message of a "(MSG, ...)" comment, which is meant to be displayed to the
+// operator. The returned bool is false when the comment is not a message.
+func (c Comment) Message() (string, bool) {
+	text := c.Text()
+	if len(text) < 4 || !strings.EqualFold(text[:4], "MSG,") {
+		return "", false
+	}
+	return strings.TrimSpace(text[4:]), true
+}
+
 // Block is a line which may include commands to do several different things.
 type Block struct {
 	system *string
 	words  []*Word
+	// leadingComments are comments before the system command or the first word.
+	leadingComments []Comment
+	// trailingComments are comments after the system command or the first word.
+	trailingComments []Comment
 }
 
 func NewBlockSystem(system string) *Block {
@@ -281,7 +313,53 @@ func (b *Block) AppendCommandWords(words ...*Word) {
 	b.words = append(b.words, words...)
 }
 
+// LeadingComments returns the comments before the system command or the first word.
+func (b *Block) LeadingComments() []Comment {
+	return b.leadingComments
+}
+
+// TrailingComments returns the comments after the system command or the first word.
+func (b *Block) TrailingComments() []Comment {
+	return b.trailingComments
+}
+
+// Comments returns all comments of the block, in order.
+func (b *Block) Comments() []Comment {
+	return append(append([]Comment{}, b.leadingComments...), b.trailingComments...)
+}
+
+// SetComments replaces the comments of the block.
+func (b *Block) SetComments(leading, trailing []Comment) {
+	b.leadingComments = leading
+	b.trailingComments = trailing
+}
+
+// Messages returns the messages from "(MSG, ...)" comments of the block.
+func (b *Block) Messages() []string {
+	var messages []string
+	for _, comment := range b.Comments() {
+		if message, ok := comment.Message(); ok {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+// String returns the block with its original words, including comments.
 func (b *Block) String() string {
+	var buff bytes.Buffer
+	for _, c := range b.leadingComments {
+		buff.WriteString(string(c))
+	}
+	buff.WriteString(b.StringWithoutComments())
+	for _, c := range b.trailingComments {
+		buff.WriteString(string(c))
+	}
+	return buff.String()
+}
+
+// StringWithoutComments returns the block with its original words, without comments.
+func (b *Block) StringWithoutComments() string {
 	var buff bytes.Buffer
 	if b.system != nil {
 		buff.WriteString(string(*b.system))
@@ -359,6 +437,20 @@ func (b *Block) SetArgumentNumber(letter rune, number float64) error {
 	return nil
 }
 
+// setArgumentNumber is similar to SetArgumentNumber, but appends a new argument when letter is not
+// present.
+func (b *Block) setArgumentNumber(letter rune, number float64) error {
+	n, err := b.GetArgumentNumber(letter)
+	if err != nil {
+		return err
+	}
+	if n == nil {
+		b.words = append(b.words, NewWord(letter, number))
+		return nil
+	}
+	return b.SetArgumentNumber(letter, number)
+}
+
 // Empty returns true if no system or command is defined.
 func (b *Block) Empty() bool {
 	return b.system == nil && len(b.words) == 0
@@ -390,43 +482,123 @@ func (b *Block) IsEEPROM() bool {
 var rotateXYCommands = map[string]bool{
 	"G0": true, // Coordinated Motion at Rapid Rate
 	"G1": true, // Coordinated Motion at Feed Rate
+	"G2": true, // Coordinated Helical Motion at Feed Rate (clockwise)
+	"G3": true, // Coordinated Helical Motion at Feed Rate (counterclockwise)
 }
 
 var rotateXYIgnoreCommands = map[string]bool{
 	"G4":  true, // Dwell
 	"G17": true, // Plane Select XY
+	"G20": true, // Units Inches
 	"G21": true, // Units Millimeters
 	"G53": true, // Move in machine coordinates
 	"G90": true, // Distance Mode Absolute
+	"G91": true, // Distance Mode Incremental
 	"G94": true, // Feed Rate Mode Units per Minute
 	"M0":  true, // Program pause
 	"M3":  true, // Spindle on (clockwise)
 	"M5":  true, // Spindle stop
 }
 
+// Commands that are unaffected by rotation when they have no X or Y words, such as probing and
+// setting the Z origin.
+var rotateXYZOnlyCommands = map[string]bool{
+	"G38.2": true, // Probe toward workpiece, stop on contact, signal error if failure
+	"G38.3": true, // Probe toward workpiece, stop on contact
+	"G38.4": true, // Probe away from workpiece, stop on loss of contact, signal error if failure
+	"G38.5": true, // Probe away from workpiece, stop on loss of contact
+	"G92":   true, // Coordinate System Offset
+}
+
 // RotateXY rotates work coordinates at the XY plane. Machine coordinates are not affected.
 // cx and cy are the center coordinates for the rotation, radians is the angle (looking down at XY
 // from Z positive to Z negative).
+// Arcs must be at the XY plane (G17): their IJ center offsets are rotated, while R form arcs only
+// have their end point rotated. The arc direction is kept.
+// Motion is incremental (G91) only if set at the block, in which case it is rotated as a vector.
 func (b *Block) RotateXY(cx, cy, radians float64) error {
+	var motion *Word
+	var incremental bool
+	for _, w := range b.Commands() {
+		if _, ok := rotateXYCommands[w.NormalizedString()]; ok {
+			motion = w
+		}
+		switch w.NormalizedString() {
+		case "G90":
+			incremental = false
+		case "G91":
+			incremental = true
+		}
+	}
+	return b.rotateXY(motion, incremental, position{}, cx, cy, radians)
+}
+
+// rotateXY implements RotateXY, given the motion and distance modes for the block, which may be set
+// by previous blocks. start is the XY position before the block, used for motion with a single axis.
+//
+//gocyclo:ignore
+func (b *Block) rotateXY(motion *Word, incremental bool, start position, cx, cy, radians float64) error {
 	if b.system != nil {
 		return fmt.Errorf("%s: can't rotate system commands", b)
 	}
-	var doRotation bool
 	for _, w := range b.Commands() {
 		commandStr := w.NormalizedString()
 		if _, ok := rotateXYIgnoreCommands[commandStr]; ok {
+			if commandStr == "G53" {
+				return nil
+			}
 			continue
 		}
 		if _, ok := rotateXYCommands[commandStr]; ok {
-			doRotation = true
 			continue
 		}
+		if _, ok := rotateXYZOnlyCommands[commandStr]; ok {
+			for _, argument := range b.Arguments() {
+				switch argument.Letter() {
+				case 'X', 'Y':
+					return fmt.Errorf("%s: rotation unsupported for command with X or Y: %s", b, w)
+				}
+			}
+			return nil
+		}
 		return fmt.Errorf("%s: rotation unsupported for command: %s", b, w)
 	}
-	if !doRotation {
+	if motion == nil {
+		return nil
+	}
+	motionStr := motion.NormalizedString()
+	if _, ok := rotateXYCommands[motionStr]; !ok {
 		return nil
 	}
 
+	sin, cos := math.Sin(radians), math.Cos(radians)
+
+	if motionStr == "G2" || motionStr == "G3" {
+		i, err := b.GetArgumentNumber('I')
+		if err != nil {
+			return err
+		}
+		j, err := b.GetArgumentNumber('J')
+		if err != nil {
+			return err
+		}
+		if i != nil || j != nil {
+			var di, dj float64
+			if i != nil {
+				di = *i
+			}
+			if j != nil {
+				dj = *j
+			}
+			if err := b.setArgumentNumber('I', di*cos-dj*sin); err != nil {
+				return err
+			}
+			if err := b.setArgumentNumber('J', di*sin+dj*cos); err != nil {
+				return err
+			}
+		}
+	}
+
 	x, err := b.GetArgumentNumber('X')
 	if err != nil {
 		return err
@@ -438,22 +610,39 @@ func (b *Block) RotateXY(cx, cy, radians float64) error {
 	if x == nil && y == nil {
 		return nil
 	}
-	if x == nil {
-		return fmt.Errorf("%s: rotation unsupported for X without Y", b)
-	}
-	if y == nil {
-		return fmt.Errorf("%s: rotation unsupported for Y without X", b)
+
+	if incremental {
+		// Incremental motion is a vector, rotated without center.
+		cx, cy = 0, 0
+		if x == nil {
+			x = new(float64)
+		}
+		if y == nil {
+			y = new(float64)
+		}
+	} else {
+		if x == nil {
+			if start.x == nil {
+				return fmt.Errorf("%s: rotation unsupported for Y without X when X position is unknown", b)
+			}
+			x = start.x
+		}
+		if y == nil {
+			if start.y == nil {
+				return fmt.Errorf("%s: rotation unsupported for X without Y when Y position is unknown", b)
+			}
+			y = start.y
+		}
 	}
 
-	sin, cos := math.Sin(radians), math.Cos(radians)
 	dx, dy := *x-cx, *y-cy
 	rx := dx*cos - dy*sin + cx
 	ry := dx*sin + dy*cos + cy
 
-	if err := b.SetArgumentNumber('X', rx); err != nil {
+	if err := b.setArgumentNumber('X', rx); err != nil {
 		return err
 	}
-	if err := b.SetArgumentNumber('Y', ry); err != nil {
+	if err := b.setArgumentNumber('Y', ry); err != nil {
 		return err
 	}
 
@@ -26,3 +26,28 @@ func TestWordNormalizedString(t *testing.T) {
 		})
 	}
 }
+
+func TestCommentMessage(t *testing.T) {
+	testCases := []struct {
+		comment Comment
+		text    string
+		message string
+		ok      bool
+	}{
+		{"(foo)", "foo", "", false},
+		{"; foo ", "foo", "", false},
+		{"(MSG, Change tool)", "MSG, Change tool", "Change tool", true},
+		{"(msg,Insert probe)", "msg,Insert probe", "Insert probe", true},
+		{";MSG, Done", "MSG, Done", "Done", true},
+		{"(MSGX)", "MSGX", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.comment), func(t *testing.T) {
+			require.Equal(t, tc.text, tc.comment.Text())
+			message, ok := tc.comment.Message()
+			require.Equal(t, tc.ok, ok)
+			require.Equal(t, tc.message, message)
+		})
+	}
+}
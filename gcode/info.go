@@ -0,0 +1,265 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Range holds the minimum and maximum of a value.
+type Range struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+func (r *Range) add(v float64) *Range {
+	if r == nil {
+		return &Range{Min: v, Max: v}
+	}
+	r.Min = math.Min(r.Min, v)
+	r.Max = math.Max(r.Max, v)
+	return r
+}
+
+// BoundingBox is an axis aligned box.
+type BoundingBox struct {
+	Min Vector `json:"min"`
+	Max Vector `json:"max"`
+}
+
+func (b *BoundingBox) add(v Vector) *BoundingBox {
+	if b == nil {
+		return &BoundingBox{Min: v, Max: v}
+	}
+	b.Min = Vector{X: math.Min(b.Min.X, v.X), Y: math.Min(b.Min.Y, v.Y), Z: math.Min(b.Min.Z, v.Z)}
+	b.Max = Vector{X: math.Max(b.Max.X, v.X), Y: math.Max(b.Max.Y, v.Y), Z: math.Max(b.Max.Z, v.Z)}
+	return b
+}
+
+// WordInfo is the usage of a G/M word.
+type WordInfo struct {
+	Word  string `json:"word"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Pause is a program pause (M0).
+type Pause struct {
+	Line uint `json:"line"`
+	// Comment preceding the pause, which usually tells the operator what to do.
+	Comment string `json:"comment,omitempty"`
+}
+
+// Metadata is a key / value pair from a program header comment, such as the ones generated by
+// FlatCAM (eg: "(TOOL DIAMETER: 0.3 mm)").
+type Metadata struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Info summarizes a program.
+type Info struct {
+	// Bounding box of motion in work coordinates, in millimeters. Machine coordinates motion (G53,
+	// G28 and G30) and probing targets are not included, neither are axes at an unknown position,
+	// such as before the program sets them: axes it never sets are zero. Nil when there's no motion.
+	BoundingBox *BoundingBox `json:"bounding_box"`
+	// Distance for motion at feed rate, including arcs and probing, in millimeters.
+	CuttingDistance float64 `json:"cutting_distance"`
+	// Distance for motion at rapid rate, in millimeters.
+	RapidDistance float64 `json:"rapid_distance"`
+	// Feed rate range for motion at feed rate, in millimeters per minute. Nil when there's no such motion.
+	FeedRate *Range `json:"feed_rate"`
+	// Spindle speed range for motion with the spindle on. Nil when there's no such motion.
+	SpindleSpeed *Range `json:"spindle_speed"`
+	// G/M words used, in order of first usage.
+	Words []WordInfo `json:"words"`
+	// Program pauses (M0).
+	Pauses []Pause `json:"pauses"`
+	// Tool numbers (T), in order of first usage.
+	Tools []float64 `json:"tools"`
+	// Metadata from comments before the first command.
+	Metadata []Metadata `json:"metadata"`
+}
+
+// MetadataValue returns the value for given metadata key, or nil if not present.
+func (i *Info) MetadataValue(key string) *string {
+	for _, m := range i.Metadata {
+		if m.Key == key {
+			return &m.Value
+		}
+	}
+	return nil
+}
+
+var metadataKeyRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_ ,]*$`)
+
+// metadataValueRegexp matches comments without a colon, such as "Feedrate rapids 1500.0 mm/min".
+var metadataValueRegexp = regexp.MustCompile(`^([A-Za-z][A-Za-z_ ]*?)\s+(-?[0-9.]+(?:\s*[A-Za-z/]+)?)$`)
+
+// parseMetadata parses comment text as metadata, returning nil if it isn't.
+func parseMetadata(text string) *Metadata {
+	if key, value, ok := strings.Cut(text, ": "); ok {
+		key = strings.TrimSpace(key)
+		if !metadataKeyRegexp.MatchString(key) {
+			return nil
+		}
+		return &Metadata{Key: key, Value: strings.TrimSpace(value)}
+	}
+	if matches := metadataValueRegexp.FindStringSubmatch(text); matches != nil {
+		return &Metadata{Key: matches[1], Value: matches[2]}
+	}
+	return nil
+}
+
+// infoBuilder accumulates Info from executed blocks.
+type infoBuilder struct {
+	info      Info
+	wordIndex map[string]int
+	header    bool
+	comment   string
+	// Range of each axis (X, Y, Z) for the bounding box.
+	axes [3]*Range
+}
+
+// addComments process comments for the tokens of a line.
+func (b *infoBuilder) addComments(tokens Tokens) {
+	for _, comment := range tokens.Comments() {
+		text := comment.Text()
+		if text == "" {
+			continue
+		}
+		b.comment = text
+		if !b.header {
+			continue
+		}
+		metadata := parseMetadata(text)
+		if metadata == nil || b.info.MetadataValue(metadata.Key) != nil {
+			continue
+		}
+		b.info.Metadata = append(b.info.Metadata, *metadata)
+	}
+}
+
+// addBlock process a command block, executed at given line.
+func (b *infoBuilder) addBlock(line uint, block *Block) error {
+	b.header = false
+	for _, w := range block.Commands() {
+		word := w.NormalizedString()
+		if i, ok := b.wordIndex[word]; ok {
+			b.info.Words[i].Count++
+		} else {
+			b.wordIndex[word] = len(b.info.Words)
+			b.info.Words = append(b.info.Words, WordInfo{Word: word, Name: WordName(word), Count: 1})
+		}
+		if word == "M0" {
+			b.info.Pauses = append(b.info.Pauses, Pause{Line: line, Comment: b.comment})
+		}
+	}
+	t, err := block.GetArgumentNumber('T')
+	if err != nil {
+		return err
+	}
+	if t != nil && !slices.Contains(b.info.Tools, *t) {
+		b.info.Tools = append(b.info.Tools, *t)
+	}
+	b.comment = ""
+	return nil
+}
+
+// machineCoordinatesCommands are commands whose motion targets are in machine coordinates.
+var machineCoordinatesCommands = []string{"G28", "G30", "G53"}
+
+// addSegments process the segments of a block.
+func (b *infoBuilder) addSegments(block *Block, segments []Segment) {
+	var machineCoordinates bool
+	for _, w := range block.Commands() {
+		if slices.Contains(machineCoordinatesCommands, w.NormalizedString()) {
+			machineCoordinates = true
+		}
+	}
+	for _, segment := range segments {
+		switch segment.Type {
+		case SegmentTypeRapid:
+			b.info.RapidDistance += segment.Length()
+		default:
+			b.info.CuttingDistance += segment.Length()
+			b.info.FeedRate = b.info.FeedRate.add(segment.FeedRate)
+			if segment.Spindle != nil && segment.Spindle.NormalizedString() != "M5" {
+				b.info.SpindleSpeed = b.info.SpindleSpeed.add(segment.SpindleSpeed)
+			}
+		}
+		if machineCoordinates || segment.Type == SegmentTypeProbe {
+			continue
+		}
+		if !segment.StartKnown() {
+			b.addPoint(segment.End.Sub(segment.WorkCoordinateOffset), segment.endKnown)
+			continue
+		}
+		// Exact enough for a bounding box, without generating too many points.
+		for _, point := range segment.Points(0.001) {
+			b.addPoint(point.Sub(segment.WorkCoordinateOffset), [3]bool{true, true, true})
+		}
+	}
+}
+
+// addPoint adds the known axes of a point to the bounding box.
+func (b *infoBuilder) addPoint(point Vector, known [3]bool) {
+	for i := range b.axes {
+		if known[i] {
+			b.axes[i] = b.axes[i].add(point.axis(i))
+		}
+	}
+}
+
+// boundingBox returns the bounding box from the range of each axis, or nil if there's none.
+func (b *infoBuilder) boundingBox() *BoundingBox {
+	if b.axes == [3]*Range{} {
+		return nil
+	}
+	boundingBox := &BoundingBox{}
+	for i, r := range b.axes {
+		if r != nil {
+			boundingBox.Min.setAxis(i, r.Min)
+			boundingBox.Max.setAxis(i, r.Max)
+		}
+	}
+	return boundingBox
+}
+
+// GetInfo returns Info for all blocks from parser, executed with given Machine.
+func GetInfo(parser *Parser, machine *Machine) (*Info, error) {
+	builder := infoBuilder{
+		info: Info{
+			Words:    []WordInfo{},
+			Pauses:   []Pause{},
+			Tools:    []float64{},
+			Metadata: []Metadata{},
+		},
+		wordIndex: map[string]int{},
+		header:    true,
+	}
+	for {
+		line := parser.Lexer.Line
+		eof, block, tokens, err := parser.Next()
+		if err != nil {
+			return nil, err
+		}
+		builder.addComments(tokens)
+		if block != nil && block.IsCommand() {
+			if err := builder.addBlock(line, block); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			segments, err := machine.Execute(block)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			builder.addSegments(block, segments)
+		}
+		if eof {
+			builder.info.BoundingBox = builder.boundingBox()
+			return &builder.info, nil
+		}
+	}
+}
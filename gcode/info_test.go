@@ -0,0 +1,59 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetInfo(t *testing.T) {
+	gcode := `(TOOL DIAMETER: 0.3 mm)
+(Feedrate rapids 1500.0 mm/min)
+(Z_Cut: -0.15 mm)
+(Remove clamps)
+M0
+G21 G90
+G92 X10 Y10 Z0
+G0 Z1
+T2
+(Insert bit)
+M0
+M3 S10000
+G1 Z-0.15 F60
+G1 X20 F120
+G0 Z1
+G53 G0 Z-1
+M5
+(Not metadata: 1)
+`
+	info, err := GetInfo(NewParser(strings.NewReader(gcode)), NewMachine(MachineParameters{}, Vector{}))
+	require.NoError(t, err)
+
+	require.Equal(t, &BoundingBox{Min: Vector{X: 10, Y: 10, Z: -0.15}, Max: Vector{X: 20, Y: 10, Z: 1}}, info.BoundingBox)
+	require.InDelta(t, 11.15, info.CuttingDistance, 1e-9)
+	require.InDelta(t, 1+1.15+2, info.RapidDistance, 1e-9)
+	require.Equal(t, &Range{Min: 60, Max: 120}, info.FeedRate)
+	require.Equal(t, &Range{Min: 10000, Max: 10000}, info.SpindleSpeed)
+	require.Equal(t, []Pause{{Line: 5, Comment: "Remove clamps"}, {Line: 11, Comment: "Insert bit"}}, info.Pauses)
+	require.Equal(t, []float64{2}, info.Tools)
+	require.Equal(t, []Metadata{
+		{Key: "TOOL DIAMETER", Value: "0.3 mm"},
+		{Key: "Feedrate rapids", Value: "1500.0 mm/min"},
+		{Key: "Z_Cut", Value: "-0.15 mm"},
+	}, info.Metadata)
+
+	var words []string
+	for _, w := range info.Words {
+		words = append(words, w.Word)
+	}
+	require.Equal(t, []string{"M0", "G21", "G90", "G92", "G0", "M3", "G1", "G53", "M5"}, words)
+	require.Equal(t, WordInfo{Word: "M0", Name: "Program Stop", Count: 2}, info.Words[0])
+}
+
+func TestGetInfoUnknownPosition(t *testing.T) {
+	gcode := "G21 G90\nG0 Z1\nG0 X20 Y30\nG1 Z-0.1 F100\nG1 X25 Y35\nG0 Z1\n"
+	info, err := GetInfo(NewParser(strings.NewReader(gcode)), NewMachineAtUnknownPosition(MachineParameters{}))
+	require.NoError(t, err)
+	require.Equal(t, &BoundingBox{Min: Vector{X: 20, Y: 30, Z: -0.1}, Max: Vector{X: 25, Y: 35, Z: 1}}, info.BoundingBox)
+}
@@ -0,0 +1,146 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+)
+
+// HeightMap gives the surface Z deviation at XY work coordinates (eg: grbl.HeightMap).
+type HeightMap interface {
+	// GetInterpolatedValue returns the Z deviation at given x, y, or nil if it is outside the map.
+	GetInterpolatedValue(x, y float64) *float64
+}
+
+// LevelZ compensates the Z work coordinate of G0/G1 motion by the deviation given by a HeightMap,
+// so that a program follows a surface that is not flat (eg: a warped PCB blank).
+// G1 motion longer than a maximum length is split into segments, so the compensation follows the
+// surface between probed points. G0 motion is not split, and it is left uncompensated when its
+// target is outside the height map. Motion is only compensated once the XYZ position is known,
+// which requires an absolute G0/G1 move to set each axis first.
+type LevelZ struct {
+	parser            *Parser
+	heightMap         HeightMap
+	maxSegmentLength  float64
+	initialModalGroup *ModalGroup
+	positionTracker   *positionTracker
+}
+
+// NewLevelZ creates a new LevelZ.
+// maxSegmentLength is the maximum XY length for each G1 segment, or 0 to disable splitting.
+func NewLevelZ(parser *Parser, heightMap HeightMap, maxSegmentLength float64) *LevelZ {
+	return &LevelZ{
+		parser:            parser,
+		heightMap:         heightMap,
+		maxSegmentLength:  maxSegmentLength,
+		initialModalGroup: parser.ModalGroup.Copy(),
+		positionTracker:   newPositionTracker(),
+	}
+}
+
+func (l *LevelZ) compensate(x, y, z float64) *float64 {
+	dz := l.heightMap.GetInterpolatedValue(x, y)
+	if dz == nil {
+		return nil
+	}
+	cz := z + *dz
+	return &cz
+}
+
+func (l *LevelZ) getSegmentBlocks(
+	block *Block,
+	x0, y0, z0, x1, y1, z1 float64,
+	segments int,
+) ([]*Block, error) {
+	var blocks []*Block
+	for i := 1; i <= segments; i++ {
+		t := float64(i) / float64(segments)
+		x := x0 + (x1-x0)*t
+		y := y0 + (y1-y0)*t
+		z := z0 + (z1-z0)*t
+		if i == segments {
+			x, y, z = x1, y1, z1
+		}
+
+		cz := l.compensate(x, y, z)
+		if cz == nil {
+			return nil, fmt.Errorf("%s: X%.4f Y%.4f is outside the height map", block, x, y)
+		}
+
+		var words []*Word
+		if i == 1 {
+			for _, w := range block.Words() {
+				switch w.Letter() {
+				case 'X', 'Y', 'Z':
+					continue
+				}
+				words = append(words, w)
+			}
+		}
+		words = append(words, NewWord('X', x), NewWord('Y', y), NewWord('Z', *cz))
+		blocks = append(blocks, NewBlockCommand(words...))
+	}
+	return blocks, nil
+}
+
+// levelBlock returns the blocks that replace the given block, or nil if it must be kept unchanged.
+func (l *LevelZ) levelBlock(block *Block) ([]*Block, error) {
+	if !l.parser.ModalGroup.Units.Equal(l.initialModalGroup.Units) {
+		return nil, fmt.Errorf("%s: unit change unsupported", block)
+	}
+
+	if l.parser.ModalGroup.DistanceMode.NormalizedString() != "G90" {
+		return nil, fmt.Errorf("%s: distance mode incremental unsupported", block)
+	}
+
+	start, motion, err := l.positionTracker.next(&l.parser.ModalGroup, block)
+	if err != nil {
+		return nil, err
+	}
+	if !motion {
+		return nil, nil
+	}
+
+	motionStr := l.parser.ModalGroup.Motion.NormalizedString()
+	switch motionStr {
+	case "G0", "G1":
+	case "G2", "G3":
+		return nil, fmt.Errorf("%s: arc motion unsupported, it must be linearized first", block)
+	default:
+		return nil, fmt.Errorf("%s: unsupported motion: %s", block, motionStr)
+	}
+	if motionStr == "G1" && l.parser.ModalGroup.FeedRateMode.NormalizedString() == "G93" {
+		return nil, fmt.Errorf("%s: feed rate mode inverse time unsupported", block)
+	}
+
+	end := l.positionTracker.current(&l.parser.ModalGroup)
+	if !end.known() {
+		return nil, nil
+	}
+	x1, y1, z1 := *end.x, *end.y, *end.z
+
+	if motionStr == "G0" {
+		if l.compensate(x1, y1, z1) == nil {
+			return nil, nil
+		}
+		return l.getSegmentBlocks(block, x1, y1, z1, x1, y1, z1, 1)
+	}
+
+	if !start.known() {
+		return l.getSegmentBlocks(block, x1, y1, z1, x1, y1, z1, 1)
+	}
+	x0, y0, z0 := *start.x, *start.y, *start.z
+
+	segments := 1
+	if l.maxSegmentLength > 0 {
+		length := math.Hypot(x1-x0, y1-y0)
+		segments = max(1, int(math.Ceil(length/l.maxSegmentLength)))
+	}
+	return l.getSegmentBlocks(block, x0, y0, z0, x1, y1, z1, segments)
+}
+
+// Next returns the next line(s) of G-code, with the Z coordinate of G0/G1 motion compensated.
+// Units and distance mode incremental are unsupported, and arcs must be linearized beforehand.
+// Returns nil when the end of input is reached.
+func (l *LevelZ) Next() (*string, error) {
+	return blockTransformNext(l.parser, l.levelBlock)
+}
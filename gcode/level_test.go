@@ -0,0 +1,101 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testHeightMap struct{}
+
+// GetInterpolatedValue implements a plane rising 0.1 on Z for each 1 on X, for 0 <= X,Y <= 10.
+func (h testHeightMap) GetInterpolatedValue(x, y float64) *float64 {
+	if x < 0 || x > 10 || y < 0 || y > 10 {
+		return nil
+	}
+	z := x * 0.1
+	return &z
+}
+
+func levelZ(gcode string, maxSegmentLength float64) (string, error) {
+	return transformString(NewLevelZ(NewParser(strings.NewReader(gcode)), testHeightMap{}, maxSegmentLength))
+}
+
+func TestLevelZ(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		gcode            string
+		maxSegmentLength float64
+		expected         string
+		errorContains    string
+	}{
+		{
+			name:             "split feed motion",
+			gcode:            "(start)\nG0 X0 Y0 Z1\nG1 F100 Z-0.1\nG1 X10\n",
+			maxSegmentLength: 2.5,
+			expected: "(start)\n" +
+				"G0X0Y0Z1\n" +
+				"G1F100X0Y0Z-0.1\n" +
+				"G1X2.5Y0Z0.15\n" +
+				"X5Y0Z0.4\n" +
+				"X7.5Y0Z0.65\n" +
+				"X10Y0Z0.9\n",
+		},
+		{
+			name:             "no split",
+			gcode:            "G0 X0 Y0 Z1\nG1 X10 Z0\n",
+			maxSegmentLength: 0,
+			expected:         "G0X0Y0Z1\nG1X10Y0Z1\n",
+		},
+		{
+			name:             "unknown position",
+			gcode:            "G0 Z5\nG0 X2 Y2\n",
+			maxSegmentLength: 1,
+			expected:         "G0 Z5\nG0X2Y2Z5.2\n",
+		},
+		{
+			name:             "rapid outside height map",
+			gcode:            "G0 X20 Y0 Z5\nG0 X1\n",
+			maxSegmentLength: 1,
+			expected:         "G0 X20 Y0 Z5\nG0X1Y0Z5.1\n",
+		},
+		{
+			name:             "position lost",
+			gcode:            "G0 X1 Y1 Z1\nG53 G0 Z0\nG1 X2 F10\n",
+			maxSegmentLength: 1,
+			expected:         "G0X1Y1Z1.1\nG53 G0 Z0\nG1 X2 F10\n",
+		},
+		{
+			name:             "feed outside height map",
+			gcode:            "G0 X0 Y0 Z0\nG1 X20 F10\n",
+			maxSegmentLength: 5,
+			errorContains:    "X15.0000 Y0.0000 is outside the height map",
+		},
+		{
+			name:          "arc",
+			gcode:         "G0 X0 Y0 Z0\nG2 X2 Y0 I1 J0\n",
+			errorContains: "arc motion unsupported",
+		},
+		{
+			name:          "incremental",
+			gcode:         "G91\n",
+			errorContains: "distance mode incremental unsupported",
+		},
+		{
+			name:          "units",
+			gcode:         "G20\n",
+			errorContains: "unit change unsupported",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := levelZ(tc.gcode, tc.maxSegmentLength)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
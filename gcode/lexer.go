@@ -56,6 +56,17 @@ func (ts Tokens) String() string {
 	return buf.String()
 }
 
+// Comments returns all comment tokens.
+func (ts Tokens) Comments() []Comment {
+	var comments []Comment
+	for _, token := range ts {
+		if token.Type == TokenTypeComment {
+			comments = append(comments, Comment(token.Value))
+		}
+	}
+	return comments
+}
+
 // Lexer tokenizes G-Code in Grbl flavour.
 type Lexer struct {
 	// Line the lexer is in
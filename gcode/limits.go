@@ -0,0 +1,76 @@
+package gcode
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Limits is the machine travel envelope, in machine coordinates millimeters, similar to Grbl soft
+// limits.
+type Limits struct {
+	Min Vector
+	Max Vector
+	// Home is the machine position after a homing cycle ($H).
+	Home Vector
+}
+
+// outsideAxes returns the letters of the axes at which v is outside limits.
+func (l Limits) outsideAxes(v Vector) string {
+	var axes strings.Builder
+	for i, letter := range axisLetters {
+		// Tolerate floating point error, as coordinates at the limit are valid.
+		const epsilon = 1e-6
+		if v.axis(i) < l.Min.axis(i)-epsilon || v.axis(i) > l.Max.axis(i)+epsilon {
+			axes.WriteRune(letter)
+		}
+	}
+	return axes.String()
+}
+
+// Contains returns whether v is within limits.
+func (l Limits) Contains(v Vector) bool {
+	return l.outsideAxes(v) == ""
+}
+
+// LimitsViolation is a motion segment that leaves Limits.
+type LimitsViolation struct {
+	Segment Segment
+	// Position is the first point of the segment outside limits, in machine coordinates.
+	Position Vector
+	// Axes are the letters of the axes outside limits at Position.
+	Axes string
+}
+
+func (v LimitsViolation) String() string {
+	return fmt.Sprintf(
+		"line %d: %s: %s outside limits at machine position X%.4f Y%.4f Z%.4f",
+		v.Segment.Line, v.Segment.Block, v.Axes, v.Position.X, v.Position.Y, v.Position.Z,
+	)
+}
+
+// CheckLimits executes all blocks from parser with given Machine, returning all segments that move
+// outside limits. Arcs are checked at points within given chordal tolerance in millimeters, similar
+// to how Grbl executes them. Homing cycles ($H) move the machine to Limits.Home.
+func CheckLimits(parser *Parser, machine *Machine, limits Limits, tolerance float64) ([]LimitsViolation, error) {
+	violations := []LimitsViolation{}
+	for {
+		eof, block, segments, err := machine.Next(parser)
+		if err != nil {
+			return nil, err
+		}
+		if block != nil && block.IsSystem() && strings.HasPrefix(strings.ToUpper(block.StringWithoutComments()), "$H") {
+			machine.Position = limits.Home
+		}
+		for _, segment := range segments {
+			for _, point := range segment.Points(tolerance) {
+				if axes := limits.outsideAxes(point); axes != "" {
+					violations = append(violations, LimitsViolation{Segment: segment, Position: point, Axes: axes})
+					break
+				}
+			}
+		}
+		if eof {
+			return violations, nil
+		}
+	}
+}
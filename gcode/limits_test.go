@@ -0,0 +1,63 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckLimits(t *testing.T) {
+	limits := Limits{
+		Min:  Vector{X: -200, Y: -200, Z: -50},
+		Max:  Vector{X: 0, Y: 0, Z: 0},
+		Home: Vector{X: -1, Y: -1, Z: -1},
+	}
+	parameters := MachineParameters{}
+	parameters.CoordinateSystems[0] = Vector{X: -150, Y: -150, Z: -40}
+
+	for _, tc := range []struct {
+		name  string
+		gcode string
+		lines []uint
+		axes  []string
+	}{
+		{
+			name:  "within",
+			gcode: "G0 X0 Y0\nG1 Z-10 F100\nG1 X150 Y150\nG53 G0 Z0\n",
+		},
+		{
+			name:  "feed",
+			gcode: "G0 X0 Y0\nG1 Z-20 F100\nG1 X160\n",
+			lines: []uint{2, 3},
+			axes:  []string{"Z", "XZ"},
+		},
+		{
+			name:  "arc",
+			gcode: "G0 X140 Y0\nG3 X140 Y30 I0 J15\n",
+			lines: []uint{2},
+			axes:  []string{"X"},
+		},
+		{
+			name:  "homing",
+			gcode: "G91 G0 X2\n$H\nG0 X-2\n",
+			lines: []uint{1},
+			axes:  []string{"X"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			violations, err := CheckLimits(
+				NewParser(strings.NewReader(tc.gcode)), NewMachine(parameters, limits.Home), limits, 0.002,
+			)
+			require.NoError(t, err)
+			var lines []uint
+			var axes []string
+			for _, violation := range violations {
+				lines = append(lines, violation.Segment.Line)
+				axes = append(axes, violation.Axes)
+			}
+			require.Equal(t, tc.lines, lines)
+			require.Equal(t, tc.axes, axes)
+		})
+	}
+}
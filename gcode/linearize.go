@@ -0,0 +1,188 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+)
+
+// roundArgument rounds a number to the 4 decimal places used by Word.NormalizedString for arguments.
+func roundArgument(v float64) float64 {
+	v = math.Round(v*1e4) / 1e4
+	if v == 0 {
+		// Avoids -0
+		return 0
+	}
+	return v
+}
+
+// Linearize converts G2/G3 arc motion into chains of G1 segments, whose distance from the arc (the
+// chordal error) is within a given tolerance, similar to how Grbl executes arcs. All arc planes (G17,
+// G18 and G19), helical motion, IJK (G91.1) and radius (R) forms and both distance modes are supported.
+// All other blocks are passed through unchanged.
+type Linearize struct {
+	parser          *Parser
+	tolerance       float64
+	positionTracker *positionTracker
+}
+
+// NewLinearize creates a new Linearize.
+// tolerance is the maximum chordal error in millimeters, similar to Grbl's $12 arc tolerance.
+func NewLinearize(parser *Parser, tolerance float64) *Linearize {
+	return &Linearize{
+		parser:          parser,
+		tolerance:       tolerance,
+		positionTracker: newPositionTracker(),
+	}
+}
+
+// unitsFactor returns the factor to convert millimeters to the current units.
+func (l *Linearize) unitsFactor() float64 {
+	if l.parser.ModalGroup.Units.NormalizedString() == "G20" {
+		return 1 / 25.4
+	}
+	return 1
+}
+
+// getArcPoints returns the points of the G1 segments approximating an arc, relative to the arc
+// start. Point coordinates follow XYZ order, and the last point is exactly at the end.
+func (l *Linearize) getArcPoints(block *Block, plane arcPlane, end [3]float64, clockwise bool) ([][3]float64, error) {
+	unitsFactor := l.unitsFactor()
+	geometry, err := getArcGeometry(block, plane, end, clockwise, unitsFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := geometry.segments(l.tolerance * unitsFactor)
+	points := make([][3]float64, segments)
+	for i := 1; i < segments; i++ {
+		points[i-1] = geometry.point(plane, end, float64(i)/float64(segments))
+	}
+	points[segments-1] = end
+	return points, nil
+}
+
+// linearizeArc returns the G1 blocks approximating the arc at given block.
+//
+//gocyclo:ignore
+func (l *Linearize) linearizeArc(block *Block, start position, clockwise bool) ([]*Block, error) {
+	modalGroup := &l.parser.ModalGroup
+	plane, ok := arcPlanes[modalGroup.PlaneSelection.NormalizedString()]
+	if !ok {
+		return nil, fmt.Errorf("%s: unsupported plane selection: %s", block, modalGroup.PlaneSelection)
+	}
+
+	axes, err := getAxes(block)
+	if err != nil {
+		return nil, err
+	}
+	axesValues := [3]*float64{axes.x, axes.y, axes.z}
+	startValues := [3]*float64{start.x, start.y, start.z}
+	incremental := modalGroup.DistanceMode.NormalizedString() == "G91"
+
+	// Arc end relative to start.
+	var end [3]float64
+	var startAbsolute [3]float64
+	for i := range 3 {
+		if incremental {
+			if axesValues[i] != nil {
+				end[i] = *axesValues[i]
+			}
+			continue
+		}
+		if i != plane.axis0 && i != plane.axis1 && axesValues[i] == nil {
+			continue
+		}
+		if startValues[i] == nil {
+			return nil, fmt.Errorf("%s: arc start position unknown for %c", block, axisLetters[i])
+		}
+		startAbsolute[i] = *startValues[i]
+		if axesValues[i] != nil {
+			end[i] = *axesValues[i] - startAbsolute[i]
+		}
+	}
+
+	points, err := l.getArcPoints(block, plane, end, clockwise)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", block, err)
+	}
+
+	var feedRate *float64
+	inverseTime := modalGroup.FeedRateMode.NormalizedString() == "G93"
+	if inverseTime {
+		feedRate, err = block.GetArgumentNumber('F')
+		if err != nil {
+			return nil, err
+		}
+		if feedRate == nil {
+			return nil, fmt.Errorf("%s: feed rate required in inverse time mode", block)
+		}
+	}
+
+	blocks := make([]*Block, 0, len(points))
+	var previous [3]float64
+	for n, point := range points {
+		var words []*Word
+		if n == 0 {
+			words = append(words, NewWord('G', 1))
+			for _, w := range block.Words() {
+				switch w.Letter() {
+				case 'X', 'Y', 'Z', 'I', 'J', 'K', 'R':
+					continue
+				case 'F':
+					if inverseTime {
+						continue
+					}
+				case 'G':
+					switch w.NormalizedString() {
+					case "G2", "G3":
+						continue
+					}
+				}
+				words = append(words, w)
+			}
+		}
+		for i := range 3 {
+			if i != plane.axis0 && i != plane.axis1 && axesValues[i] == nil {
+				continue
+			}
+			if incremental {
+				// Rounded first, so that increments add up exactly to the end point.
+				words = append(words, NewWord(axisLetters[i], roundArgument(point[i])-roundArgument(previous[i])))
+			} else {
+				words = append(words, NewWord(axisLetters[i], roundArgument(startAbsolute[i]+point[i])))
+			}
+		}
+		if inverseTime {
+			words = append(words, NewWord('F', *feedRate*float64(len(points))))
+		}
+		previous = point
+		blocks = append(blocks, NewBlockCommand(words...))
+	}
+	return blocks, nil
+}
+
+// linearizeBlock returns the blocks that replace the given block, or nil if it must be kept unchanged.
+func (l *Linearize) linearizeBlock(block *Block) ([]*Block, error) {
+	start, motion, err := l.positionTracker.next(&l.parser.ModalGroup, block)
+	if err != nil {
+		return nil, err
+	}
+	if !motion {
+		return nil, nil
+	}
+
+	switch l.parser.ModalGroup.Motion.NormalizedString() {
+	case "G2":
+		return l.linearizeArc(block, start, true)
+	case "G3":
+		return l.linearizeArc(block, start, false)
+	default:
+		return nil, nil
+	}
+}
+
+// Next returns the next line(s) of G-code, with G2/G3 arcs replaced by G1 segments. Returns nil when
+// the end of input is reached.
+func (l *Linearize) Next() (*string, error) {
+	return blockTransformNext(l.parser, l.linearizeBlock)
+}
@@ -0,0 +1,141 @@
+package gcode
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func linearize(gcode string, tolerance float64) (string, error) {
+	return transformString(NewLinearize(NewParser(strings.NewReader(gcode)), tolerance))
+}
+
+func TestLinearize(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		gcode         string
+		tolerance     float64
+		expected      string
+		errorContains string
+	}{
+		{
+			name:      "quarter circle clockwise",
+			gcode:     "G0 X0 Y1\nG2 X1 Y0 I0 J-1 F100 M3\nG0 X0\n",
+			tolerance: 0.05,
+			expected: "G0 X0 Y1\n" +
+				"G1F100M3X0.5Y0.866\n" +
+				"X0.866Y0.5\n" +
+				"X1Y0\n" +
+				"G0 X0\n",
+		},
+		{
+			name:      "radius form counterclockwise",
+			gcode:     "G0 X1 Y0\nG3 X0 Y1 R1\n",
+			tolerance: 0.1,
+			expected: "G0 X1 Y0\n" +
+				"G1X0.7071Y0.7071\n" +
+				"X0Y1\n",
+		},
+		{
+			name:      "radius form long arc",
+			gcode:     "G0 X1 Y0\nG3 X0 Y-1 R-1\n",
+			tolerance: 0.5,
+			expected: "G0 X1 Y0\n" +
+				"G1X0Y1\n" +
+				"X-1Y0\n" +
+				"X0Y-1\n",
+		},
+		{
+			name:      "full circle helix",
+			gcode:     "G0 X1 Y0 Z0\nG3 Z-1 I-1\n",
+			tolerance: 0.5,
+			expected: "G0 X1 Y0 Z0\n" +
+				"G1X-0.5Y0.866Z-0.3333\n" +
+				"X-0.5Y-0.866Z-0.6667\n" +
+				"X1Y0Z-1\n",
+		},
+		{
+			name:      "G18",
+			gcode:     "G18 G0 X0 Y0 Z1\nG3 X1 Z0 K-1\n",
+			tolerance: 0.1,
+			expected: "G18 G0 X0 Y0 Z1\n" +
+				"G1X0.7071Z0.7071\n" +
+				"X1Z0\n",
+		},
+		{
+			name:      "G19",
+			gcode:     "G19 G0 X0 Y0 Z1\nG2 Y1 Z0 K-1\n",
+			tolerance: 0.1,
+			expected: "G19 G0 X0 Y0 Z1\n" +
+				"G1Y0.7071Z0.7071\n" +
+				"Y1Z0\n",
+		},
+		{
+			name:      "incremental",
+			gcode:     "G91\nG2 X1 Y-1 J-1\n",
+			tolerance: 0.1,
+			expected: "G91\n" +
+				"G1X0.7071Y-0.2929\n" +
+				"X0.2929Y-0.7071\n",
+		},
+		{
+			name:      "inverse time",
+			gcode:     "G0 X1 Y0\nG93 G3 X0 Y1 R1 F2\n",
+			tolerance: 0.1,
+			expected: "G0 X1 Y0\n" +
+				"G1G93X0.7071Y0.7071F4\n" +
+				"X0Y1F4\n",
+		},
+		{
+			name:      "comments",
+			gcode:     "G0 X1 Y0\n(a) G3 X0 Y1 R1 (b)\n",
+			tolerance: 0.1,
+			expected: "G0 X1 Y0\n" +
+				"(a)G1X0.7071Y0.7071\n" +
+				"X0Y1(b)\n",
+		},
+		{
+			name:          "unknown start",
+			gcode:         "G2 X1 Y0 I1\n",
+			errorContains: "arc start position unknown for X",
+		},
+		{
+			name:          "invalid target",
+			gcode:         "G0 X0 Y0\nG2 X3 Y0 I1\n",
+			errorContains: "arc end point is not at the arc radius",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := linearize(tc.gcode, tc.tolerance)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestLinearizeTolerance(t *testing.T) {
+	tolerance := 0.002
+	output, err := linearize("G0 X10 Y0\nG3 I-10\n", tolerance)
+	require.NoError(t, err)
+
+	parser := NewParser(strings.NewReader(output))
+	blocks, err := parser.Blocks()
+	require.NoError(t, err)
+	require.Greater(t, len(blocks), 2)
+	for _, block := range blocks {
+		x, err := block.GetArgumentNumber('X')
+		require.NoError(t, err)
+		y, err := block.GetArgumentNumber('Y')
+		require.NoError(t, err)
+		require.InDelta(t, 10, math.Hypot(*x, *y), 0.0001)
+	}
+	// Segment chordal error is within tolerance.
+	angle := 2 * math.Pi / float64(len(blocks)-1)
+	require.LessOrEqual(t, 10*(1-math.Cos(angle/2)), tolerance)
+}
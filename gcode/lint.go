@@ -0,0 +1,247 @@
+package gcode
+
+import (
+	"fmt"
+)
+
+// Grbl error codes for LintFinding.
+const (
+	LintCodeLineTooLong         = 11
+	LintCodeUnsupportedCommand  = 20
+	LintCodeModalGroupViolation = 21
+	LintCodeUndefinedFeedRate   = 22
+	LintCodeAxisCommandConflict = 24
+	LintCodeWordRepeated        = 25
+)
+
+// lintMaxSignificantCharacters is the maximum line length accepted by Grbl, as its line buffer
+// holds 80 characters, including the terminator, after removing spaces and comments.
+const lintMaxSignificantCharacters = 79
+
+// lintModalGroups maps G/M commands supported by Grbl to the name of their modal group. Non-modal
+// commands also can not be used together.
+var lintModalGroups = map[string]string{}
+
+func init() {
+	for group, commands := range map[string][]string{
+		"non-modal":                    {"G4", "G10", "G28", "G28.1", "G30", "G30.1", "G53", "G92", "G92.1"},
+		"motion":                       {"G0", "G1", "G2", "G3", "G38.2", "G38.3", "G38.4", "G38.5", "G80"},
+		"plane selection":              {"G17", "G18", "G19"},
+		"distance mode":                {"G90", "G91"},
+		"arc IJK distance mode":        {"G91.1"},
+		"feed rate mode":               {"G93", "G94"},
+		"units":                        {"G20", "G21"},
+		"cutter diameter compensation": {"G40"},
+		"tool length offset":           {"G43.1", "G49"},
+		"coordinate system select":     {"G54", "G55", "G56", "G57", "G58", "G59"},
+		"control mode":                 {"G61"},
+		"stopping":                     {"M0", "M1", "M2", "M30"},
+		"spindle":                      {"M3", "M4", "M5"},
+		"coolant":                      {"M7", "M8", "M9"},
+		"override control":             {"M56"},
+		// Not supported by Grbl, but handled by the program streamer.
+		"tool change": {"M6"},
+	} {
+		for _, command := range commands {
+			lintModalGroups[command] = group
+		}
+	}
+}
+
+// lintAxisCommands are commands that use axis words, other than motion.
+var lintAxisCommands = map[string]bool{
+	"G10":   true,
+	"G28":   true,
+	"G30":   true,
+	"G92":   true,
+	"G43.1": true,
+}
+
+// lintFeedMotions are motion modes that require a feed rate.
+var lintFeedMotions = map[string]bool{
+	"G1":    true,
+	"G2":    true,
+	"G3":    true,
+	"G38.2": true,
+	"G38.3": true,
+	"G38.4": true,
+	"G38.5": true,
+}
+
+// LintFinding is a problem that Grbl would report with an error response message.
+type LintFinding struct {
+	Line   uint `json:"line"`
+	Column uint `json:"column"`
+	// Grbl error code, as in "error:20".
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("line %d column %d: error:%d: %s", f.Line, f.Column, f.Code, f.Message)
+}
+
+// lintWord is a word with its column.
+type lintWord struct {
+	*Word
+	column uint
+}
+
+// linter holds state across lines.
+type linter struct {
+	findings []LintFinding
+	// Whether a feed rate is defined for G94 motion.
+	feedRateDefined bool
+	inverseTime     bool
+}
+
+func (l *linter) add(line, column uint, code int, format string, a ...any) {
+	l.findings = append(l.findings, LintFinding{
+		Line:    line,
+		Column:  column,
+		Code:    code,
+		Message: fmt.Sprintf(format, a...),
+	})
+}
+
+// words returns block words with their columns, and checks the line length, as Grbl counts it:
+// without spaces and comments.
+func (l *linter) words(line uint, block *Block, tokens Tokens) []lintWord {
+	var words []lintWord
+	column := uint(1)
+	var letterColumn uint
+	var significant int
+	for _, token := range tokens {
+		switch token.Type {
+		case TokenTypeSpace, TokenTypeComment, TokenTypeNewLine, TokenTypeEOF:
+		default:
+			if significant <= lintMaxSignificantCharacters && significant+len(token.Value) > lintMaxSignificantCharacters {
+				l.add(
+					line, column+uint(lintMaxSignificantCharacters-significant), LintCodeLineTooLong,
+					"line exceeds %d characters, excluding spaces and comments", lintMaxSignificantCharacters,
+				)
+			}
+			significant += len(token.Value)
+		}
+		switch token.Type {
+		case TokenTypeWordLetter:
+			letterColumn = column
+		case TokenTypeWordNumber:
+			words = append(words, lintWord{Word: block.Words()[len(words)], column: letterColumn})
+		}
+		column += uint(len(token.Value))
+	}
+	return words
+}
+
+// lintBlock checks a command block, which must have already been applied to modalGroup.
+//
+//gocyclo:ignore
+func (l *linter) lintBlock(line uint, words []lintWord, modalGroup *ModalGroup) {
+	groups := map[string]bool{}
+	letters := map[rune]bool{}
+	var axisCommand, motionWord *lintWord
+	var axisWord, feedWord *lintWord
+	var g93, g94 bool
+	for _, w := range words {
+		if w.IsCommand() {
+			command := w.NormalizedString()
+			group, ok := lintModalGroups[command]
+			if !ok {
+				l.add(line, w.column, LintCodeUnsupportedCommand, "unsupported command %s", command)
+				continue
+			}
+			if groups[group] {
+				l.add(line, w.column, LintCodeModalGroupViolation, "%s: more than one command from the %s modal group", command, group)
+			}
+			groups[group] = true
+			if lintAxisCommands[command] || (group == "motion" && command != "G80") {
+				if axisCommand != nil {
+					l.add(line, w.column, LintCodeAxisCommandConflict, "%s and %s both use axis words", axisCommand.NormalizedString(), command)
+				} else {
+					axisCommand = &w
+				}
+			}
+			if group == "motion" {
+				motionWord = &w
+			}
+			switch command {
+			case "G93":
+				g93 = true
+			case "G94":
+				g94 = true
+			}
+			continue
+		}
+		if letters[w.Letter()] {
+			l.add(line, w.column, LintCodeWordRepeated, "%c word repeated", w.Letter())
+		}
+		letters[w.Letter()] = true
+		switch w.Letter() {
+		case 'X', 'Y', 'Z':
+			if axisWord == nil {
+				axisWord = &w
+			}
+		case 'F':
+			feedWord = &w
+		}
+	}
+
+	// Feed rate
+	switch {
+	case g93:
+		l.inverseTime = true
+	case g94:
+		if l.inverseTime {
+			// Switching from inverse time does not keep the previous feed rate.
+			l.feedRateDefined = false
+		}
+		l.inverseTime = false
+	}
+	if feedWord != nil {
+		l.feedRateDefined = feedWord.Number() > 0
+	}
+	if axisWord == nil || (axisCommand != nil && motionWord == nil) {
+		return
+	}
+	if modalGroup.Motion == nil || !lintFeedMotions[modalGroup.Motion.NormalizedString()] {
+		return
+	}
+	column := axisWord.column
+	if motionWord != nil {
+		column = motionWord.column
+	}
+	if l.inverseTime {
+		if feedWord == nil || feedWord.Number() <= 0 {
+			l.add(line, column, LintCodeUndefinedFeedRate, "%s in inverse time mode (G93) requires F at the same block", modalGroup.Motion)
+		}
+		return
+	}
+	if !l.feedRateDefined {
+		l.add(line, column, LintCodeUndefinedFeedRate, "%s with undefined feed rate", modalGroup.Motion)
+	}
+}
+
+// Lint checks G-code from parser for problems that Grbl would report as an error, before sending
+// it: line too long (error:11), unsupported commands (error:20), more than one command from the
+// same modal group (error:21), undefined feed rate (error:22), commands that conflict over axis
+// words (error:24) and repeated words (error:25). Syntax errors are returned as errors.
+func Lint(parser *Parser) ([]LintFinding, error) {
+	l := linter{findings: []LintFinding{}}
+	for {
+		line := parser.Lexer.Line
+		eof, block, tokens, err := parser.Next()
+		if err != nil {
+			return nil, err
+		}
+		if block != nil {
+			words := l.words(line, block, tokens)
+			if block.IsCommand() {
+				l.lintBlock(line, words, &parser.ModalGroup)
+			}
+		}
+		if eof {
+			return l.findings, nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package gcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		gcode    string
+		expected []LintFinding
+	}{
+		{
+			name:     "valid",
+			gcode:    "G21 G90\n$H\nG0 X1 Y1\nG1 Z-1 F100\nG2 X2 Y2 I1\nG92 X0\nM6 T2\n",
+			expected: []LintFinding{},
+		},
+		{
+			name:  "unsupported command",
+			gcode: "G0 X1\nG1 F100 G33 Z1\nG59.1\n",
+			expected: []LintFinding{
+				{Line: 2, Column: 9, Code: LintCodeUnsupportedCommand, Message: "unsupported command G33"},
+				{Line: 3, Column: 1, Code: LintCodeUnsupportedCommand, Message: "unsupported command G59.1"},
+			},
+		},
+		{
+			name:  "modal group violation",
+			gcode: "G20 G21\n",
+			expected: []LintFinding{
+				{Line: 1, Column: 5, Code: LintCodeModalGroupViolation, Message: "G21: more than one command from the units modal group"},
+			},
+		},
+		{
+			name:  "undefined feed rate",
+			gcode: "G0 X1\nG1 X2\nF100\nG1 X3\nG93 G1 X4 F1\nG1 X5\nG94 G1 X6\n",
+			expected: []LintFinding{
+				{Line: 2, Column: 1, Code: LintCodeUndefinedFeedRate, Message: "G1 with undefined feed rate"},
+				{Line: 6, Column: 1, Code: LintCodeUndefinedFeedRate, Message: "G1 in inverse time mode (G93) requires F at the same block"},
+				{Line: 7, Column: 5, Code: LintCodeUndefinedFeedRate, Message: "G1 with undefined feed rate"},
+			},
+		},
+		{
+			name:  "axis command conflict",
+			gcode: "G0 G92 X0\n",
+			expected: []LintFinding{
+				{Line: 1, Column: 4, Code: LintCodeAxisCommandConflict, Message: "G0 and G92 both use axis words"},
+			},
+		},
+		{
+			name:  "word repeated",
+			gcode: "G0 X1 X2\n",
+			expected: []LintFinding{
+				{Line: 1, Column: 7, Code: LintCodeWordRepeated, Message: "X word repeated"},
+			},
+		},
+		{
+			name:  "line too long",
+			gcode: "(comment) G0 X1." + strings.Repeat("0", 80) + "\n",
+			expected: []LintFinding{
+				{Line: 1, Column: 91, Code: LintCodeLineTooLong, Message: "line exceeds 79 characters, excluding spaces and comments"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			findings, err := Lint(NewParser(strings.NewReader(tc.gcode)))
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, findings)
+		})
+	}
+}
@@ -0,0 +1,650 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+)
+
+// Vector holds XYZ coordinates.
+type Vector struct {
+	X float64
+	Y float64
+	Z float64
+}
+
+func (v Vector) axis(i int) float64 {
+	switch i {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	case 2:
+		return v.Z
+	}
+	panic(fmt.Sprintf("bug: invalid axis index %d", i))
+}
+
+func (v *Vector) setAxis(i int, value float64) {
+	switch i {
+	case 0:
+		v.X = value
+	case 1:
+		v.Y = value
+	case 2:
+		v.Z = value
+	default:
+		panic(fmt.Sprintf("bug: invalid axis index %d", i))
+	}
+}
+
+// Add returns v + o.
+func (v Vector) Add(o Vector) Vector {
+	return Vector{X: v.X + o.X, Y: v.Y + o.Y, Z: v.Z + o.Z}
+}
+
+// Sub returns v - o.
+func (v Vector) Sub(o Vector) Vector {
+	return Vector{X: v.X - o.X, Y: v.Y - o.Y, Z: v.Z - o.Z}
+}
+
+// Length returns the euclidean length of the vector.
+func (v Vector) Length() float64 {
+	return math.Sqrt(v.X*v.X + v.Y*v.Y + v.Z*v.Z)
+}
+
+// MachineParameters holds G-code parameters in millimeters, similar to what Grbl reports via $#
+// (eg: grbl.GcodeParameters).
+type MachineParameters struct {
+	// Coordinate systems 1 (G54) to 6 (G59).
+	CoordinateSystems [6]Vector
+	// Primary Pre-Defined Position (G28)
+	PrimaryPreDefinedPosition Vector
+	// Secondary Pre-Defined Position (G30)
+	SecondaryPreDefinedPosition Vector
+	// Coordinate Offset (G92)
+	CoordinateOffset Vector
+	// Tool length offset (G43.1) for the Z axis.
+	ToolLengthOffset float64
+}
+
+// SegmentType is the type of motion of a Segment.
+type SegmentType int
+
+const (
+	// Coordinated motion at rapid rate (G0, G28, G30).
+	SegmentTypeRapid SegmentType = iota
+	// Coordinated motion at feed rate (G1).
+	SegmentTypeFeed
+	// Arc motion at feed rate (G2, G3).
+	SegmentTypeArc
+	// Probing motion at feed rate (G38.2, G38.3, G38.4, G38.5). The end is the probe target.
+	SegmentTypeProbe
+)
+
+func (t SegmentType) String() string {
+	switch t {
+	case SegmentTypeRapid:
+		return "rapid"
+	case SegmentTypeFeed:
+		return "feed"
+	case SegmentTypeArc:
+		return "arc"
+	case SegmentTypeProbe:
+		return "probe"
+	}
+	return fmt.Sprintf("SegmentType(%d)", int(t))
+}
+
+// Arc holds the geometry of a SegmentTypeArc Segment.
+type Arc struct {
+	// Plane selection (G17, G18 or G19).
+	Plane *Word
+	// Center in machine coordinates. The linear (helix) axis is at the start.
+	Center Vector
+	Radius float64
+	// Angular travel in radians: negative when clockwise.
+	AngularTravel float64
+}
+
+// Segment is a motion, in absolute machine coordinates, in millimeters.
+type Segment struct {
+	// Block that generated the segment.
+	Block *Block
+	// Line number of Block, when executed via Machine.Next.
+	Line  uint
+	Type  SegmentType
+	Start Vector
+	End   Vector
+	// Arc is set for SegmentTypeArc, unless the start is unknown at the arc plane.
+	Arc *Arc
+	// FeedRate in millimeters per minute, also for inverse time mode (G93). Zero for rapids.
+	FeedRate     float64
+	Spindle      *Word
+	SpindleSpeed float64
+	Coolant      []*Word
+	// Offset from machine to work coordinates at the time of the motion.
+	WorkCoordinateOffset Vector
+	// Which axes (X, Y, Z) of Start and End are known, see NewMachineAtUnknownPosition.
+	startKnown [3]bool
+	endKnown   [3]bool
+}
+
+// StartKnown returns whether all axes of Start are known. Motion from an unknown position, such as
+// the first motion of a program executed by a Machine from NewMachineAtUnknownPosition, has a
+// meaningless Start.
+func (s *Segment) StartKnown() bool {
+	return s.startKnown == [3]bool{true, true, true}
+}
+
+// Length of the motion in millimeters.
+func (s *Segment) Length() float64 {
+	if s.Arc != nil {
+		plane := arcPlanes[s.Arc.Plane.NormalizedString()]
+		arcLength := s.Arc.AngularTravel * s.Arc.Radius
+		linear := s.End.axis(plane.linear) - s.Start.axis(plane.linear)
+		return math.Hypot(arcLength, linear)
+	}
+	return s.End.Sub(s.Start).Length()
+}
+
+// Points returns points along the segment, ending at End: for arcs, these are within given chordal
+// tolerance, for other segments it's only End.
+func (s *Segment) Points(tolerance float64) []Vector {
+	if s.Arc == nil {
+		return []Vector{s.End}
+	}
+	plane := arcPlanes[s.Arc.Plane.NormalizedString()]
+	geometry := arcGeometry{
+		offset: [2]float64{
+			s.Arc.Center.axis(plane.axis0) - s.Start.axis(plane.axis0),
+			s.Arc.Center.axis(plane.axis1) - s.Start.axis(plane.axis1),
+		},
+		radius:        s.Arc.Radius,
+		angularTravel: s.Arc.AngularTravel,
+	}
+	delta := s.End.Sub(s.Start)
+	end := [3]float64{delta.X, delta.Y, delta.Z}
+	segments := geometry.segments(tolerance)
+	points := make([]Vector, segments)
+	for i := 1; i < segments; i++ {
+		point := geometry.point(plane, end, float64(i)/float64(segments))
+		points[i-1] = s.Start.Add(Vector{X: point[0], Y: point[1], Z: point[2]})
+	}
+	points[segments-1] = s.End
+	return points
+}
+
+// Machine interprets blocks, similar to Grbl, tracking the absolute machine position and emitting
+// the motion segments for each block.
+type Machine struct {
+	// ModalGroup holds the state of each modal group, updated by Execute.
+	ModalGroup ModalGroup
+	// Parameters holds the current G-code parameters, updated by Execute.
+	Parameters MachineParameters
+	// Position is the current machine position in millimeters.
+	Position Vector
+	// positionKnown is which axes (X, Y, Z) of Position are known.
+	positionKnown [3]bool
+	// parametersUnknown is whether Parameters are unknown, see newMachineAtUnknownParameters.
+	parametersUnknown bool
+	// FeedRate in millimeters per minute, for units per minute mode (G94).
+	FeedRate     float64
+	SpindleSpeed float64
+}
+
+// NewMachine creates a new Machine, using DefaultModalGroup as the initial state, at given machine
+// position in millimeters.
+func NewMachine(parameters MachineParameters, position Vector) *Machine {
+	return &Machine{
+		ModalGroup:    DefaultModalGroup,
+		Parameters:    parameters,
+		Position:      position,
+		positionKnown: [3]bool{true, true, true},
+	}
+}
+
+// NewMachineAtUnknownPosition creates a new Machine, like NewMachine, but at an unknown position:
+// each axis becomes known once motion sets it in absolute or machine coordinates. Segments from
+// the unknown position have Segment StartKnown false.
+func NewMachineAtUnknownPosition(parameters MachineParameters) *Machine {
+	return &Machine{
+		ModalGroup: DefaultModalGroup,
+		Parameters: parameters,
+	}
+}
+
+// newMachineAtUnknownParameters creates a new Machine at an unknown position, like
+// NewMachineAtUnknownPosition, but also with unknown parameters. As the offset from machine to work
+// coordinates is unknown, Position is in work coordinates: axes become unknown with motion in
+// machine coordinates (G53, G28, G30), probing, coordinate system or tool length offset changes
+// and system commands, while G92 and G10 L20 set them.
+func newMachineAtUnknownParameters() *Machine {
+	return &Machine{
+		ModalGroup:        DefaultModalGroup,
+		parametersUnknown: true,
+	}
+}
+
+// unitsFactor returns the factor to convert the units at modalGroup to millimeters.
+func unitsFactor(modalGroup *ModalGroup) float64 {
+	if modalGroup.Units.NormalizedString() == "G20" {
+		return 25.4
+	}
+	return 1
+}
+
+// unitsFactor returns the factor to convert the current units to millimeters.
+func (m *Machine) unitsFactor() float64 {
+	return unitsFactor(&m.ModalGroup)
+}
+
+// coordinateSystemIndex returns the index at Parameters.CoordinateSystems for the current
+// coordinate system.
+func (m *Machine) coordinateSystemIndex() int {
+	switch m.ModalGroup.CoordinateSystemSelect.NormalizedString() {
+	case "G55":
+		return 1
+	case "G56":
+		return 2
+	case "G57":
+		return 3
+	case "G58":
+		return 4
+	case "G59":
+		return 5
+	default:
+		return 0
+	}
+}
+
+// WorkCoordinateOffset returns the current offset from machine to work coordinates: the current
+// coordinate system, plus the coordinate offset (G92), plus the tool length offset.
+func (m *Machine) WorkCoordinateOffset() Vector {
+	wco := m.Parameters.CoordinateSystems[m.coordinateSystemIndex()].Add(m.Parameters.CoordinateOffset)
+	wco.Z += m.Parameters.ToolLengthOffset
+	return wco
+}
+
+// WorkPosition returns the current position in work coordinates, in millimeters.
+func (m *Machine) WorkPosition() Vector {
+	return m.Position.Sub(m.WorkCoordinateOffset())
+}
+
+func (m *Machine) newSegment(block *Block, segmentType SegmentType, end Vector, endKnown [3]bool, feedRate float64) Segment {
+	return Segment{
+		Block:                block,
+		Type:                 segmentType,
+		Start:                m.Position,
+		End:                  end,
+		startKnown:           m.positionKnown,
+		endKnown:             endKnown,
+		FeedRate:             feedRate,
+		Spindle:              m.ModalGroup.Spindle,
+		SpindleSpeed:         m.SpindleSpeed,
+		Coolant:              append([]*Word{}, m.ModalGroup.Coolant...),
+		WorkCoordinateOffset: m.WorkCoordinateOffset(),
+	}
+}
+
+// target returns the machine position for given axes in millimeters, with absent axes unchanged,
+// and which of its axes are known.
+func (m *Machine) target(axes [3]*float64, machineCoordinates, incremental bool) (Vector, [3]bool) {
+	target := m.Position
+	known := m.positionKnown
+	wco := m.WorkCoordinateOffset()
+	for i, v := range axes {
+		if v == nil {
+			continue
+		}
+		switch {
+		case machineCoordinates:
+			target.setAxis(i, *v)
+			known[i] = !m.parametersUnknown
+		case incremental:
+			target.setAxis(i, m.Position.axis(i)+*v)
+		default:
+			target.setAxis(i, *v+wco.axis(i))
+			known[i] = true
+		}
+	}
+	return target, known
+}
+
+// setCoordinateSystem implements G10.
+func (m *Machine) setCoordinateSystem(block *Block, axes [3]*float64) error {
+	l, err := block.GetArgumentNumber('L')
+	if err != nil {
+		return err
+	}
+	p, err := block.GetArgumentNumber('P')
+	if err != nil {
+		return err
+	}
+	if l == nil || (*l != 2 && *l != 20) {
+		return fmt.Errorf("%s: G10 requires L2 or L20", block)
+	}
+	index := m.coordinateSystemIndex()
+	if p != nil && *p != 0 {
+		if *p != math.Trunc(*p) || *p < 1 || *p > 6 {
+			return fmt.Errorf("%s: G10 P must be between 0 and 6", block)
+		}
+		index = int(*p) - 1
+	}
+	if m.parametersUnknown {
+		if index != m.coordinateSystemIndex() {
+			return nil
+		}
+		// The work position is set by L20, and lost with L2.
+		for i, v := range axes {
+			if v == nil {
+				continue
+			}
+			if *l == 20 {
+				m.Position.setAxis(i, *v)
+			}
+			m.positionKnown[i] = *l == 20
+		}
+		return nil
+	}
+	coordinateSystem := &m.Parameters.CoordinateSystems[index]
+	for i, v := range axes {
+		if v == nil {
+			continue
+		}
+		if *l == 2 {
+			coordinateSystem.setAxis(i, *v)
+			continue
+		}
+		value := m.Position.axis(i) - m.Parameters.CoordinateOffset.axis(i) - *v
+		if i == 2 {
+			value -= m.Parameters.ToolLengthOffset
+		}
+		coordinateSystem.setAxis(i, value)
+	}
+	return nil
+}
+
+// setCoordinateOffset implements G92.
+func (m *Machine) setCoordinateOffset(axes [3]*float64) {
+	coordinateSystem := m.Parameters.CoordinateSystems[m.coordinateSystemIndex()]
+	for i, v := range axes {
+		if v == nil {
+			continue
+		}
+		if m.parametersUnknown {
+			m.Position.setAxis(i, *v)
+			m.positionKnown[i] = true
+			continue
+		}
+		value := m.Position.axis(i) - coordinateSystem.axis(i) - *v
+		if i == 2 {
+			value -= m.Parameters.ToolLengthOffset
+		}
+		m.Parameters.CoordinateOffset.setAxis(i, value)
+		// The work position is set, so motion from it is known in work coordinates.
+		m.positionKnown[i] = true
+	}
+}
+
+// goToPreDefinedPosition implements G28 and G30.
+func (m *Machine) goToPreDefinedPosition(block *Block, axes [3]*float64, preDefinedPosition Vector) []Segment {
+	var segments []Segment
+	hasAxes := axes[0] != nil || axes[1] != nil || axes[2] != nil
+	target := preDefinedPosition
+	known := [3]bool{!m.parametersUnknown, !m.parametersUnknown, !m.parametersUnknown}
+	if hasAxes {
+		intermediate, intermediateKnown := m.target(axes, false, m.ModalGroup.DistanceMode.NormalizedString() == "G91")
+		segments = append(segments, m.newSegment(block, SegmentTypeRapid, intermediate, intermediateKnown, 0))
+		m.Position, m.positionKnown = intermediate, intermediateKnown
+		target, known = m.Position, m.positionKnown
+		for i, v := range axes {
+			if v != nil {
+				target.setAxis(i, preDefinedPosition.axis(i))
+				known[i] = !m.parametersUnknown
+			}
+		}
+	}
+	segments = append(segments, m.newSegment(block, SegmentTypeRapid, target, known, 0))
+	m.Position, m.positionKnown = target, known
+	return segments
+}
+
+// arc returns the arc segment from the current position to target.
+func (m *Machine) arc(block *Block, target Vector, targetKnown [3]bool, feedRate float64) (Segment, error) {
+	plane, ok := arcPlanes[m.ModalGroup.PlaneSelection.NormalizedString()]
+	if !ok {
+		return Segment{}, fmt.Errorf("%s: unsupported plane selection: %s", block, m.ModalGroup.PlaneSelection)
+	}
+	if !m.positionKnown[plane.axis0] || !m.positionKnown[plane.axis1] {
+		// The arc geometry is unknown.
+		return m.newSegment(block, SegmentTypeArc, target, targetKnown, feedRate), nil
+	}
+	unitsFactor := m.unitsFactor()
+	delta := target.Sub(m.Position)
+	// Arc geometry is computed in the block units, as given by IJK and R.
+	end := [3]float64{delta.X / unitsFactor, delta.Y / unitsFactor, delta.Z / unitsFactor}
+	clockwise := m.ModalGroup.Motion.NormalizedString() == "G2"
+	geometry, err := getArcGeometry(block, plane, end, clockwise, 1/unitsFactor)
+	if err != nil {
+		return Segment{}, fmt.Errorf("%s: %w", block, err)
+	}
+	center := m.Position
+	center.setAxis(plane.axis0, center.axis(plane.axis0)+geometry.offset[0]*unitsFactor)
+	center.setAxis(plane.axis1, center.axis(plane.axis1)+geometry.offset[1]*unitsFactor)
+
+	segment := m.newSegment(block, SegmentTypeArc, target, targetKnown, feedRate)
+	segment.Arc = &Arc{
+		Plane:         m.ModalGroup.PlaneSelection,
+		Center:        center,
+		Radius:        geometry.radius * unitsFactor,
+		AngularTravel: geometry.angularTravel,
+	}
+	return segment, nil
+}
+
+// resetProgramEnd resets modal state at program end (M2 / M30), same as Grbl.
+func (m *Machine) resetProgramEnd() {
+	m.ModalGroup.Motion = NewWord('G', 1)
+	m.ModalGroup.PlaneSelection = NewWord('G', 17)
+	m.ModalGroup.DistanceMode = NewWord('G', 90)
+	m.ModalGroup.FeedRateMode = NewWord('G', 94)
+	m.ModalGroup.CoordinateSystemSelect = NewWord('G', 54)
+	m.ModalGroup.Spindle = NewWord('M', 5)
+	m.ModalGroup.Coolant = []*Word{NewWord('M', 9)}
+}
+
+// Execute updates the machine state with given block, and returns its motion segments. System
+// blocks are ignored.
+func (m *Machine) Execute(block *Block) ([]Segment, error) {
+	modalGroup := m.ModalGroup
+	if !block.IsSystem() {
+		if err := modalGroup.UpdateFromBlock(block); err != nil {
+			return nil, fmt.Errorf("%s: %w", block, err)
+		}
+	}
+	return m.execute(block, modalGroup)
+}
+
+// loseCoordinateSystem marks all axes as unknown, when parameters are unknown and the coordinate
+// system changed from given index.
+func (m *Machine) loseCoordinateSystem(index int) {
+	if m.parametersUnknown && m.coordinateSystemIndex() != index {
+		m.positionKnown = [3]bool{}
+	}
+}
+
+// execute implements Execute, given the modal group state, already updated with block.
+//
+//gocyclo:ignore
+func (m *Machine) execute(block *Block, modalGroup ModalGroup) ([]Segment, error) {
+	if block.IsSystem() {
+		if m.parametersUnknown {
+			// Such as homing or jogging.
+			m.positionKnown = [3]bool{}
+		}
+		return nil, nil
+	}
+
+	coordinateSystemIndex := m.coordinateSystemIndex()
+	m.ModalGroup = modalGroup
+	m.loseCoordinateSystem(coordinateSystemIndex)
+	unitsFactor := m.unitsFactor()
+
+	axes, err := getAxes(block)
+	if err != nil {
+		return nil, err
+	}
+	var axesMm [3]*float64
+	var hasAxes bool
+	for i, v := range [3]*float64{axes.x, axes.y, axes.z} {
+		if v != nil {
+			mm := *v * unitsFactor
+			axesMm[i] = &mm
+			hasAxes = true
+		}
+	}
+
+	inverseTime := m.ModalGroup.FeedRateMode.NormalizedString() == "G93"
+	f, err := block.GetArgumentNumber('F')
+	if err != nil {
+		return nil, err
+	}
+	if f != nil && !inverseTime {
+		m.FeedRate = *f * unitsFactor
+	}
+	s, err := block.GetArgumentNumber('S')
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		m.SpindleSpeed = *s
+	}
+
+	var machineCoordinates, programEnd bool
+	for _, w := range block.Commands() {
+		switch w.NormalizedString() {
+		case "G10":
+			return nil, m.setCoordinateSystem(block, axesMm)
+		case "G28":
+			return m.goToPreDefinedPosition(block, axesMm, m.Parameters.PrimaryPreDefinedPosition), nil
+		case "G30":
+			return m.goToPreDefinedPosition(block, axesMm, m.Parameters.SecondaryPreDefinedPosition), nil
+		case "G28.1":
+			m.Parameters.PrimaryPreDefinedPosition = m.Position
+			return nil, nil
+		case "G30.1":
+			m.Parameters.SecondaryPreDefinedPosition = m.Position
+			return nil, nil
+		case "G92":
+			m.setCoordinateOffset(axesMm)
+			return nil, nil
+		case "G92.1":
+			if m.parametersUnknown {
+				m.positionKnown = [3]bool{}
+			}
+			m.Parameters.CoordinateOffset = Vector{}
+		case "G43.1":
+			if axesMm[2] == nil {
+				return nil, fmt.Errorf("%s: G43.1 requires Z", block)
+			}
+			if m.parametersUnknown {
+				m.positionKnown[2] = false
+			} else {
+				m.Parameters.ToolLengthOffset = *axesMm[2]
+			}
+			// Z is the offset, not motion.
+			hasAxes = axesMm[0] != nil || axesMm[1] != nil
+			axesMm[2] = nil
+		case "G49":
+			if m.parametersUnknown {
+				m.positionKnown[2] = false
+			}
+			m.Parameters.ToolLengthOffset = 0
+		case "G53":
+			machineCoordinates = true
+		case "M2", "M30":
+			programEnd = true
+		}
+	}
+
+	var segments []Segment
+	motion := m.ModalGroup.Motion.NormalizedString()
+	isArc := motion == "G2" || motion == "G3"
+	if isArc && !hasAxes {
+		// Full circle
+		for _, w := range block.Arguments() {
+			switch w.Letter() {
+			case 'I', 'J', 'K', 'R':
+				hasAxes = true
+			}
+		}
+	}
+	if hasAxes {
+		if machineCoordinates && motion != "G0" && motion != "G1" {
+			return nil, fmt.Errorf("%s: G53 requires G0 or G1", block)
+		}
+		target, targetKnown := m.target(axesMm, machineCoordinates, m.ModalGroup.DistanceMode.NormalizedString() == "G91")
+		feedRate := m.FeedRate
+		if inverseTime && motion != "G0" && f == nil {
+			return nil, fmt.Errorf("%s: feed rate required in inverse time mode", block)
+		}
+		var segment Segment
+		switch motion {
+		case "G0":
+			segment = m.newSegment(block, SegmentTypeRapid, target, targetKnown, 0)
+		case "G1":
+			segment = m.newSegment(block, SegmentTypeFeed, target, targetKnown, feedRate)
+		case "G2", "G3":
+			if segment, err = m.arc(block, target, targetKnown, feedRate); err != nil {
+				return nil, err
+			}
+		case "G38.2", "G38.3", "G38.4", "G38.5":
+			segment = m.newSegment(block, SegmentTypeProbe, target, targetKnown, feedRate)
+			if m.parametersUnknown {
+				// Probing stops at contact, before the target.
+				for i, v := range axesMm {
+					if v != nil {
+						targetKnown[i] = false
+					}
+				}
+			}
+		default:
+			return nil, fmt.Errorf("%s: axis words unsupported for motion %s", block, m.ModalGroup.Motion)
+		}
+		if inverseTime && motion != "G0" {
+			// Inverse time is 1/minutes for the motion to complete.
+			segment.FeedRate = segment.Length() * *f
+		}
+		segments = append(segments, segment)
+		m.Position, m.positionKnown = target, targetKnown
+	}
+
+	if programEnd {
+		coordinateSystemIndex := m.coordinateSystemIndex()
+		m.resetProgramEnd()
+		m.loseCoordinateSystem(coordinateSystemIndex)
+	}
+
+	return segments, nil
+}
+
+// Next parses the next block from parser and executes it, returning same values as Parser.Next,
+// except for tokens, and the segments for the block, with Line set.
+func (m *Machine) Next(parser *Parser) (bool, *Block, []Segment, error) {
+	line := parser.Lexer.Line
+	eof, block, _, err := parser.Next()
+	if err != nil {
+		return eof, nil, nil, err
+	}
+	if block == nil {
+		return eof, nil, nil, nil
+	}
+	segments, err := m.Execute(block)
+	if err != nil {
+		return eof, block, nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	for i := range segments {
+		segments[i].Line = line
+	}
+	return eof, block, segments, nil
+}
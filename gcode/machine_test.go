@@ -0,0 +1,229 @@
+package gcode
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func executeMachine(t *testing.T, machine *Machine, gcode string) []Segment {
+	blocks, err := NewParser(strings.NewReader(gcode)).Blocks()
+	require.NoError(t, err)
+	var segments []Segment
+	for _, block := range blocks {
+		blockSegments, err := machine.Execute(block)
+		require.NoError(t, err)
+		segments = append(segments, blockSegments...)
+	}
+	return segments
+}
+
+func TestMachine(t *testing.T) {
+	parameters := MachineParameters{
+		CoordinateSystems: [6]Vector{
+			{X: -100, Y: -100, Z: -10},
+			{X: -50, Y: -50, Z: -5},
+		},
+		PrimaryPreDefinedPosition: Vector{X: -1, Y: -2, Z: -3},
+	}
+
+	type segment struct {
+		segmentType SegmentType
+		start, end  Vector
+		feedRate    float64
+	}
+	for _, tc := range []struct {
+		name     string
+		gcode    string
+		expected []segment
+		position Vector
+	}{
+		{
+			name:  "absolute and incremental",
+			gcode: "G0 X1 Y2 Z3\nG91 G1 X1 F100\nG20 G1 Y1\n",
+			expected: []segment{
+				{SegmentTypeRapid, Vector{}, Vector{X: -99, Y: -98, Z: -7}, 0},
+				{SegmentTypeFeed, Vector{X: -99, Y: -98, Z: -7}, Vector{X: -98, Y: -98, Z: -7}, 100},
+				{SegmentTypeFeed, Vector{X: -98, Y: -98, Z: -7}, Vector{X: -98, Y: -72.6, Z: -7}, 100},
+			},
+			position: Vector{X: -98, Y: -72.6, Z: -7},
+		},
+		{
+			name:  "coordinate systems and offsets",
+			gcode: "G55 G0 X0 Y0\nG92 X10 Y10\nG0 X10\nG53 G0 Z0\nG92.1\nG0 X0\nG43.1 Z1\nG0 Z0\n",
+			expected: []segment{
+				{SegmentTypeRapid, Vector{}, Vector{X: -50, Y: -50}, 0},
+				{SegmentTypeRapid, Vector{X: -50, Y: -50}, Vector{X: -50, Y: -50}, 0},
+				{SegmentTypeRapid, Vector{X: -50, Y: -50}, Vector{X: -50, Y: -50, Z: 0}, 0},
+				{SegmentTypeRapid, Vector{X: -50, Y: -50}, Vector{X: -50, Y: -50}, 0},
+				{SegmentTypeRapid, Vector{X: -50, Y: -50}, Vector{X: -50, Y: -50, Z: -4}, 0},
+			},
+			position: Vector{X: -50, Y: -50, Z: -4},
+		},
+		{
+			name:  "pre-defined position",
+			gcode: "G28 G91 Z1\nG90 G28\n",
+			expected: []segment{
+				{SegmentTypeRapid, Vector{}, Vector{Z: 1}, 0},
+				{SegmentTypeRapid, Vector{Z: 1}, Vector{Z: -3}, 0},
+				{SegmentTypeRapid, Vector{Z: -3}, Vector{X: -1, Y: -2, Z: -3}, 0},
+			},
+			position: Vector{X: -1, Y: -2, Z: -3},
+		},
+		{
+			name:  "set coordinate system",
+			gcode: "G10 L20 P1 X1\nG0 X0\nG10 L2 P0 Y0\nG0 Y0\n",
+			expected: []segment{
+				{SegmentTypeRapid, Vector{}, Vector{X: -1}, 0},
+				{SegmentTypeRapid, Vector{X: -1}, Vector{X: -1}, 0},
+			},
+			position: Vector{X: -1},
+		},
+		{
+			name:  "inverse time",
+			gcode: "G93 G1 X102 Y100 F2\n",
+			expected: []segment{
+				{SegmentTypeFeed, Vector{}, Vector{X: 2}, 4},
+			},
+			position: Vector{X: 2},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := NewMachine(parameters, Vector{})
+			segments := executeMachine(t, machine, tc.gcode)
+			require.Len(t, segments, len(tc.expected))
+			for i, s := range segments {
+				require.Equal(t, tc.expected[i].segmentType, s.Type, "segment %d", i)
+				require.InDelta(t, tc.expected[i].start.X, s.Start.X, 1e-9, "segment %d", i)
+				require.InDelta(t, tc.expected[i].start.Y, s.Start.Y, 1e-9, "segment %d", i)
+				require.InDelta(t, tc.expected[i].start.Z, s.Start.Z, 1e-9, "segment %d", i)
+				require.InDelta(t, tc.expected[i].end.X, s.End.X, 1e-9, "segment %d", i)
+				require.InDelta(t, tc.expected[i].end.Y, s.End.Y, 1e-9, "segment %d", i)
+				require.InDelta(t, tc.expected[i].end.Z, s.End.Z, 1e-9, "segment %d", i)
+				require.InDelta(t, tc.expected[i].feedRate, s.FeedRate, 1e-9, "segment %d", i)
+			}
+			require.InDelta(t, tc.position.X, machine.Position.X, 1e-9)
+			require.InDelta(t, tc.position.Y, machine.Position.Y, 1e-9)
+			require.InDelta(t, tc.position.Z, machine.Position.Z, 1e-9)
+		})
+	}
+}
+
+func TestMachineArc(t *testing.T) {
+	machine := NewMachine(MachineParameters{}, Vector{})
+	segments := executeMachine(t, machine, "G20 M3 S1000 M8\nG0 X1 Y0\nG2 X0 Y-1 I-1 F10\n")
+	require.Len(t, segments, 2)
+
+	arc := segments[1]
+	require.Equal(t, SegmentTypeArc, arc.Type)
+	require.Equal(t, "M3", arc.Spindle.NormalizedString())
+	require.Equal(t, 1000.0, arc.SpindleSpeed)
+	require.Len(t, arc.Coolant, 1)
+	require.Equal(t, "M8", arc.Coolant[0].NormalizedString())
+	require.Equal(t, 254.0, arc.FeedRate)
+	require.NotNil(t, arc.Arc)
+	require.Equal(t, Vector{}, arc.Arc.Center)
+	require.InDelta(t, 25.4, arc.Arc.Radius, 1e-9)
+	require.InDelta(t, -math.Pi/2, arc.Arc.AngularTravel, 1e-9)
+	require.InDelta(t, 25.4*math.Pi/2, arc.Length(), 1e-9)
+
+	points := arc.Points(0.01)
+	require.Greater(t, len(points), 2)
+	for _, point := range points {
+		require.InDelta(t, 25.4, point.Length(), 1e-9)
+	}
+	require.Equal(t, arc.End, points[len(points)-1])
+}
+
+func TestPositionTracker(t *testing.T) {
+	ptr := func(v float64) *float64 { return &v }
+	for _, tc := range []struct {
+		name     string
+		gcode    string
+		expected position
+	}{
+		{
+			name:     "motion",
+			gcode:    "G0 X1 Y2\nG91 Z1 X1\n",
+			expected: position{x: ptr(2), y: ptr(2)},
+		},
+		{
+			name:     "units",
+			gcode:    "G0 X25.4 Y0 Z0\nG20\n",
+			expected: position{x: ptr(1), y: ptr(0), z: ptr(0)},
+		},
+		{
+			name:     "machine coordinates",
+			gcode:    "G0 X1 Y2 Z3\nG53 G0 Z0\n",
+			expected: position{x: ptr(1), y: ptr(2)},
+		},
+		{
+			name:     "predefined position",
+			gcode:    "G0 X1 Y2 Z3\nG28 G91 Z0\n",
+			expected: position{x: ptr(1), y: ptr(2)},
+		},
+		{
+			name:     "probe",
+			gcode:    "G0 X1 Y2 Z3\nG38.2 Z-10 F10\n",
+			expected: position{x: ptr(1), y: ptr(2)},
+		},
+		{
+			name:     "coordinate offset",
+			gcode:    "G92 X0 Y0\nG0 Z1\nG91 X1\n",
+			expected: position{x: ptr(1), y: ptr(0), z: ptr(1)},
+		},
+		{
+			name:     "coordinate system",
+			gcode:    "G10 L20 P0 X5\nG10 L2 P2 Y1\nG0 Y1\n",
+			expected: position{x: ptr(5), y: ptr(1)},
+		},
+		{
+			name:  "coordinate system select",
+			gcode: "G0 X1 Y2 Z3\nG55\n",
+		},
+		{
+			name:     "tool length offset",
+			gcode:    "G0 X1 Y2 Z3\nG43.1 Z1\n",
+			expected: position{x: ptr(1), y: ptr(2)},
+		},
+		{
+			name:  "system command",
+			gcode: "G0 X1 Y2 Z3\n$H\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			parser := NewParser(strings.NewReader(tc.gcode))
+			tracker := newPositionTracker()
+			for {
+				eof, block, _, err := parser.Next()
+				require.NoError(t, err)
+				if block != nil {
+					_, _, err := tracker.next(&parser.ModalGroup, block)
+					require.NoError(t, err)
+				}
+				if eof {
+					break
+				}
+			}
+			require.Equal(t, tc.expected, tracker.current(&parser.ModalGroup))
+		})
+	}
+}
+
+func TestMachineTestData(t *testing.T) {
+	matches, err := filepath.Glob("testdata/*.nc")
+	require.NoError(t, err)
+	require.NotEmpty(t, matches)
+
+	for _, path := range matches {
+		t.Run(path, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			executeMachine(t, NewMachine(MachineParameters{}, Vector{}), string(data))
+		})
+	}
+}
@@ -0,0 +1,535 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// optimizerEpsilon is the distance below which positions are considered the same.
+const optimizerEpsilon = 1e-9
+
+// optimizerMaxTwoOptPasses limits the 2-opt passes over each group of units.
+const optimizerMaxTwoOptPasses = 100
+
+// optimizerUnitCommands are the commands allowed at blocks within a unit.
+var optimizerUnitCommands = map[string]bool{
+	"G0": true,
+	"G1": true,
+	"G2": true,
+	"G3": true,
+}
+
+// optimizerUnitArguments are the arguments allowed at blocks within a unit.
+var optimizerUnitArguments = map[rune]bool{
+	'N': true,
+	'X': true,
+	'Y': true,
+	'Z': true,
+	'I': true,
+	'J': true,
+	'K': true,
+	'R': true,
+	'F': true,
+}
+
+// optimizerUnit is a "plunge–cut–retract" unit: a rapid XY move at travel height, followed by
+// motion only, up to the next rapid XY move or to anything else.
+type optimizerUnit struct {
+	lines []string
+	// XY before the unit, or nil if unknown.
+	from *point2
+	// XY after the leading rapid and at the end of the unit.
+	start, end point2
+	// Z before and after the unit.
+	startZ, endZ float64
+	// Modal state, excluding motion, and feed rate before the unit.
+	startState string
+	// Modal state and feed rate after the unit.
+	endState string
+	// Whether the unit leaves the modal state, excluding motion, and feed rate unchanged.
+	stateKept bool
+	// Whether distances are in inches.
+	inches bool
+}
+
+// optimizerXYDependency is whether a line that is not part of a unit depends on the XY position
+// left by previous lines.
+type optimizerXYDependency int
+
+const (
+	// Does not move, or retracts Z only.
+	optimizerXYNeutral optimizerXYDependency = iota
+	// Moves to absolute XY coordinates, so it does not depend on the previous position.
+	optimizerXYReset
+	// Anything else that moves.
+	optimizerXYDependent
+)
+
+// optimizerItem is either a unit or a line that can not be reordered.
+type optimizerItem struct {
+	unit         *optimizerUnit
+	line         string
+	xyDependency optimizerXYDependency
+}
+
+// optimizerWordString returns the normalized word, or an empty string for nil.
+func optimizerWordString(word *Word) string {
+	if word == nil {
+		return ""
+	}
+	return word.NormalizedString()
+}
+
+// optimizerState returns a key for the modal state and feed rate, which units must share to be
+// reordered.
+func optimizerState(modalGroup *ModalGroup, feedRate *float64, motion bool) string {
+	words := []string{
+		optimizerWordString(modalGroup.PlaneSelection),
+		optimizerWordString(modalGroup.DistanceMode),
+		optimizerWordString(modalGroup.ArcIjkDistanceMode),
+		optimizerWordString(modalGroup.FeedRateMode),
+		optimizerWordString(modalGroup.Units),
+		optimizerWordString(modalGroup.CutterDiameterCompensation),
+		optimizerWordString(modalGroup.CoordinateSystemSelect),
+		optimizerWordString(modalGroup.ControlMode),
+		optimizerWordString(modalGroup.Spindle),
+	}
+	if modalGroup.ToolLengthOffset != nil {
+		words = append(words, modalGroup.ToolLengthOffset.NormalizedString())
+	}
+	for _, word := range modalGroup.Coolant {
+		words = append(words, optimizerWordString(word))
+	}
+	if feedRate != nil {
+		words = append(words, fmt.Sprintf("F%v", *feedRate))
+	}
+	if motion {
+		words = append(words, optimizerWordString(modalGroup.Motion))
+	}
+	return strings.Join(words, " ")
+}
+
+// optimizerPathLength returns the rapid XY distance from start, through units, in order.
+func optimizerPathLength(start point2, units []*optimizerUnit) float64 {
+	length := 0.0
+	for _, unit := range units {
+		length += start.distance(unit.start)
+		start = unit.end
+	}
+	return length
+}
+
+// optimizerNearestNeighbor orders units by repeatedly visiting the one closest to the current
+// position, from start.
+func optimizerNearestNeighbor(start point2, units []*optimizerUnit) []*optimizerUnit {
+	remaining := append([]*optimizerUnit{}, units...)
+	order := make([]*optimizerUnit, 0, len(units))
+	for len(remaining) > 0 {
+		nearest := 0
+		for i, unit := range remaining {
+			if start.distance(unit.start) < start.distance(remaining[nearest].start) {
+				nearest = i
+			}
+		}
+		unit := remaining[nearest]
+		order = append(order, unit)
+		remaining = append(remaining[:nearest], remaining[nearest+1:]...)
+		start = unit.end
+	}
+	return order
+}
+
+// optimizerTwoOpt improves order from start by reversing sub-sequences of units while that
+// shortens the path. As units do not start and end at the same position, reversing changes the
+// distance within the sub-sequence, which is computed from prefix sums. When fixedEnd is set, the
+// last unit is kept last.
+func optimizerTwoOpt(start point2, order []*optimizerUnit, fixedEnd bool) {
+	n := len(order)
+	movable := n
+	if fixedEnd {
+		movable--
+	}
+	// Distance from the end of each unit to the start of the next one (forward), and from the end
+	// of the next unit to the start of each one (reverse), accumulated.
+	forward := make([]float64, n)
+	reverse := make([]float64, n)
+	prefixSums := func() {
+		for k := 0; k+1 < n; k++ {
+			forward[k+1] = forward[k] + order[k].end.distance(order[k+1].start)
+			reverse[k+1] = reverse[k] + order[k+1].end.distance(order[k].start)
+		}
+	}
+	prefixSums()
+	for pass := 0; pass < optimizerMaxTwoOptPasses; pass++ {
+		improved := false
+		for i := 0; i < movable; i++ {
+			previous := start
+			if i > 0 {
+				previous = order[i-1].end
+			}
+			for j := i + 1; j < movable; j++ {
+				before := previous.distance(order[i].start) + forward[j] - forward[i]
+				after := previous.distance(order[j].start) + reverse[j] - reverse[i]
+				if j+1 < n {
+					before += order[j].end.distance(order[j+1].start)
+					after += order[i].end.distance(order[j+1].start)
+				}
+				if after < before-optimizerEpsilon {
+					for a, b := i, j; a < b; a, b = a+1, b-1 {
+						order[a], order[b] = order[b], order[a]
+					}
+					prefixSums()
+					improved = true
+				}
+			}
+		}
+		if !improved {
+			return
+		}
+	}
+}
+
+// Optimizer reorders "plunge–cut–retract" units of a program to minimize the rapid travel between
+// them, such as the holes of a drill program or the islands of an isolation routing program.
+//
+// A unit starts with a G0 block with X and Y (and nothing else) in absolute distance mode, followed
+// by G0/G1/G2/G3 motion, up to the next such G0 block or any other block. Consecutive units are
+// reordered when:
+//   - they end at the same Z, the travel height, and all but the first also start at it;
+//   - the first one starts at or above the travel height;
+//   - they keep modal state (other than motion) and feed rate unchanged, and share it.
+//
+// Units that do not meet these, such as ones after a tool change or that set the feed rate used by
+// later units, keep their order, as do all other lines. The last unit of a group is kept last when
+// the following lines depend on its XY position. Units are ordered by nearest neighbor followed by
+// 2-opt; the original order is kept if not improved.
+type Optimizer struct {
+	parser *Parser
+	lines  []string
+	// Rapid XY travel distance between reordered units, in millimeters.
+	originalDistance  float64
+	optimizedDistance float64
+}
+
+// NewOptimizer creates a new Optimizer.
+func NewOptimizer(parser *Parser) *Optimizer {
+	return &Optimizer{
+		parser: parser,
+	}
+}
+
+// isTravel returns whether block is a rapid XY move that starts a unit.
+func (o *Optimizer) isTravel(block *Block, motion bool, start, end position) bool {
+	if !block.IsCommand() || !motion || start.z == nil || end.x == nil || end.y == nil {
+		return false
+	}
+	if optimizerWordString(o.parser.ModalGroup.DistanceMode) != "G90" {
+		return false
+	}
+	var g0, x, y bool
+	for _, word := range block.Words() {
+		switch word.Letter() {
+		case 'G':
+			if word.NormalizedString() != "G0" {
+				return false
+			}
+			g0 = true
+		case 'X':
+			x = true
+		case 'Y':
+			y = true
+		case 'N':
+		default:
+			return false
+		}
+	}
+	return g0 && x && y
+}
+
+// isUnitMotion returns whether block can be part of a unit.
+func (o *Optimizer) isUnitMotion(block *Block, motion bool, end position) bool {
+	if !block.IsCommand() {
+		return false
+	}
+	for _, word := range block.Commands() {
+		if !optimizerUnitCommands[word.NormalizedString()] {
+			return false
+		}
+	}
+	axes := false
+	for _, word := range block.Arguments() {
+		if !optimizerUnitArguments[word.Letter()] {
+			return false
+		}
+		switch word.Letter() {
+		case 'X', 'Y', 'Z':
+			axes = true
+		}
+	}
+	if axes && !motion {
+		return false
+	}
+	return !motion || end.known()
+}
+
+// xyDependency returns how a block that is not part of a unit depends on the XY position.
+func (o *Optimizer) xyDependency(block *Block, motion bool, start, end position) (optimizerXYDependency, error) {
+	if block.IsSystem() {
+		return optimizerXYDependent, nil
+	}
+	axes, err := getAxes(block)
+	if err != nil {
+		return 0, err
+	}
+	for _, word := range block.Commands() {
+		switch word.NormalizedString() {
+		case "G28", "G30", "G38.2", "G38.3", "G38.4", "G38.5":
+			return optimizerXYDependent, nil
+		}
+	}
+	if axes.x == nil && axes.y == nil && axes.z == nil {
+		return optimizerXYNeutral, nil
+	}
+	if !motion {
+		return optimizerXYDependent, nil
+	}
+	switch optimizerWordString(o.parser.ModalGroup.Motion) {
+	case "G0", "G1":
+	default:
+		return optimizerXYDependent, nil
+	}
+	absolute := optimizerWordString(o.parser.ModalGroup.DistanceMode) == "G90"
+	if absolute && axes.x != nil && axes.y != nil {
+		return optimizerXYReset, nil
+	}
+	if axes.x == nil && axes.y == nil && start.z != nil && end.z != nil && *end.z >= *start.z {
+		return optimizerXYNeutral, nil
+	}
+	return optimizerXYDependent, nil
+}
+
+// read reads all of the program, splitting it into units and other lines.
+//
+//gocyclo:ignore
+func (o *Optimizer) read() ([]optimizerItem, error) {
+	items := []optimizerItem{}
+	tracker := newPositionTracker()
+	var feedRate *float64
+	var unit *optimizerUnit
+	// closeUnit ends the current unit, given the modal state and position after it.
+	closeUnit := func(modalGroup *ModalGroup, end position) {
+		if unit == nil {
+			return
+		}
+		unit.end = point2{*end.x, *end.y}
+		unit.endZ = *end.z
+		unit.endState = optimizerState(modalGroup, feedRate, true)
+		unit.stateKept = optimizerState(modalGroup, feedRate, false) == unit.startState
+		items = append(items, optimizerItem{unit: unit})
+		unit = nil
+	}
+	for {
+		lineNumber := o.parser.Lexer.Line
+		modalGroup := *o.parser.ModalGroup.Copy()
+		eof, block, tokens, err := o.parser.Next()
+		if err != nil {
+			return nil, err
+		}
+		line := tokens.String()
+		if block == nil {
+			if unit != nil {
+				unit.lines = append(unit.lines, line)
+			} else if line != "" {
+				items = append(items, optimizerItem{line: line})
+			}
+			if eof {
+				break
+			}
+			continue
+		}
+
+		start, motion, err := tracker.next(&o.parser.ModalGroup, block)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		end := tracker.current(&o.parser.ModalGroup)
+
+		if o.isTravel(block, motion, start, end) {
+			closeUnit(&modalGroup, start)
+			var from *point2
+			if start.x != nil && start.y != nil {
+				from = &point2{*start.x, *start.y}
+			}
+			unit = &optimizerUnit{
+				lines:      []string{line},
+				from:       from,
+				start:      point2{*end.x, *end.y},
+				startZ:     *start.z,
+				startState: optimizerState(&modalGroup, feedRate, false),
+				inches:     optimizerWordString(o.parser.ModalGroup.Units) == "G20",
+			}
+		} else if unit != nil && o.isUnitMotion(block, motion, end) {
+			unit.lines = append(unit.lines, line)
+		} else {
+			closeUnit(&modalGroup, start)
+			xyDependency, err := o.xyDependency(block, motion, start, end)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			items = append(items, optimizerItem{line: line, xyDependency: xyDependency})
+		}
+
+		if block.IsCommand() {
+			if f, err := block.GetArgumentNumber('F'); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			} else if f != nil {
+				feedRate = f
+			}
+		}
+
+		if eof {
+			break
+		}
+	}
+	closeUnit(&o.parser.ModalGroup, tracker.current(&o.parser.ModalGroup))
+	return items, nil
+}
+
+// fixedEnd returns whether the lines following items[i:] depend on the XY position at the end of
+// the previous unit.
+func (o *Optimizer) fixedEnd(items []optimizerItem, i int) bool {
+	for ; i < len(items); i++ {
+		item := items[i]
+		if item.unit != nil {
+			return false
+		}
+		switch item.xyDependency {
+		case optimizerXYReset:
+			return false
+		case optimizerXYDependent:
+			return true
+		}
+	}
+	return false
+}
+
+// optimize reorders the units of the group items[i:j]. from is the XY position before the group,
+// which differs from the original one when the previous group was reordered.
+func (o *Optimizer) optimize(items []optimizerItem, i, j int, from *point2) []*optimizerUnit {
+	units := make([]*optimizerUnit, 0, j-i)
+	for _, item := range items[i:j] {
+		units = append(units, item.unit)
+	}
+
+	scale := 1.0
+	if units[0].inches {
+		scale = 25.4
+	}
+
+	// When the position before the group is unknown, the first unit is kept first.
+	var prefix []*optimizerUnit
+	var originalStart, start point2
+	if from != nil {
+		originalStart = *units[0].from
+		start = *from
+	} else {
+		prefix = units[:1]
+		originalStart = units[0].end
+		start = units[0].end
+		units = units[1:]
+	}
+
+	original := optimizerPathLength(originalStart, units)
+	optimized := optimizerPathLength(start, units)
+	if len(units) > 1 {
+		fixedEnd := o.fixedEnd(items, j)
+		var order []*optimizerUnit
+		if fixedEnd {
+			order = append(optimizerNearestNeighbor(start, units[:len(units)-1]), units[len(units)-1])
+		} else {
+			order = optimizerNearestNeighbor(start, units)
+		}
+		optimizerTwoOpt(start, order, fixedEnd)
+		if length := optimizerPathLength(start, order); length < optimized-optimizerEpsilon {
+			units = order
+			optimized = length
+		}
+	}
+
+	o.originalDistance += original * scale
+	o.optimizedDistance += optimized * scale
+
+	return append(prefix, units...)
+}
+
+// groupEnd returns the end of the group of units that can be reordered starting at items[i], or
+// i+1 if the unit at items[i] can not be reordered.
+func (o *Optimizer) groupEnd(items []optimizerItem, i int) int {
+	first := items[i].unit
+	if !first.stateKept || first.startZ < first.endZ-optimizerEpsilon {
+		return i + 1
+	}
+	j := i + 1
+	for ; j < len(items); j++ {
+		unit := items[j].unit
+		if unit == nil ||
+			!unit.stateKept ||
+			unit.startState != first.startState ||
+			unit.endState != first.endState ||
+			math.Abs(unit.endZ-first.endZ) > optimizerEpsilon {
+			break
+		}
+	}
+	return j
+}
+
+func (o *Optimizer) run() error {
+	items, err := o.read()
+	if err != nil {
+		return err
+	}
+	o.lines = []string{}
+	// XY position after the last unit, as reordered.
+	var from *point2
+	for i := 0; i < len(items); {
+		if items[i].unit == nil {
+			o.lines = append(o.lines, items[i].line)
+			from = nil
+			i++
+			continue
+		}
+		if from == nil {
+			from = items[i].unit.from
+		}
+		j := o.groupEnd(items, i)
+		for _, unit := range o.optimize(items, i, j, from) {
+			o.lines = append(o.lines, strings.Join(unit.lines, ""))
+			from = &unit.end
+		}
+		i = j
+	}
+	return nil
+}
+
+// Next returns the next line(s) of G-code, including line endings. Returns nil when the end of
+// input is reached. All of the program is read at the first call.
+func (o *Optimizer) Next() (*string, error) {
+	if o.lines == nil {
+		if err := o.run(); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.lines) == 0 {
+		return nil, nil
+	}
+	line := o.lines[0]
+	o.lines = o.lines[1:]
+	return &line, nil
+}
+
+// TravelDistance returns the rapid XY travel distance between reorderable units, in millimeters,
+// of the original and of the optimized program. Only valid after Next returned nil.
+func (o *Optimizer) TravelDistance() (original, optimized float64) {
+	return o.originalDistance, o.optimizedDistance
+}
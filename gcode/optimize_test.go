@@ -0,0 +1,201 @@
+package gcode
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func optimize(gcode string) (string, float64, float64, error) {
+	optimizer := NewOptimizer(NewParser(strings.NewReader(gcode)))
+	output, err := transformString(optimizer)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	original, optimized := optimizer.TravelDistance()
+	return output, original, optimized, nil
+}
+
+func TestOptimizer(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		gcode             string
+		expected          string
+		originalDistance  float64
+		optimizedDistance float64
+		errorContains     string
+	}{
+		{
+			name: "drill holes",
+			gcode: "G0 Z5\nG0 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X11 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n" +
+				"M5\n",
+			expected: "G0 Z5\nG0 X0 Y0\nF100\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X11 Y0\nG1 Z-1\nG0 Z1\n" +
+				"M5\n",
+			originalDistance:  10 + 9 + 10 + 9,
+			optimizedDistance: 11,
+		},
+		{
+			name: "open paths with comments",
+			gcode: "G0 Z1 X0 Y0\nG1 F50\n" +
+				"G0 X10 Y0 (island 1)\nG1 Z-1\nX10 Y5\nG0 Z1\n" +
+				"G0 X0 Y1 (island 2)\nG1 Z-1\nX0 Y4\nG0 Z1\n",
+			expected: "G0 Z1 X0 Y0\nG1 F50\n" +
+				"G0 X0 Y1 (island 2)\nG1 Z-1\nX0 Y4\nG0 Z1\n" +
+				"G0 X10 Y0 (island 1)\nG1 Z-1\nX10 Y5\nG0 Z1\n",
+			originalDistance:  10 + 10.770329614269007,
+			optimizedDistance: 1 + 10.770329614269007,
+		},
+		{
+			name: "already optimal",
+			gcode: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n",
+			expected: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n",
+			originalDistance:  2,
+			optimizedDistance: 2,
+		},
+		{
+			name: "barriers keep order",
+			gcode: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"M0\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n",
+			expected: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"M0\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n",
+			originalDistance:  10 + 9,
+			optimizedDistance: 10 + 9,
+		},
+		{
+			name: "feed rate dependency",
+			gcode: "G0 Z1 X0 Y0\n" +
+				"G0 X10 Y0\nG1 Z-1 F100\nG0 Z1\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n",
+			expected: "G0 Z1 X0 Y0\n" +
+				"G0 X10 Y0\nG1 Z-1 F100\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n",
+			originalDistance:  10 + 9 + 1,
+			optimizedDistance: 10 + 8 + 1,
+		},
+		{
+			name: "different travel height",
+			gcode: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z2\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n",
+			expected: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z2\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n",
+			originalDistance:  10 + 9,
+			optimizedDistance: 10 + 9,
+		},
+		{
+			name: "first unit from above travel height",
+			gcode: "G0 Z5 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n",
+			expected: "G0 Z5 X0 Y0\nF100\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n",
+			originalDistance:  10 + 9,
+			optimizedDistance: 10,
+		},
+		{
+			name: "last unit kept when followed by relative motion",
+			gcode: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G91 G0 X5\n",
+			expected: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G91 G0 X5\n",
+			originalDistance:  10 + 9 + 1,
+			optimizedDistance: 1 + 9 + 8,
+		},
+		{
+			name: "group followed by unit that can not be reordered",
+			gcode: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z5\n",
+			expected: "G0 Z1 X0 Y0\nF100\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X2 Y0\nG1 Z-1\nG0 Z5\n",
+			originalDistance:  10 + 9 + 1,
+			optimizedDistance: 1 + 9 + 8,
+		},
+		{
+			name: "unknown start position keeps first unit",
+			gcode: "G0 Z1\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X0 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X9 Y0\nG1 Z-1\nG0 Z1\n",
+			expected: "G0 Z1\nF100\n" +
+				"G0 X10 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X9 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X0 Y0\nG1 Z-1\nG0 Z1\n",
+			originalDistance:  10 + 9,
+			optimizedDistance: 1 + 9,
+		},
+		{
+			name: "inches",
+			gcode: "G20 G0 Z1 X0 Y0\nF10\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n" +
+				"G0 X0 Y1\nG1 Z-1\nG0 Z1\n" +
+				"G0 X0 Y0.5\nG1 Z-1\nG0 Z1\n",
+			expected: "G20 G0 Z1 X0 Y0\nF10\n" +
+				"G0 X0 Y0.5\nG1 Z-1\nG0 Z1\n" +
+				"G0 X0 Y1\nG1 Z-1\nG0 Z1\n" +
+				"G0 X1 Y0\nG1 Z-1\nG0 Z1\n",
+			originalDistance:  (1 + 1.4142135623730951 + 0.5) * 25.4,
+			optimizedDistance: (0.5 + 0.5 + 1.4142135623730951) * 25.4,
+		},
+		{
+			name:          "syntax error",
+			gcode:         "G0 X\n",
+			errorContains: "line 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, original, optimized, err := optimize(tc.gcode)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+			require.InDelta(t, tc.originalDistance, original, 1e-6)
+			require.InDelta(t, tc.optimizedDistance, optimized, 1e-6)
+		})
+	}
+}
+
+func TestOptimizerTestData(t *testing.T) {
+	gcode, err := os.ReadFile("testdata/5.7_B_Drill.nc")
+	require.NoError(t, err)
+	output, original, optimized, err := optimize(string(gcode))
+	require.NoError(t, err)
+	require.LessOrEqual(t, optimized, original)
+	_, originalLines, _, err := optimize(output)
+	require.NoError(t, err)
+	require.InDelta(t, optimized, originalLines, 1e-6)
+	require.Equal(t, strings.Count(string(gcode), "\n"), strings.Count(output, "\n"))
+}
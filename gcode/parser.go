@@ -165,6 +165,10 @@ type Parser struct {
 	block      *Block
 	words      []*Word
 	letter     *rune
+	// leadingComments and trailingComments hold comments of the current line, before and after
+	// the system command or the first word.
+	leadingComments  []Comment
+	trailingComments []Comment
 }
 
 func NewParser(r io.Reader) *Parser {
@@ -234,7 +238,14 @@ func (p *Parser) handleToken(token *Token) (bool, error) {
 	switch token.Type {
 	case TokenTypeEOF:
 		return p.handleTokenTypeEOF()
-	case TokenTypeSpace, TokenTypeComment:
+	case TokenTypeSpace:
+		return false, nil
+	case TokenTypeComment:
+		if p.block == nil && len(p.words) == 0 && p.letter == nil {
+			p.leadingComments = append(p.leadingComments, Comment(token.Value))
+		} else {
+			p.trailingComments = append(p.trailingComments, Comment(token.Value))
+		}
 		return false, nil
 	case TokenTypeSystem:
 		if len(p.words) > 0 || p.letter != nil {
@@ -260,6 +271,8 @@ func (p *Parser) Next() (bool, *Block, Tokens, error) {
 	p.block = nil
 	p.words = nil
 	p.letter = nil
+	p.leadingComments = nil
+	p.trailingComments = nil
 	var tokens Tokens
 	for {
 		token, err := p.Lexer.Next()
@@ -273,6 +286,7 @@ func (p *Parser) Next() (bool, *Block, Tokens, error) {
 		}
 		if eol {
 			if p.block != nil {
+				p.block.SetComments(p.leadingComments, p.trailingComments)
 				if err := p.ModalGroup.UpdateFromBlock(p.block); err != nil {
 					return false, nil, nil, err
 				}
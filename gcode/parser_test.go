@@ -128,7 +128,7 @@ func TestParserWithTestData(t *testing.T) {
 					break
 				}
 				if block != nil {
-					parsedLines = append(parsedLines, block.String())
+					parsedLines = append(parsedLines, block.StringWithoutComments())
 				}
 			}
 
@@ -864,3 +864,70 @@ func TestParserBlocks(t *testing.T) {
 		})
 	}
 }
+
+func TestParserComments(t *testing.T) {
+	testCases := []struct {
+		name     string
+		gcode    string
+		leading  []Comment
+		trailing []Comment
+		messages []string
+		expected string
+	}{
+		{
+			name:     "no comments",
+			gcode:    "G0 X1\n",
+			expected: "G0X1",
+		},
+		{
+			name:     "trailing parenthesis",
+			gcode:    "G1 X1 (TOOL DIAMETER: 0.3 mm)\n",
+			trailing: []Comment{"(TOOL DIAMETER: 0.3 mm)"},
+			expected: "G1X1(TOOL DIAMETER: 0.3 mm)",
+		},
+		{
+			name:     "trailing semicolon",
+			gcode:    "G1 X1 ; foo",
+			trailing: []Comment{"; foo"},
+			expected: "G1X1; foo",
+		},
+		{
+			name:     "leading, inline and trailing",
+			gcode:    "(a) G1 (b) X1 ; c\n",
+			leading:  []Comment{"(a)"},
+			trailing: []Comment{"(b)", "; c"},
+			expected: "(a)G1X1(b); c",
+		},
+		{
+			name:     "system",
+			gcode:    "$H (home)\n",
+			trailing: []Comment{"(home)"},
+			expected: "$H(home)",
+		},
+		{
+			name:     "message",
+			gcode:    "M0 (MSG, Change to tool 2)\n",
+			trailing: []Comment{"(MSG, Change to tool 2)"},
+			messages: []string{"Change to tool 2"},
+			expected: "M0(MSG, Change to tool 2)",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			blocks, err := NewParser(strings.NewReader(tc.gcode)).Blocks()
+			require.NoError(t, err)
+			require.Len(t, blocks, 1)
+			block := blocks[0]
+			require.Equal(t, tc.leading, block.LeadingComments())
+			require.Equal(t, tc.trailing, block.TrailingComments())
+			require.Equal(t, tc.messages, block.Messages())
+			require.Equal(t, tc.expected, block.String())
+
+			blocks, err = NewParser(strings.NewReader(block.String())).Blocks()
+			require.NoError(t, err)
+			require.Len(t, blocks, 1)
+			require.Equal(t, block.String(), blocks[0].String())
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package gcode
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+
+	iFmt "github.com/fornellas/cgs/internal/fmt"
+)
+
+// PlotOptions configures Plot.
+type PlotOptions struct {
+	// Grid spacing in millimeters, or 0 to disable the grid.
+	Grid float64
+	// HeightMap is drawn under the toolpath, colored by Z deviation, when not nil.
+	HeightMap HeightMap
+}
+
+// DefaultPlotOptions are the default options for Plot.
+var DefaultPlotOptions = PlotOptions{
+	Grid: 0,
+}
+
+// plotTolerance is the chordal tolerance for arcs that are not at the XY plane, in millimeters.
+const plotTolerance = 0.001
+
+// plotHeightMapCells is the number of height map cells sampled along the largest toolpath dimension.
+const plotHeightMapCells = 50
+
+// plotSegment is a motion to draw, in work coordinates.
+type plotSegment struct {
+	rapid bool
+	start Vector
+	end   Vector
+	// points for motion that is not a straight line nor an arc at the XY plane.
+	points []Vector
+	// arc for arcs at the XY plane.
+	arc *Arc
+}
+
+// plotter accumulates segments from a program and renders them as SVG.
+type plotter struct {
+	options     PlotOptions
+	segments    []plotSegment
+	boundingBox *BoundingBox
+	z           *Range
+	legend      []string
+}
+
+// addSegments adds the segments of a block.
+func (p *plotter) addSegments(block *Block, segments []Segment) {
+	for _, w := range block.Commands() {
+		if slices.Contains(machineCoordinatesCommands, w.NormalizedString()) {
+			return
+		}
+	}
+	for _, segment := range segments {
+		if segment.Type == SegmentTypeProbe || !segment.StartKnown() {
+			continue
+		}
+		wco := segment.WorkCoordinateOffset
+		plotSegment := plotSegment{
+			rapid: segment.Type == SegmentTypeRapid,
+			start: segment.Start.Sub(wco),
+			end:   segment.End.Sub(wco),
+		}
+		points := segment.Points(plotTolerance)
+		if segment.Arc != nil {
+			if segment.Arc.Plane.NormalizedString() == "G17" {
+				arc := *segment.Arc
+				arc.Center = arc.Center.Sub(wco)
+				plotSegment.arc = &arc
+			} else {
+				for _, point := range points {
+					plotSegment.points = append(plotSegment.points, point.Sub(wco))
+				}
+			}
+		}
+		p.boundingBox = p.boundingBox.add(plotSegment.start)
+		for _, point := range points {
+			point = point.Sub(wco)
+			p.boundingBox = p.boundingBox.add(point)
+			if !plotSegment.rapid {
+				p.z = p.z.add(point.Z)
+			}
+		}
+		if !plotSegment.rapid {
+			p.z = p.z.add(plotSegment.start.Z)
+		}
+		p.segments = append(p.segments, plotSegment)
+	}
+}
+
+// plotNumber formats a SVG number.
+func plotNumber(v float64) string {
+	return iFmt.SprintFloat(v, 4)
+}
+
+// plotPoint formats a SVG point from given XY work coordinates. The Y axis is inverted, as SVG
+// has Y pointing down.
+func plotPoint(x, y float64) string {
+	return plotNumber(x) + " " + plotNumber(-y)
+}
+
+// plotColor returns a color from blue to red, for value in the [0, 1] range.
+func plotColor(value float64) string {
+	return fmt.Sprintf("hsl(%.0f,80%%,45%%)", 240*(1-math.Max(0, math.Min(1, value))))
+}
+
+// zColor returns the color for cutting motion at given Z: deepest cuts are red and shallowest
+// are blue.
+func (p *plotter) zColor(z float64) string {
+	if p.z.Max == p.z.Min {
+		return plotColor(1)
+	}
+	return plotColor((p.z.Max - z) / (p.z.Max - p.z.Min))
+}
+
+// pathData returns the SVG path data for segment, without the initial move.
+func (s *plotSegment) pathData() string {
+	switch {
+	case s.arc != nil:
+		var data []string
+		pieces := int(math.Max(1, math.Ceil(math.Abs(s.arc.AngularTravel)/math.Pi-1e-9)))
+		angle := math.Atan2(s.start.Y-s.arc.Center.Y, s.start.X-s.arc.Center.X)
+		sweep := 0
+		if s.arc.AngularTravel > 0 {
+			// Counterclockwise in work coordinates is clockwise in SVG coordinates.
+			sweep = 1
+		}
+		radius := plotNumber(s.arc.Radius)
+		for i := 1; i <= pieces; i++ {
+			x, y := s.end.X, s.end.Y
+			if i < pieces {
+				a := angle + s.arc.AngularTravel*float64(i)/float64(pieces)
+				x = s.arc.Center.X + s.arc.Radius*math.Cos(a)
+				y = s.arc.Center.Y + s.arc.Radius*math.Sin(a)
+			}
+			data = append(data, fmt.Sprintf("A%s %s 0 0 %d %s", radius, radius, sweep, plotPoint(x, y)))
+		}
+		return strings.Join(data, " ")
+	case s.points != nil:
+		var data []string
+		for _, point := range s.points {
+			data = append(data, "L"+plotPoint(point.X, point.Y))
+		}
+		return strings.Join(data, " ")
+	default:
+		return "L" + plotPoint(s.end.X, s.end.Y)
+	}
+}
+
+// color returns the stroke color for segment.
+func (p *plotter) color(s *plotSegment) string {
+	if s.rapid {
+		return "#888"
+	}
+	return p.zColor((s.start.Z + s.end.Z) / 2)
+}
+
+// writePaths writes the toolpath, joining consecutive segments with the same style.
+func (p *plotter) writePaths(b *strings.Builder, strokeWidth float64) {
+	var data []string
+	var color string
+	var rapid bool
+	var end Vector
+	flush := func() {
+		if len(data) == 0 {
+			return
+		}
+		dash := ""
+		if rapid {
+			dash = fmt.Sprintf(` stroke-dasharray="%s %s"`, plotNumber(strokeWidth*4), plotNumber(strokeWidth*3))
+		}
+		fmt.Fprintf(b, `<path d="%s" fill="none" stroke="%s" stroke-width="%s"%s/>`+"\n", strings.Join(data, " "), color, plotNumber(strokeWidth), dash)
+		data = nil
+	}
+	for i := range p.segments {
+		s := &p.segments[i]
+		if s.start.X == s.end.X && s.start.Y == s.end.Y && s.arc == nil {
+			continue
+		}
+		segmentColor := p.color(s)
+		if len(data) == 0 || segmentColor != color || s.rapid != rapid || s.start.X != end.X || s.start.Y != end.Y {
+			flush()
+			data = append(data, "M"+plotPoint(s.start.X, s.start.Y))
+			color = segmentColor
+			rapid = s.rapid
+		}
+		data = append(data, s.pathData())
+		end = s.end
+	}
+	flush()
+}
+
+// writeHeightMap writes height map cells sampled over box.
+func (p *plotter) writeHeightMap(b *strings.Builder, box *BoundingBox) {
+	width := box.Max.X - box.Min.X
+	height := box.Max.Y - box.Min.Y
+	cell := math.Max(width, height) / plotHeightMapCells
+	if cell <= 0 {
+		return
+	}
+	columns := int(math.Ceil(width / cell))
+	rows := int(math.Ceil(height / cell))
+	values := make([]*float64, columns*rows)
+	var deviation *Range
+	for row := range rows {
+		for column := range columns {
+			x := box.Min.X + (float64(column)+0.5)*cell
+			y := box.Min.Y + (float64(row)+0.5)*cell
+			value := p.options.HeightMap.GetInterpolatedValue(x, y)
+			if value == nil {
+				continue
+			}
+			values[row*columns+column] = value
+			deviation = deviation.add(*value)
+		}
+	}
+	if deviation == nil {
+		return
+	}
+	b.WriteString(`<g opacity="0.35">` + "\n")
+	for row := range rows {
+		for column := range columns {
+			value := values[row*columns+column]
+			if value == nil {
+				continue
+			}
+			color := plotColor(0.5)
+			if deviation.Max != deviation.Min {
+				color = plotColor((*value - deviation.Min) / (deviation.Max - deviation.Min))
+			}
+			x := box.Min.X + float64(column)*cell
+			y := box.Min.Y + float64(row+1)*cell
+			fmt.Fprintf(b, `<rect x="%s" y="%s" width="%s" height="%s" fill="%s"/>`+"\n",
+				plotNumber(x), plotNumber(-y), plotNumber(cell), plotNumber(cell), color)
+		}
+	}
+	b.WriteString("</g>\n")
+	p.legend = append(p.legend, fmt.Sprintf(
+		"Height map: %s .. %s mm (blue lowest, red highest)", plotNumber(deviation.Min), plotNumber(deviation.Max),
+	))
+}
+
+// writeGrid writes grid lines at multiples of the grid spacing over box.
+func (p *plotter) writeGrid(b *strings.Builder, box *BoundingBox, strokeWidth float64) {
+	grid := p.options.Grid
+	if (box.Max.X-box.Min.X)/grid > 1000 || (box.Max.Y-box.Min.Y)/grid > 1000 {
+		return
+	}
+	fmt.Fprintf(b, `<g stroke="#ddd" stroke-width="%s">`+"\n", plotNumber(strokeWidth/2))
+	for x := math.Ceil(box.Min.X/grid) * grid; x <= box.Max.X; x += grid {
+		fmt.Fprintf(b, `<line x1="%s" y1="%s" x2="%s" y2="%s"/>`+"\n",
+			plotNumber(x), plotNumber(-box.Min.Y), plotNumber(x), plotNumber(-box.Max.Y))
+	}
+	for y := math.Ceil(box.Min.Y/grid) * grid; y <= box.Max.Y; y += grid {
+		fmt.Fprintf(b, `<line x1="%s" y1="%s" x2="%s" y2="%s"/>`+"\n",
+			plotNumber(box.Min.X), plotNumber(-y), plotNumber(box.Max.X), plotNumber(-y))
+	}
+	b.WriteString("</g>\n")
+}
+
+// write writes the SVG image.
+func (p *plotter) write(w io.Writer) error {
+	toolpath := BoundingBox{}
+	if p.boundingBox != nil {
+		toolpath = *p.boundingBox
+		p.legend = append(p.legend, fmt.Sprintf(
+			"Size: %s x %s mm",
+			plotNumber(toolpath.Max.X-toolpath.Min.X), plotNumber(toolpath.Max.Y-toolpath.Min.Y),
+		))
+	}
+	if p.z != nil {
+		p.legend = append(p.legend, fmt.Sprintf(
+			"Cutting Z: %s .. %s mm (red deepest, blue shallowest)", plotNumber(p.z.Min), plotNumber(p.z.Max),
+		))
+	}
+	view := toolpath
+	view.add(Vector{})
+	size := math.Max(view.Max.X-view.Min.X, view.Max.Y-view.Min.Y)
+	if size == 0 {
+		size = 1
+	}
+	strokeWidth := size / 500
+	margin := size / 20
+	view.Min = view.Min.Sub(Vector{X: margin, Y: margin})
+	view.Max = view.Max.Add(Vector{X: margin, Y: margin})
+
+	var content strings.Builder
+	if p.options.Grid > 0 {
+		p.writeGrid(&content, &view, strokeWidth)
+	}
+	if p.options.HeightMap != nil && p.boundingBox != nil {
+		p.writeHeightMap(&content, &toolpath)
+	}
+	if p.boundingBox != nil {
+		fmt.Fprintf(&content, `<rect x="%s" y="%s" width="%s" height="%s" fill="none" stroke="#000" stroke-width="%s" stroke-dasharray="%s"/>`+"\n",
+			plotNumber(toolpath.Min.X), plotNumber(-toolpath.Max.Y),
+			plotNumber(toolpath.Max.X-toolpath.Min.X), plotNumber(toolpath.Max.Y-toolpath.Min.Y),
+			plotNumber(strokeWidth/2), plotNumber(strokeWidth*2),
+		)
+	}
+	p.writePaths(&content, strokeWidth)
+	axis := size / 20
+	fmt.Fprintf(&content, `<line x1="0" y1="0" x2="%s" y2="0" stroke="red" stroke-width="%s"/>`+"\n", plotNumber(axis), plotNumber(strokeWidth*2))
+	fmt.Fprintf(&content, `<line x1="0" y1="0" x2="0" y2="%s" stroke="green" stroke-width="%s"/>`+"\n", plotNumber(-axis), plotNumber(strokeWidth*2))
+	fmt.Fprintf(&content, `<circle cx="0" cy="0" r="%s" fill="none" stroke="#000" stroke-width="%s"/>`+"\n", plotNumber(axis/5), plotNumber(strokeWidth))
+
+	fontSize := size / 40
+	legendHeight := fontSize * 1.5 * float64(len(p.legend))
+	view.Max.Y += legendHeight
+	for i, line := range p.legend {
+		fmt.Fprintf(&content, `<text x="%s" y="%s" font-family="sans-serif" font-size="%s">%s</text>`+"\n",
+			plotNumber(view.Min.X+margin/2), plotNumber(-view.Max.Y+margin/2+fontSize*(1.5*float64(i)+1)),
+			plotNumber(fontSize), line,
+		)
+	}
+
+	width := view.Max.X - view.Min.X
+	height := view.Max.Y - view.Min.Y
+	if _, err := fmt.Fprintf(w,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="%smm" height="%smm" viewBox="%s %s %s %s">`+"\n"+
+			`<rect x="%s" y="%s" width="%s" height="%s" fill="#fff"/>`+"\n"+
+			"%s</svg>\n",
+		plotNumber(width), plotNumber(height),
+		plotNumber(view.Min.X), plotNumber(-view.Max.Y), plotNumber(width), plotNumber(height),
+		plotNumber(view.Min.X), plotNumber(-view.Max.Y), plotNumber(width), plotNumber(height),
+		content.String(),
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Plot writes an SVG image of the XY toolpath for all blocks from parser, executed with given
+// Machine, in work coordinates. Cutting motion is colored by Z and rapids are dashed, with the
+// work origin and the toolpath bounding box. Arcs at the XY plane are drawn as exact SVG arcs.
+// Motion in machine coordinates (G28, G30, G53) and probing motion are not drawn.
+func Plot(w io.Writer, parser *Parser, machine *Machine, options PlotOptions) error {
+	p := plotter{options: options}
+	for {
+		eof, block, segments, err := machine.Next(parser)
+		if err != nil {
+			return err
+		}
+		if block != nil {
+			p.addSegments(block, segments)
+		}
+		if eof {
+			return p.write(w)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package gcode
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type plotHeightMap struct{}
+
+func (plotHeightMap) GetInterpolatedValue(x, y float64) *float64 {
+	if x > 5 {
+		return nil
+	}
+	value := x / 10
+	return &value
+}
+
+func plot(t *testing.T, gcode string, machine *Machine, options PlotOptions) string {
+	var buf bytes.Buffer
+	err := Plot(&buf, NewParser(strings.NewReader(gcode)), machine, options)
+	require.NoError(t, err)
+	decoder := xml.NewDecoder(&buf)
+	svg := buf.String()
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	return svg
+}
+
+func TestPlot(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		gcode           string
+		unknownPosition bool
+		options         PlotOptions
+		contains        []string
+		notContains     []string
+	}{
+		{
+			name:  "rapids and cuts",
+			gcode: "G0 X1 Y1\nG1 Z-1 F100\nX2\nG1 Z-2\nX3\nG0 Z1\n",
+			contains: []string{
+				`<path d="M0 0 L1 -1" fill="none" stroke="#888" stroke-width="0.006" stroke-dasharray="0.024 0.018"/>`,
+				`<path d="M1 -1 L2 -1" fill="none" stroke="hsl(120,80%,45%)" stroke-width="0.006"/>`,
+				`<path d="M2 -1 L3 -1" fill="none" stroke="hsl(0,80%,45%)" stroke-width="0.006"/>`,
+				`<rect x="0" y="-1" width="3" height="1" fill="none" stroke="#000"`,
+				"Size: 3 x 1 mm",
+				"Cutting Z: -2 .. 0 mm",
+			},
+			notContains: []string{`stroke="#ddd"`},
+		},
+		{
+			name:            "unknown position",
+			gcode:           "G0 Z1\nG0 X1 Y1\nG1 Z-1 F100\nX2\n",
+			unknownPosition: true,
+			contains: []string{
+				`<path d="M1 -1 L2 -1" fill="none" stroke="hsl(0,80%,45%)"`,
+				`<rect x="1" y="-1" width="1" height="0" fill="none" stroke="#000"`,
+			},
+			notContains: []string{`M0 0`},
+		},
+		{
+			name:  "arcs",
+			gcode: "G0 X1 Y0\nG1 Z-1 F100\nG3 X-1 Y0 I-1 J0\nG2 X1 Y0 I1\nG3 I-1\n",
+			contains: []string{
+				`<path d="M1 0 A1 1 0 0 1 -1 0 A1 1 0 0 0 1 0 A1 1 0 0 1 -1 0 A1 1 0 0 1 1 0"`,
+			},
+		},
+		{
+			name:  "arc at other plane",
+			gcode: "G0 X0 Y0 Z0\nG18 G2 X2 Z0 I1 F100\n",
+			contains: []string{
+				`<path d="M0 0 L`,
+			},
+			notContains: []string{" A"},
+		},
+		{
+			name:  "work coordinates",
+			gcode: "G0 X1 Y1\nG92 X0 Y0\nG1 X1 F100\nG53 G0 X0 Y0\n",
+			contains: []string{
+				`<path d="M0 0 L1 0" fill="none" stroke="hsl(0,80%,45%)"`,
+			},
+			notContains: []string{`L-1 1`},
+		},
+		{
+			name:     "grid",
+			gcode:    "G0 X20 Y10\n",
+			options:  PlotOptions{Grid: 10},
+			contains: []string{`stroke="#ddd"`, `<line x1="10" y1="1" x2="10" y2="-11"/>`},
+		},
+		{
+			name:    "height map",
+			gcode:   "G0 X10 Y10\n",
+			options: PlotOptions{HeightMap: plotHeightMap{}},
+			contains: []string{
+				`<g opacity="0.35">`,
+				`<rect x="0" y="-0.2" width="0.2" height="0.2" fill="hsl(240,80%,45%)"/>`,
+				"Height map: 0.01 .. 0.49 mm",
+			},
+			notContains: []string{`<rect x="5.2"`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			machine := NewMachine(MachineParameters{}, Vector{})
+			if tc.unknownPosition {
+				machine = NewMachineAtUnknownPosition(MachineParameters{})
+			}
+			svg := plot(t, tc.gcode, machine, tc.options)
+			for _, s := range tc.contains {
+				require.Contains(t, svg, s)
+			}
+			for _, s := range tc.notContains {
+				require.NotContains(t, svg, s)
+			}
+		})
+	}
+}
+
+func TestPlotTestData(t *testing.T) {
+	matches, err := filepath.Glob("testdata/*.nc")
+	require.NoError(t, err)
+	require.NotEmpty(t, matches)
+	for _, path := range matches {
+		t.Run(path, func(t *testing.T) {
+			gcode, err := os.ReadFile(path)
+			require.NoError(t, err)
+			plot(t, string(gcode), NewMachineAtUnknownPosition(MachineParameters{}), PlotOptions{Grid: 10})
+		})
+	}
+}
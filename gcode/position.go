@@ -0,0 +1,93 @@
+package gcode
+
+// axisLetters maps axis indexes to letters.
+var axisLetters = [3]rune{'X', 'Y', 'Z'}
+
+// position holds XYZ work coordinates. Each axis is nil when unknown.
+type position struct {
+	x *float64
+	y *float64
+	z *float64
+}
+
+func (p position) known() bool {
+	return p.x != nil && p.y != nil && p.z != nil
+}
+
+// positionTracker tracks the tool position in work coordinates as blocks are parsed. It executes
+// blocks with a Machine at unknown position and parameters, so that the position is lost after
+// motion not in work coordinates, and coordinate system changes.
+type positionTracker struct {
+	machine *Machine
+}
+
+func newPositionTracker() *positionTracker {
+	return &positionTracker{
+		machine: newMachineAtUnknownParameters(),
+	}
+}
+
+// current returns the tracked position in the units at modalGroup.
+func (p *positionTracker) current(modalGroup *ModalGroup) position {
+	factor := unitsFactor(modalGroup)
+	var current position
+	for i, axis := range []**float64{&current.x, &current.y, &current.z} {
+		if p.machine.positionKnown[i] {
+			v := p.machine.Position.axis(i) / factor
+			*axis = &v
+		}
+	}
+	return current
+}
+
+// set sets the tracked position, in the units at modalGroup. Nil axes become unknown.
+func (p *positionTracker) set(modalGroup *ModalGroup, position position) {
+	factor := unitsFactor(modalGroup)
+	for i, v := range [3]*float64{position.x, position.y, position.z} {
+		p.machine.positionKnown[i] = v != nil
+		if v != nil {
+			p.machine.Position.setAxis(i, *v*factor)
+		}
+	}
+}
+
+// getAxes returns X, Y and Z words from block.
+func getAxes(block *Block) (position, error) {
+	var p position
+	var err error
+	if p.x, err = block.GetArgumentNumber('X'); err != nil {
+		return p, err
+	}
+	if p.y, err = block.GetArgumentNumber('Y'); err != nil {
+		return p, err
+	}
+	if p.z, err = block.GetArgumentNumber('Z'); err != nil {
+		return p, err
+	}
+	return p, nil
+}
+
+// next updates the tracked position with given block, which must have already been applied to
+// modalGroup. It returns the start position (converted to the block units) and whether the block
+// is a G0/G1/G2/G3 motion in work coordinates.
+func (p *positionTracker) next(modalGroup *ModalGroup, block *Block) (position, bool, error) {
+	start := p.current(modalGroup)
+	segments, err := p.machine.execute(block, *modalGroup)
+	if err != nil {
+		return start, false, err
+	}
+	if len(segments) == 0 {
+		return start, false, nil
+	}
+	for _, w := range block.Commands() {
+		switch w.NormalizedString() {
+		case "G28", "G30", "G53":
+			return start, false, nil
+		}
+	}
+	switch modalGroup.Motion.NormalizedString() {
+	case "G0", "G1", "G2", "G3":
+		return start, true, nil
+	}
+	return start, false, nil
+}
@@ -0,0 +1,274 @@
+package gcode
+
+import (
+	"fmt"
+	"strings"
+
+	iFmt "github.com/fornellas/cgs/internal/fmt"
+)
+
+// ResumeOptions configures Resume.
+type ResumeOptions struct {
+	// Line to resume the program at, starting at 1.
+	Line uint
+	// SafeZ is the work Z coordinate, in millimeters, to retract to before moving to the XY
+	// position where the program is resumed.
+	SafeZ float64
+	// SpindleDwell is the time, in seconds, to wait for the spindle to reach its speed.
+	SpindleDwell float64
+	// PlungeFeedRate, in millimeters per minute, to move down to the Z position where the program
+	// is resumed, or 0 to use the program feed rate.
+	PlungeFeedRate float64
+}
+
+// DefaultResumeOptions are the default options for Resume.
+var DefaultResumeOptions = ResumeOptions{
+	Line:           1,
+	SafeZ:          5,
+	SpindleDwell:   3,
+	PlungeFeedRate: 0,
+}
+
+// Commands that change parameters that depend on the position at the time they are executed, so
+// the program can not be resumed after them.
+var resumeUnsupportedCommands = map[string]bool{
+	"G10":   true,
+	"G28.1": true,
+	"G30.1": true,
+	"G92":   true,
+}
+
+// Commands that use axis words without the motion mode.
+var resumeNonModalAxisCommands = map[string]bool{
+	"G10": true,
+	"G28": true,
+	"G30": true,
+	"G92": true,
+}
+
+// Resume resumes a program at a given line. Previous lines are not executed, but their modal state
+// and position are tracked: a preamble restores them, with units, plane, distance mode,
+// coordinate system, tool length offset, spindle and coolant. It then retracts to a safe Z, moves
+// to the XY position where the program is resumed, and plunges at feed rate to its Z position.
+// The motion mode is restored for the first block that relies on it. Tool changes (M6) from
+// previous lines are not executed: only the selected tool (T) is restored.
+type Resume struct {
+	parser          *Parser
+	options         ResumeOptions
+	positionTracker *positionTracker
+	// feedRate in millimeters per minute, for units per minute mode (G94).
+	feedRate              *float64
+	spindleSpeed          *float64
+	tool                  *float64
+	clearCoordinateOffset bool
+	resumed               bool
+	// preambleLines is the number of output lines of the preamble.
+	preambleLines uint
+	// pendingMotion is the motion mode to restore at the first block that relies on it.
+	pendingMotion *Word
+}
+
+// NewResume creates a new Resume.
+func NewResume(parser *Parser, options ResumeOptions) *Resume {
+	return &Resume{
+		parser:          parser,
+		options:         options,
+		positionTracker: newPositionTracker(),
+		resumed:         options.Line <= 1,
+	}
+}
+
+// skip tracks the state of a block that is not executed.
+func (r *Resume) skip(block *Block) error {
+	for _, word := range block.Commands() {
+		command := word.NormalizedString()
+		if resumeUnsupportedCommands[command] {
+			return fmt.Errorf("%s: can not resume after %s", block, command)
+		}
+		if command == "G92.1" {
+			r.clearCoordinateOffset = true
+		}
+	}
+	if _, _, err := r.positionTracker.next(&r.parser.ModalGroup, block); err != nil {
+		return err
+	}
+	if block.IsSystem() {
+		return nil
+	}
+	feedRate, err := block.GetArgumentNumber('F')
+	if err != nil {
+		return err
+	}
+	if feedRate != nil {
+		mm := *feedRate * r.unitsFactor()
+		r.feedRate = &mm
+	}
+	spindleSpeed, err := block.GetArgumentNumber('S')
+	if err != nil {
+		return err
+	}
+	if spindleSpeed != nil {
+		r.spindleSpeed = spindleSpeed
+	}
+	tool, err := block.GetArgumentNumber('T')
+	if err != nil {
+		return err
+	}
+	if tool != nil {
+		r.tool = tool
+	}
+	return nil
+}
+
+// unitsFactor returns the factor to convert the current units to millimeters.
+func (r *Resume) unitsFactor() float64 {
+	return unitsFactor(&r.parser.ModalGroup)
+}
+
+// number formats a number in the current units from millimeters.
+func (r *Resume) number(mm float64) string {
+	return iFmt.SprintFloat(mm/r.unitsFactor(), 4)
+}
+
+// preamble returns the lines that restore the state at the line the program is resumed at.
+//
+//gocyclo:ignore
+func (r *Resume) preamble() (string, error) {
+	p := r.positionTracker.current(&r.parser.ModalGroup)
+	if !p.known() {
+		return "", fmt.Errorf("line %d: position is unknown", r.options.Line)
+	}
+
+	modalGroup := &r.parser.ModalGroup
+	inverseTime := modalGroup.FeedRateMode.NormalizedString() == "G93"
+	var plungeFeedRate string
+	switch {
+	case r.options.PlungeFeedRate > 0:
+		plungeFeedRate = r.number(r.options.PlungeFeedRate)
+	case r.feedRate != nil && !inverseTime:
+		plungeFeedRate = r.number(*r.feedRate)
+	default:
+		return "", fmt.Errorf("line %d: feed rate is unknown, a plunge feed rate is required", r.options.Line)
+	}
+
+	lines := []string{
+		fmt.Sprintf("(Resume at line %d)", r.options.Line),
+		modalGroup.Units.NormalizedString(),
+		modalGroup.PlaneSelection.NormalizedString(),
+		"G90",
+		"G94",
+		modalGroup.CoordinateSystemSelect.NormalizedString(),
+		modalGroup.ToolLengthOffset.NormalizedString(),
+	}
+	if r.clearCoordinateOffset {
+		lines = append(lines, "G92.1")
+	}
+	if r.tool != nil {
+		lines = append(lines, "T"+iFmt.SprintFloat(*r.tool, 0))
+	}
+	spindle := modalGroup.Spindle.NormalizedString()
+	if spindle == "M5" {
+		lines = append(lines, spindle)
+	} else {
+		if r.spindleSpeed != nil {
+			spindle += "S" + iFmt.SprintFloat(*r.spindleSpeed, 4)
+		}
+		lines = append(lines, spindle)
+		if r.options.SpindleDwell > 0 {
+			lines = append(lines, "G4P"+iFmt.SprintFloat(r.options.SpindleDwell, 4))
+		}
+	}
+	for _, coolant := range modalGroup.Coolant {
+		lines = append(lines, coolant.NormalizedString())
+	}
+	lines = append(lines,
+		"G0Z"+r.number(r.options.SafeZ),
+		"G0X"+iFmt.SprintFloat(*p.x, 4)+"Y"+iFmt.SprintFloat(*p.y, 4),
+		"G1Z"+iFmt.SprintFloat(*p.z, 4)+"F"+plungeFeedRate,
+	)
+	if inverseTime {
+		lines = append(lines, "G93")
+	} else if r.feedRate != nil && r.number(*r.feedRate) != plungeFeedRate {
+		lines = append(lines, "F"+r.number(*r.feedRate))
+	}
+	if modalGroup.DistanceMode.NormalizedString() == "G91" {
+		lines = append(lines, "G91")
+	}
+	if modalGroup.Motion.NormalizedString() != "G1" {
+		r.pendingMotion = modalGroup.Motion
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// resumeBlock adds the pending motion mode to the first block that relies on it.
+func (r *Resume) resumeBlock(block *Block) ([]*Block, error) {
+	if r.pendingMotion == nil || !block.IsCommand() {
+		return nil, nil
+	}
+	var hasAxes bool
+	for _, word := range block.Words() {
+		command := word.NormalizedString()
+		if resumeNonModalAxisCommands[command] {
+			return nil, nil
+		}
+		switch command {
+		case "G0", "G1", "G2", "G3", "G38.2", "G38.3", "G38.4", "G38.5", "G80":
+			r.pendingMotion = nil
+			return nil, nil
+		}
+		switch word.Letter() {
+		case 'X', 'Y', 'Z', 'I', 'J', 'K', 'R':
+			hasAxes = true
+		}
+	}
+	if !hasAxes {
+		return nil, nil
+	}
+	resumed := NewBlockCommand(append([]*Word{NewWord(r.pendingMotion.Letter(), r.pendingMotion.Number())}, block.Words()...)...)
+	resumed.SetComments(block.LeadingComments(), block.TrailingComments())
+	r.pendingMotion = nil
+	return []*Block{resumed}, nil
+}
+
+// Next returns the next line(s) of G-code, starting with the preamble. Returns nil when the end of
+// input is reached.
+func (r *Resume) Next() (*string, error) {
+	for !r.resumed {
+		if r.parser.Lexer.Line >= r.options.Line {
+			r.resumed = true
+			preamble, err := r.preamble()
+			if err != nil {
+				return nil, err
+			}
+			r.preambleLines = uint(strings.Count(preamble, "\n"))
+			return &preamble, nil
+		}
+		lineNumber := r.parser.Lexer.Line
+		eof, block, _, err := r.parser.Next()
+		if err != nil {
+			return nil, err
+		}
+		if block != nil {
+			if err := r.skip(block); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+		}
+		if eof {
+			return nil, fmt.Errorf("line %d is past the end of the program", r.options.Line)
+		}
+	}
+	return blockTransformNext(r.parser, r.resumeBlock)
+}
+
+// SourceLine returns the line of the program being resumed that an output line, starting at 1, comes
+// from. Preamble lines come from the line the program is resumed at. Lines are shifted by the
+// preamble only once Next returned it.
+func (r *Resume) SourceLine(line uint) uint {
+	if line == 0 || r.preambleLines == 0 {
+		return line
+	}
+	if line <= r.preambleLines {
+		return r.options.Line
+	}
+	return line - r.preambleLines + r.options.Line - 1
+}
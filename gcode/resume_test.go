@@ -0,0 +1,145 @@
+package gcode
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func resume(gcode string, options ResumeOptions) (string, error) {
+	return transformString(NewResume(NewParser(strings.NewReader(gcode)), options))
+}
+
+func TestResume(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		gcode         string
+		options       ResumeOptions
+		expected      string
+		errorContains string
+	}{
+		{
+			name:     "first line",
+			gcode:    "G0 X1\nG1 X2 F100\n",
+			options:  DefaultResumeOptions,
+			expected: "G0 X1\nG1 X2 F100\n",
+		},
+		{
+			name: "restores state",
+			gcode: "G21 G90 G55\n" +
+				"T2 M6\n" +
+				"M3 S10000\n" +
+				"M8\n" +
+				"G0 Z1\n" +
+				"G0 X10 Y5\n" +
+				"G1 Z-1 F50\n" +
+				"G1 X20 F200\n" +
+				"X30\n" +
+				"X40\n",
+			options: ResumeOptions{Line: 10, SafeZ: 5, SpindleDwell: 2},
+			expected: "(Resume at line 10)\n" +
+				"G21\nG17\nG90\nG94\nG55\nG49\n" +
+				"T2\n" +
+				"M3S10000\nG4P2\n" +
+				"M8\n" +
+				"G0Z5\n" +
+				"G0X30Y5\n" +
+				"G1Z-1F200\n" +
+				"X40\n",
+		},
+		{
+			name: "plunge feed rate and motion mode",
+			gcode: "G0 X0 Y0 Z1\n" +
+				"G1 Z-1 F100\n" +
+				"G2 X2 Y0 I1 J0\n" +
+				"X0 Y0 I-1 J0 (back)\n",
+			options: ResumeOptions{Line: 4, SafeZ: 3, PlungeFeedRate: 30},
+			expected: "(Resume at line 4)\n" +
+				"G21\nG17\nG90\nG94\nG54\nG49\n" +
+				"M5\nM9\n" +
+				"G0Z3\n" +
+				"G0X2Y0\n" +
+				"G1Z-1F30\n" +
+				"F100\n" +
+				"G2X0Y0I-1J0(back)\n",
+		},
+		{
+			name: "inches and incremental",
+			gcode: "G20 G0 X1 Y1 Z0.1\n" +
+				"G1 Z-0.01 F10\n" +
+				"G91 G1 X0.5\n" +
+				"X0.5\n",
+			options: ResumeOptions{Line: 4, SafeZ: 2.54},
+			expected: "(Resume at line 4)\n" +
+				"G20\nG17\nG90\nG94\nG54\nG49\n" +
+				"M5\nM9\n" +
+				"G0Z0.1\n" +
+				"G0X1.5Y1\n" +
+				"G1Z-0.01F10\n" +
+				"G91\n" +
+				"X0.5\n",
+		},
+		{
+			name:          "unknown position",
+			gcode:         "G1 X1 Y1 F100\nX2\n",
+			options:       ResumeOptions{Line: 2},
+			errorContains: "line 2: position is unknown",
+		},
+		{
+			name:          "unknown feed rate",
+			gcode:         "G0 X1 Y1 Z1\nG0 X2\n",
+			options:       ResumeOptions{Line: 2},
+			errorContains: "line 2: feed rate is unknown",
+		},
+		{
+			name:          "unsupported command",
+			gcode:         "G0 X0 Y0 Z0\nG92 X1\nG1 X2 F100\n",
+			options:       ResumeOptions{Line: 3},
+			errorContains: "line 2: G92X1: can not resume after G92",
+		},
+		{
+			name:          "past end",
+			gcode:         "G0 X0 Y0 Z0\n",
+			options:       ResumeOptions{Line: 3},
+			errorContains: "line 3 is past the end of the program",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := resume(tc.gcode, tc.options)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestResumeTransformReader(t *testing.T) {
+	gcode := "G0 X0 Y0 Z1\nG1 Z-1 F100\nX10\nX20\n"
+	expected, err := resume(gcode, ResumeOptions{Line: 4, SafeZ: 5})
+	require.NoError(t, err)
+
+	reader := NewTransformReader(NewResume(NewParser(strings.NewReader(gcode)), ResumeOptions{Line: 4, SafeZ: 5}))
+	output, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, expected, string(output))
+}
+
+func TestResumeSourceLine(t *testing.T) {
+	gcode := "G0 X0 Y0 Z1\nG1 Z-1 F100\nX10\nX20\n"
+	resume := NewResume(NewParser(strings.NewReader(gcode)), ResumeOptions{Line: 3, SafeZ: 5})
+	output, err := io.ReadAll(NewTransformReader(resume))
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(string(output), "\n"), "\n")
+	require.Equal(t, "X10", lines[len(lines)-2])
+	require.Equal(t, uint(3), resume.SourceLine(uint(len(lines)-1)))
+	require.Equal(t, "X20", lines[len(lines)-1])
+	require.Equal(t, uint(4), resume.SourceLine(uint(len(lines))))
+	require.Equal(t, uint(3), resume.SourceLine(1))
+	require.Equal(t, uint(0), resume.SourceLine(0))
+}
@@ -3,62 +3,100 @@ package gcode
 import "fmt"
 
 // RotateXY rotates work coordinates at the XY plane. Machine coordinates are not affected.
+// Both distance modes and unit changes are supported. The XY position is tracked, so that motion
+// with either X or Y alone can be rotated; rapid motion with X or Y alone while the other axis
+// position is unknown, such as after G53, is kept unchanged.
 type RotateXY struct {
-	parser            *Parser
-	cx                float64
-	cy                float64
-	radians           float64
-	initialModalGroup *ModalGroup
+	parser          *Parser
+	cx              float64
+	cy              float64
+	units           string
+	radians         float64
+	positionTracker *positionTracker
 }
 
 // NewRotateXY creates a new RotateXY.
-// cx and cy are the center coordinates for the rotation, radians is the angle (looking down at XY
-// from Z positive to Z negative).
+// cx and cy are the center coordinates for the rotation, in the units active when RotateXY is
+// created, radians is the angle (looking down at XY from Z positive to Z negative).
 func NewRotateXY(parser *Parser, cx, cy, radians float64) *RotateXY {
 	return &RotateXY{
-		parser:            parser,
-		cx:                cx,
-		cy:                cy,
-		radians:           radians,
-		initialModalGroup: parser.ModalGroup.Copy(),
+		parser:          parser,
+		cx:              cx,
+		cy:              cy,
+		units:           parser.ModalGroup.Units.NormalizedString(),
+		radians:         radians,
+		positionTracker: newPositionTracker(),
 	}
 }
 
-// Next returns the next line of G-code with XY coordinates rotated by the specified angle and center point.
-// It validates that units and distance modes haven't changed since RotateXY was created, as these changes
-// are unsupported during rotation. System blocks are passed through unchanged. Returns nil when the end
-// of input is reached.
-func (r *RotateXY) Next() (*string, error) {
-	eof, block, tokens, err := r.parser.Next()
+// center returns the center for the rotation in the current units.
+func (r *RotateXY) center() (float64, float64) {
+	units := r.parser.ModalGroup.Units.NormalizedString()
+	switch {
+	case units == r.units:
+		return r.cx, r.cy
+	case units == "G20":
+		return r.cx / 25.4, r.cy / 25.4
+	default:
+		return r.cx * 25.4, r.cy * 25.4
+	}
+}
+
+// rotateBlock returns the blocks that replace the given block, or nil if it must be kept unchanged.
+func (r *RotateXY) rotateBlock(block *Block) ([]*Block, error) {
+	// Rotation happens after, as the tracked position is for the original coordinates.
+	start, _, err := r.positionTracker.next(&r.parser.ModalGroup, block)
 	if err != nil {
 		return nil, err
 	}
-	if eof {
-		return nil, nil
-	}
 
-	if !r.parser.ModalGroup.Units.Equal(r.initialModalGroup.Units) {
-		return nil, fmt.Errorf("line %d: %s: unit change unsupported", r.parser.Lexer.Line, block)
+	if block.IsSystem() {
+		return nil, nil
 	}
 
-	if !r.parser.ModalGroup.DistanceMode.Equal(r.initialModalGroup.DistanceMode) {
-		return nil, fmt.Errorf("line %d: %s: distance mode incremental unsupported", r.parser.Lexer.Line, block)
+	motion := r.parser.ModalGroup.Motion
+	switch motion.NormalizedString() {
+	case "G2", "G3":
+		if plane := r.parser.ModalGroup.PlaneSelection; plane.NormalizedString() != "G17" {
+			for _, w := range block.Arguments() {
+				switch w.Letter() {
+				case 'X', 'Y', 'I', 'J', 'K', 'R':
+					return nil, fmt.Errorf("%s: arc rotation unsupported for plane %s", block, plane)
+				}
+			}
+		}
 	}
 
-	var line string
-	if block != nil {
-		if block.IsSystem() {
-			line := tokens.String()
-			return &line, nil
+	incremental := r.parser.ModalGroup.DistanceMode.NormalizedString() == "G91"
+	if motion.NormalizedString() == "G0" && !incremental {
+		// Rapid motion with X or Y alone, while the other axis position is unknown, can't be
+		// rotated. It is kept unchanged, as it positions the tool before the XY position is known,
+		// such as around G53 moves to a maintenance position.
+		x, err := block.GetArgumentNumber('X')
+		if err != nil {
+			return nil, err
 		}
-
-		if err = block.RotateXY(r.cx, r.cy, r.radians); err != nil {
-			return nil, fmt.Errorf("line %d: %s", r.parser.Lexer.Line, err)
+		y, err := block.GetArgumentNumber('Y')
+		if err != nil {
+			return nil, err
+		}
+		if (x != nil && y == nil && start.y == nil) || (x == nil && y != nil && start.x == nil) {
+			return nil, nil
 		}
-		line = block.String() + "\n"
-	} else {
-		line = tokens.String()
 	}
+	cx, cy := r.center()
+	original := block.String()
+	if err := block.rotateXY(motion, incremental, start, cx, cy, r.radians); err != nil {
+		return nil, err
+	}
+	if block.String() == original {
+		return nil, nil
+	}
+	return []*Block{block}, nil
+}
 
-	return &line, nil
+// Next returns the next line of G-code with XY coordinates rotated by the specified angle and center point.
+// Lines without XY motion are passed through unchanged. Returns nil when the end of input is reached.
+func (r *RotateXY) Next() (*string, error) {
+	return blockTransformNext(r.parser, r.rotateBlock)
 }
@@ -0,0 +1,152 @@
+package gcode
+
+import (
+	"math"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func rotateXY(gcode string, cx, cy, degrees float64) (string, error) {
+	return transformString(NewRotateXY(NewParser(strings.NewReader(gcode)), cx, cy, degrees*math.Pi/180))
+}
+
+func TestRotateXY(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		gcode         string
+		cx, cy        float64
+		degrees       float64
+		expected      string
+		errorContains string
+	}{
+		{
+			name:     "linear",
+			gcode:    "(start)\nG0 Z5 ; up\nG0 X1 Y0\nG1 X2 Y0 F100\n",
+			cx:       1,
+			degrees:  90,
+			expected: "(start)\nG0 Z5 ; up\nG0 X1 Y0\nG1X1Y1F100\n",
+		},
+		{
+			name:     "arc IJ form",
+			gcode:    "G0 X1 Y0\nG2 X0 Y-1 I-1 J0\nX-1 Y0 I0 J1\nG3 X0 Y-1 J-1\n",
+			degrees:  90,
+			expected: "G0X0Y1\nG2X1Y0I0J-1\nX0Y-1I-1J0\nG3X1Y0J0I1\n",
+		},
+		{
+			name:     "arc R form",
+			gcode:    "G0 X1 Y0\nG3 X0 Y1 R-1\n",
+			degrees:  180,
+			expected: "G0X-1Y0\nG3X0Y-1R-1\n",
+		},
+		{
+			name:     "single axis",
+			gcode:    "G0 X1 Y0\nG1 X2\nY1\n",
+			degrees:  90,
+			expected: "G0X0Y1\nG1X0Y2\nY2X-1\n",
+		},
+		{
+			name:     "single axis rapid unknown position",
+			gcode:    "G0 X1\nG0 X2 Y0\n",
+			degrees:  90,
+			expected: "G0 X1\nG0X0Y2\n",
+		},
+		{
+			name:          "single axis feed unknown position",
+			gcode:         "G1 X1 F100\n",
+			errorContains: "rotation unsupported for X without Y when Y position is unknown",
+		},
+		{
+			name:     "incremental",
+			gcode:    "G91\nG0 X1\nG2 X1 Y-1 J-1\nG90\nG0 X2 Y0\n",
+			cx:       1,
+			degrees:  90,
+			expected: "G91\nG0X0Y1\nG2X1Y1J0I1\nG90\nG0X1Y1\n",
+		},
+		{
+			name:     "units",
+			gcode:    "G0 X25.4 Y0\nG20\nG0 X2 Y0\n",
+			cx:       25.4,
+			degrees:  90,
+			expected: "G0 X25.4 Y0\nG20\nG0X1Y1\n",
+		},
+		{
+			name:     "machine coordinates",
+			gcode:    "G0 X1 Y0\nG53 G0 X5\nG0 Y1\nG0 X1\n",
+			degrees:  90,
+			expected: "G0X0Y1\nG53 G0 X5\nG0 Y1\nG0X-1Y1\n",
+		},
+		{
+			name:          "machine coordinates feed",
+			gcode:         "G0 X1 Y0\nG53 G0 X5\nG1 Y1 F100\n",
+			degrees:       90,
+			errorContains: "rotation unsupported for Y without X when X position is unknown",
+		},
+		{
+			name:     "probe",
+			gcode:    "G38.2 Z-10 F10\nG92 Z0\nG0 X1 Y0\n",
+			degrees:  90,
+			expected: "G38.2 Z-10 F10\nG92 Z0\nG0X0Y1\n",
+		},
+		{
+			name:          "probe XY",
+			gcode:         "G38.2 X10 F10\n",
+			errorContains: "rotation unsupported for command with X or Y: G38.2",
+		},
+		{
+			name:          "arc plane",
+			gcode:         "G18 G2 X1 Z1 I1\n",
+			errorContains: "arc rotation unsupported for plane G18",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := rotateXY(tc.gcode, tc.cx, tc.cy, tc.degrees)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+func TestRotateXYTestData(t *testing.T) {
+	// Cut segments, which are after the XY position is known.
+	cutSegments := func(t *testing.T, gcode string) []Segment {
+		var segments []Segment
+		for _, segment := range executeMachine(t, NewMachineAtUnknownPosition(MachineParameters{}), gcode) {
+			if segment.Type != SegmentTypeRapid && segment.StartKnown() {
+				segments = append(segments, segment)
+			}
+		}
+		return segments
+	}
+
+	for _, path := range []string{
+		"testdata/5.7_B_Drill.nc",
+		"testdata/5.8_B_B_Edge_Cuts.nc",
+	} {
+		t.Run(path, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			gcode := string(data)
+
+			output, err := rotateXY(gcode, 180, 40, 90)
+			require.NoError(t, err)
+			require.Equal(t, strings.Count(gcode, "\n"), strings.Count(output, "\n"))
+
+			original := cutSegments(t, gcode)
+			rotated := cutSegments(t, output)
+			require.NotEmpty(t, original)
+			require.Len(t, rotated, len(original))
+			for i, segment := range original {
+				require.InDelta(t, 180-(segment.End.Y-40), rotated[i].End.X, 1e-3)
+				require.InDelta(t, 40+(segment.End.X-180), rotated[i].End.Y, 1e-3)
+				require.InDelta(t, segment.End.Z, rotated[i].End.Z, 1e-9)
+			}
+		})
+	}
+}
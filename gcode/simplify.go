@@ -0,0 +1,553 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// simplifyEpsilon is the distance below which positions are considered the same.
+const simplifyEpsilon = 1e-9
+
+// simplifyMinArcPoints is the minimum number of points fitted to an arc.
+const simplifyMinArcPoints = 4
+
+// SimplifyOptions configures Simplify.
+type SimplifyOptions struct {
+	// Maximum distance in millimeters between the original toolpath and the simplified one.
+	Tolerance float64
+	// Fit G2/G3 arcs to runs of G1 points at the XY plane (G17).
+	Arcs bool
+	// Remove G0/G1 moves that do not change the position.
+	RemoveZeroLengthMoves bool
+	// Remove G/M words for modes that are already active, and F words for the current feed rate.
+	RemoveRedundantModalWords bool
+}
+
+// DefaultSimplifyOptions are sensible defaults for SimplifyOptions.
+var DefaultSimplifyOptions = SimplifyOptions{
+	Tolerance:                 0.005,
+	RemoveZeroLengthMoves:     true,
+	RemoveRedundantModalWords: true,
+}
+
+// simplifyDistanceToSegment returns the distance from p to the segment from a to b.
+func simplifyDistanceToSegment(p, a, b Vector) float64 {
+	ab := b.Sub(a)
+	lengthSquared := ab.X*ab.X + ab.Y*ab.Y + ab.Z*ab.Z
+	if lengthSquared < simplifyEpsilon*simplifyEpsilon {
+		return p.Sub(a).Length()
+	}
+	ap := p.Sub(a)
+	t := (ap.X*ab.X + ap.Y*ab.Y + ap.Z*ab.Z) / lengthSquared
+	t = math.Max(0, math.Min(1, t))
+	return p.Sub(a.Add(Vector{X: ab.X * t, Y: ab.Y * t, Z: ab.Z * t})).Length()
+}
+
+// simplifyDouglasPeucker returns the indexes of points kept by the Douglas–Peucker algorithm, so
+// that no dropped point is farther than tolerance from the resulting path. The first and last
+// points are always kept.
+func simplifyDouglasPeucker(points []Vector, tolerance float64) []int {
+	keep := make([]bool, len(points))
+	keep[0] = true
+	keep[len(points)-1] = true
+	stack := [][2]int{{0, len(points) - 1}}
+	for len(stack) > 0 {
+		first, last := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		farthest := -1
+		farthestDistance := tolerance
+		for i := first + 1; i < last; i++ {
+			if distance := simplifyDistanceToSegment(points[i], points[first], points[last]); distance > farthestDistance {
+				farthest = i
+				farthestDistance = distance
+			}
+		}
+		if farthest < 0 {
+			continue
+		}
+		keep[farthest] = true
+		stack = append(stack, [2]int{first, farthest}, [2]int{farthest, last})
+	}
+	indexes := []int{}
+	for i, k := range keep {
+		if k {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// simplifyMotionWord returns the word for given normalized motion command.
+func simplifyMotionWord(motion string) *Word {
+	word, err := NewWordFromString(motion)
+	if err != nil {
+		panic(fmt.Sprintf("bug: invalid motion: %s", motion))
+	}
+	return word
+}
+
+// simplifyArc is an arc at the XY plane fitted to points.
+type simplifyArc struct {
+	center    point2
+	clockwise bool
+}
+
+// simplifyFitArc returns an arc at the XY plane through all points, within tolerance, or nil if
+// there is none. The chords between points must be within tolerance of the arc, which must turn
+// in a single direction for less than a full circle, and must not be within tolerance of a
+// single line.
+//
+//gocyclo:ignore
+func simplifyFitArc(points []Vector, tolerance float64) *simplifyArc {
+	first, middle, last := points[0], points[len(points)/2], points[len(points)-1]
+	for _, p := range points {
+		if math.Abs(p.Z-first.Z) > simplifyEpsilon {
+			return nil
+		}
+	}
+
+	// Circle through the first, middle and last points.
+	a := point2{first.X, first.Y}
+	b := point2{middle.X, middle.Y}
+	c := point2{last.X, last.Y}
+	ab, ac := b.sub(a), c.sub(a)
+	d := 2 * ab.cross(ac)
+	if math.Abs(d) < simplifyEpsilon {
+		return nil
+	}
+	abLengthSquared, acLengthSquared := ab.dot(ab), ac.dot(ac)
+	center := a.add(point2{
+		(ac[1]*abLengthSquared - ab[1]*acLengthSquared) / d,
+		(ab[0]*acLengthSquared - ac[0]*abLengthSquared) / d,
+	})
+	radius := a.distance(center)
+
+	clockwise := d < 0
+	angularTravel := 0.0
+	farthestFromChord := 0.0
+	for i, p := range points {
+		q := point2{p.X, p.Y}
+		if math.Abs(q.distance(center)-radius) > tolerance {
+			return nil
+		}
+		farthestFromChord = math.Max(farthestFromChord, simplifyDistanceToSegment(p, first, last))
+		if i == 0 {
+			continue
+		}
+		previous := point2{points[i-1].X, points[i-1].Y}
+		u, v := previous.sub(center), q.sub(center)
+		angle := math.Atan2(u.cross(v), u.dot(v))
+		if (angle < 0) != clockwise || math.Abs(angle) < simplifyEpsilon {
+			return nil
+		}
+		angularTravel += math.Abs(angle)
+		// Distance between the chord and the arc, plus the distance of the chord ends to the arc.
+		halfChord := previous.distance(q) / 2
+		sagitta := radius - math.Sqrt(math.Max(0, radius*radius-halfChord*halfChord))
+		radialError := math.Max(math.Abs(previous.distance(center)-radius), math.Abs(q.distance(center)-radius))
+		if sagitta+radialError > tolerance {
+			return nil
+		}
+	}
+	if angularTravel >= 2*math.Pi-simplifyEpsilon {
+		return nil
+	}
+	if farthestFromChord <= tolerance {
+		return nil
+	}
+	return &simplifyArc{center: center, clockwise: clockwise}
+}
+
+// Simplify reduces the number of blocks of a program, without changing the toolpath by more than
+// a tolerance. Such programs are faster to stream and keep Grbl's planner buffer full, which is
+// the case for CAM output made of many tiny collinear segments.
+//
+// Consecutive G1 blocks with only X, Y, Z and F words, in absolute distance mode (G90) and units
+// per minute feed rate mode (G94), at the same feed rate, are merged with the Douglas–Peucker
+// algorithm. Optionally, G2/G3 arcs are fitted to them, when the XY plane (G17) is selected.
+// Zero-length moves and redundant modal words can also be removed from all other blocks. Only
+// modes set by previous blocks are considered redundant, as the initial machine state is unknown.
+type Simplify struct {
+	parser          *Parser
+	options         SimplifyOptions
+	positionTracker *positionTracker
+	// Modal state set by the simplified program; nil fields are unknown. Motion differs from the
+	// one of the original program when a run ends with an arc.
+	modalGroup ModalGroup
+	// Current feed rate, or nil if not set.
+	feedRate *float64
+	// Points of the run of G1 blocks being merged, starting with the position before it.
+	runPoints []Vector
+	// Motion and feed rate words at the first block of the run, or nil if not present.
+	runMotionWord *Word
+	runFeedWord   *Word
+	// Feed rate before the run, or nil if not set.
+	runFeedRate *float64
+	// Tolerance in the run units, and whether arcs can be fitted.
+	runTolerance float64
+	runArcs      bool
+	lines        []string
+	eof          bool
+}
+
+// NewSimplify creates a new Simplify.
+func NewSimplify(parser *Parser, options SimplifyOptions) *Simplify {
+	return &Simplify{
+		parser:          parser,
+		options:         options,
+		positionTracker: newPositionTracker(),
+	}
+}
+
+// tolerance returns the tolerance in the current units.
+func (s *Simplify) tolerance() float64 {
+	if s.parser.ModalGroup.Units.NormalizedString() == "G20" {
+		return s.options.Tolerance / 25.4
+	}
+	return s.options.Tolerance
+}
+
+// isRunBlock returns whether block can be merged with other G1 blocks.
+func (s *Simplify) isRunBlock(block *Block, tokens Tokens, motion bool, start, end position) bool {
+	if !block.IsCommand() || !motion || !start.known() || !end.known() {
+		return false
+	}
+	modalGroup := &s.parser.ModalGroup
+	if modalGroup.Motion.NormalizedString() != "G1" ||
+		modalGroup.DistanceMode.NormalizedString() != "G90" ||
+		modalGroup.FeedRateMode.NormalizedString() != "G94" ||
+		(modalGroup.CutterDiameterCompensation != nil && modalGroup.CutterDiameterCompensation.NormalizedString() != "G40") {
+		return false
+	}
+	for _, token := range tokens {
+		if token.Type == TokenTypeComment {
+			return false
+		}
+	}
+	for _, word := range block.Words() {
+		switch word.Letter() {
+		case 'G':
+			if word.NormalizedString() != "G1" {
+				return false
+			}
+		case 'X', 'Y', 'Z', 'F':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// runAxesWords returns the axis words to move from previous to p, skipping axes that do not
+// change.
+func (s *Simplify) runAxesWords(previous, p Vector, axes int) []*Word {
+	words := []*Word{}
+	for i := range axes {
+		if roundArgument(p.axis(i)) != roundArgument(previous.axis(i)) {
+			words = append(words, NewWord(axisLetters[i], roundArgument(p.axis(i))))
+		}
+	}
+	return words
+}
+
+// flushRun simplifies the current run of G1 blocks.
+//
+//gocyclo:ignore
+func (s *Simplify) flushRun() {
+	if len(s.runPoints) == 0 {
+		return
+	}
+	points := s.runPoints
+	s.runPoints = nil
+	tolerance := s.runTolerance
+
+	var motion string
+	if s.modalGroup.Motion != nil {
+		motion = s.modalGroup.Motion.NormalizedString()
+	}
+	// The motion word is kept at the first block when not removing redundant words.
+	keepMotionWord := s.runMotionWord != nil && !s.options.RemoveRedundantModalWords
+	// Words to add to the next block.
+	var pending []*Word
+	if s.runFeedWord != nil &&
+		(!s.options.RemoveRedundantModalWords || s.runFeedRate == nil || *s.runFeedRate != s.runFeedWord.Number()) {
+		pending = append(pending, s.runFeedWord)
+	}
+	blocks := []*Block{}
+	addBlock := func(m string, words ...*Word) {
+		if motion != m || keepMotionWord {
+			motionWord := simplifyMotionWord(m)
+			if m == "G1" && s.runMotionWord != nil {
+				motionWord = s.runMotionWord
+			}
+			pending = append([]*Word{motionWord}, pending...)
+			motion = m
+			keepMotionWord = false
+		}
+		blocks = append(blocks, NewBlockCommand(append(pending, words...)...))
+		pending = nil
+	}
+
+	previous := points[0]
+	addLines := func(linePoints []Vector) {
+		if len(linePoints) < 2 {
+			return
+		}
+		for _, i := range simplifyDouglasPeucker(linePoints, tolerance)[1:] {
+			p := linePoints[i]
+			words := s.runAxesWords(previous, p, 3)
+			if len(words) == 0 {
+				if s.options.RemoveZeroLengthMoves {
+					continue
+				}
+				words = []*Word{NewWord('X', roundArgument(p.X))}
+			}
+			addBlock("G1", words...)
+			previous = p
+		}
+	}
+
+	lineStart := 0
+	for i := 0; i < len(points); {
+		var arc *simplifyArc
+		j := i + simplifyMinArcPoints - 1
+		if s.runArcs && j < len(points) {
+			arc = simplifyFitArc(points[i:j+1], tolerance)
+		}
+		if arc == nil {
+			i++
+			continue
+		}
+		for j+1 < len(points) {
+			extended := simplifyFitArc(points[i:j+2], tolerance)
+			if extended == nil {
+				break
+			}
+			arc = extended
+			j++
+		}
+
+		addLines(points[lineStart : i+1])
+		end := points[j]
+		words := s.runAxesWords(previous, end, 2)
+		if len(words) == 0 {
+			words = []*Word{NewWord('X', roundArgument(end.X))}
+		}
+		words = append(
+			words,
+			NewWord('I', roundArgument(arc.center[0]-roundArgument(previous.X))),
+			NewWord('J', roundArgument(arc.center[1]-roundArgument(previous.Y))),
+		)
+		if arc.clockwise {
+			addBlock("G2", words...)
+		} else {
+			addBlock("G3", words...)
+		}
+		previous = end
+		lineStart = j
+		i = j
+	}
+	addLines(points[lineStart:])
+	if len(pending) > 0 {
+		blocks = append(blocks, NewBlockCommand(pending...))
+	}
+
+	if motion != "" {
+		s.modalGroup.Motion = simplifyMotionWord(motion)
+	}
+	for _, block := range blocks {
+		s.lines = append(s.lines, block.String()+"\n")
+	}
+}
+
+// simplifyBlock returns the words of given block without zero-length moves and redundant modal
+// words, as configured. The original motion word is added when the motion mode set by the
+// simplified program differs and the block relies on it.
+//
+//gocyclo:ignore
+func (s *Simplify) simplifyBlock(block *Block, modalGroup *ModalGroup, motion bool, start, end position) []*Word {
+	words := block.Words()
+
+	if s.options.RemoveZeroLengthMoves && motion && start.known() && end.known() {
+		switch s.parser.ModalGroup.Motion.NormalizedString() {
+		case "G0", "G1":
+			zero := true
+			for _, axis := range [][2]*float64{{start.x, end.x}, {start.y, end.y}, {start.z, end.z}} {
+				if math.Abs(*axis[0]-*axis[1]) > simplifyEpsilon {
+					zero = false
+				}
+			}
+			if zero {
+				nonAxisWords := []*Word{}
+				for _, word := range words {
+					switch word.Letter() {
+					case 'X', 'Y', 'Z':
+					default:
+						nonAxisWords = append(nonAxisWords, word)
+					}
+				}
+				words = nonAxisWords
+			}
+		}
+	}
+
+	if s.options.RemoveRedundantModalWords {
+		inverseTime := modalGroup.FeedRateMode.NormalizedString() == "G93"
+		nonRedundantWords := []*Word{}
+		for _, word := range words {
+			if active := activeModalWord(&s.modalGroup, word); active != nil && active.Equal(word) {
+				continue
+			}
+			if word.Letter() == 'F' && !inverseTime && s.feedRate != nil && *s.feedRate == word.Number() {
+				continue
+			}
+			nonRedundantWords = append(nonRedundantWords, word)
+		}
+		words = nonRedundantWords
+	}
+
+	if s.modalGroup.Motion != nil && !s.modalGroup.Motion.Equal(modalGroup.Motion) {
+		axes := false
+		for _, word := range words {
+			switch word.NormalizedString() {
+			case "G0", "G1", "G2", "G3", "G38.2", "G38.3", "G38.4", "G38.5", "G80":
+				return words
+			}
+			switch word.Letter() {
+			case 'X', 'Y', 'Z':
+				axes = true
+			}
+		}
+		if axes {
+			words = append([]*Word{modalGroup.Motion}, words...)
+		}
+	}
+
+	return words
+}
+
+// updateModalGroup updates the modal state set by the simplified program with given words.
+func (s *Simplify) updateModalGroup(words []*Word) error {
+	if len(words) == 0 {
+		return nil
+	}
+	for _, word := range words {
+		switch word.NormalizedString() {
+		case "M2", "M30":
+			// Program end resets modes to defaults, which may differ from the ones at the start.
+			s.modalGroup = ModalGroup{}
+			return nil
+		}
+	}
+	return s.modalGroup.UpdateFromBlock(NewBlockCommand(words...))
+}
+
+// next processes the next line.
+//
+//gocyclo:ignore
+func (s *Simplify) next() error {
+	lineNumber := s.parser.Lexer.Line
+	modalGroup := s.parser.ModalGroup.Copy()
+	eof, block, tokens, err := s.parser.Next()
+	if err != nil {
+		return err
+	}
+	s.eof = eof
+
+	if block == nil {
+		s.flushRun()
+		if line := tokens.String(); line != "" {
+			s.lines = append(s.lines, line)
+		}
+		return nil
+	}
+
+	start, motion, err := s.positionTracker.next(&s.parser.ModalGroup, block)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", lineNumber, err)
+	}
+	end := s.positionTracker.current(&s.parser.ModalGroup)
+	var feedWord *Word
+	for _, word := range block.Arguments() {
+		if word.Letter() == 'F' {
+			feedWord = word
+		}
+	}
+	defer func() {
+		if feedWord != nil {
+			feedRate := feedWord.Number()
+			s.feedRate = &feedRate
+		}
+	}()
+
+	if s.isRunBlock(block, tokens, motion, start, end) {
+		if len(s.runPoints) > 0 && feedWord != nil && (s.feedRate == nil || *s.feedRate != feedWord.Number()) {
+			s.flushRun()
+		}
+		if len(s.runPoints) == 0 {
+			s.runPoints = []Vector{{X: *start.x, Y: *start.y, Z: *start.z}}
+			s.runMotionWord = nil
+			s.runFeedWord = nil
+			s.runFeedRate = s.feedRate
+			s.runTolerance = s.tolerance()
+			s.runArcs = s.options.Arcs && s.parser.ModalGroup.PlaneSelection.NormalizedString() == "G17"
+			for _, word := range block.Words() {
+				switch word.Letter() {
+				case 'G':
+					s.runMotionWord = word
+				case 'F':
+					s.runFeedWord = word
+				}
+			}
+		} else if s.runFeedWord == nil && feedWord != nil {
+			s.runFeedWord = feedWord
+		}
+		s.runPoints = append(s.runPoints, Vector{X: *end.x, Y: *end.y, Z: *end.z})
+		return nil
+	}
+
+	s.flushRun()
+	words := s.simplifyBlock(block, modalGroup, motion, start, end)
+	if err := s.updateModalGroup(words); err != nil {
+		return fmt.Errorf("line %d: %w", lineNumber, err)
+	}
+	if slices.Equal(words, block.Words()) {
+		s.lines = append(s.lines, tokens.String())
+		return nil
+	}
+	var line string
+	if len(words) > 0 {
+		line = NewBlockCommand(words...).String()
+	}
+	for _, token := range tokens {
+		if token.Type == TokenTypeComment {
+			line += token.String()
+		}
+	}
+	if line != "" {
+		s.lines = append(s.lines, line+"\n")
+	}
+	return nil
+}
+
+// Next returns the next simplified line(s) of G-code, including line endings. Returns nil when
+// the end of input is reached.
+func (s *Simplify) Next() (*string, error) {
+	for len(s.lines) == 0 {
+		if s.eof {
+			s.flushRun()
+			if len(s.lines) == 0 {
+				return nil, nil
+			}
+			break
+		}
+		if err := s.next(); err != nil {
+			return nil, err
+		}
+	}
+	line := strings.Join(s.lines, "")
+	s.lines = nil
+	return &line, nil
+}
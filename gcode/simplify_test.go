@@ -0,0 +1,207 @@
+package gcode
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func simplify(gcode string, options SimplifyOptions) (string, error) {
+	return transformString(NewSimplify(NewParser(strings.NewReader(gcode)), options))
+}
+
+// simplifyQuarterCircle returns G1 blocks for a counterclockwise quarter circle of radius 10
+// centered at the origin, from X10 Y0.
+func simplifyQuarterCircle() string {
+	var gcode string
+	for i := 1; i <= 16; i++ {
+		angle := float64(i) / 16 * math.Pi / 2
+		gcode += fmt.Sprintf("G1 X%.4f Y%.4f\n", 10*math.Cos(angle), 10*math.Sin(angle))
+	}
+	return gcode
+}
+
+func TestSimplify(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		gcode         string
+		options       SimplifyOptions
+		expected      string
+		errorContains string
+	}{
+		{
+			name:     "collinear segments",
+			gcode:    "G0 X0 Y0 Z0\nG1 X1 F100\nG1 X2\nG1 X3\nG0 Z1\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X0 Y0 Z0\nG1F100X3\nG0 Z1\n",
+		},
+		{
+			name:     "near collinear segments within tolerance",
+			gcode:    "G0 X0 Y0 Z0\nG1 X1 Y0.001 F100\nX2 Y0\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X0 Y0 Z0\nG1F100X2\n",
+		},
+		{
+			name:     "corners are kept",
+			gcode:    "G0 X0 Y0 Z0\nG1 X1 F100\nX1 Y1\nX0 Y1\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X0 Y0 Z0\nG1F100X1\nY1\nX0\n",
+		},
+		{
+			name:     "3D segments",
+			gcode:    "G0 X0 Y0 Z0\nG1 X1 Z-1 F100\nX2 Z-2\nX3 Z-2\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X0 Y0 Z0\nG1F100X2Z-2\nX3\n",
+		},
+		{
+			name:     "feed rate changes split runs",
+			gcode:    "G0 X0 Y0 Z0\nG1 X1 F100\nX2 F100\nX3 F200\nX4\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X0 Y0 Z0\nG1F100X2\nF200X4\n",
+		},
+		{
+			name:     "incremental distance mode is kept",
+			gcode:    "G0 X0 Y0 Z0\nG91 G1 X1 F100\nX1\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X0 Y0 Z0\nG91 G1 X1 F100\nX1\n",
+		},
+		{
+			name:     "zero length moves",
+			gcode:    "G0 X1 Y1 Z1\nG0 X1 Y1\nG1 Z1 F100\nM3 G0 X1\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X1 Y1 Z1\nF100\nM3\n",
+		},
+		{
+			name:     "motion mode restored after zero length run",
+			gcode:    "G0 X1 Y1 Z1\nG1 Z1 F100\nX2 (comment)\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G0 X1 Y1 Z1\nF100\nG1X2(comment)\n",
+		},
+		{
+			name:  "zero length moves kept",
+			gcode: "G0 X1 Y1 Z1\nG0 X1 Y1\n",
+			options: SimplifyOptions{
+				Tolerance:                 0.005,
+				RemoveRedundantModalWords: true,
+			},
+			expected: "G0 X1 Y1 Z1\nX1Y1\n",
+		},
+		{
+			name:     "redundant modal words",
+			gcode:    "G21 G90\nG21 G0 X1 (comment)\nM3 S1000\nM3 F10\nF10 G4 P1\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G21 G90\nG0X1(comment)\nM3 S1000\nF10\nG4P1\n",
+		},
+		{
+			name:  "redundant modal words kept",
+			gcode: "G0 X0 Y0 Z0\nG1 X1 F100\nG1 X2 F100\nG1 X3 F100\nG1 Y1 F100\n",
+			options: SimplifyOptions{
+				Tolerance:             0.005,
+				RemoveZeroLengthMoves: true,
+			},
+			expected: "G0 X0 Y0 Z0\nG1F100X3\nY1\n",
+		},
+		{
+			name:     "arc fitting",
+			gcode:    "G0 X10 Y0 Z0\nF100\n" + simplifyQuarterCircle() + "G1 X0 Y20\nG0 Z1\n",
+			options:  SimplifyOptions{Tolerance: 0.02, Arcs: true},
+			expected: "G0 X10 Y0 Z0\nF100\nG3X0Y10I-9.9999J0.0001\nG1Y20\nG0 Z1\n",
+		},
+		{
+			name:     "arc fitting disabled",
+			gcode:    "G0 X10 Y0 Z0\nF100\n" + simplifyQuarterCircle(),
+			options:  SimplifyOptions{Tolerance: 0.01},
+			expected: "G0 X10 Y0 Z0\nF100\nG1X9.9518Y0.9802\nX9.8079Y1.9509\nX9.5694Y2.9028\nX9.2388Y3.8268\nX8.8192Y4.714\nX8.3147Y5.5557\nX7.7301Y6.3439\nX7.0711Y7.0711\nX6.3439Y7.7301\nX5.5557Y8.3147\nX4.714Y8.8192\nX3.8268Y9.2388\nX2.9028Y9.5694\nX1.9509Y9.8079\nX0.9802Y9.9518\nX0Y10\n",
+		},
+		{
+			name:     "motion mode restored after arc",
+			gcode:    "G0 X10 Y0 Z0\nF100\n" + simplifyQuarterCircle() + "X1 Y1 (comment)\n",
+			options:  SimplifyOptions{Tolerance: 0.02, Arcs: true},
+			expected: "G0 X10 Y0 Z0\nF100\nG3X0Y10I-9.9999J0.0001\nG1X1Y1(comment)\n",
+		},
+		{
+			name:     "inches",
+			gcode:    "G20 G0 X0 Y0 Z0\nG1 X1 Y0.0001 F10\nX2 Y0\n",
+			options:  DefaultSimplifyOptions,
+			expected: "G20 G0 X0 Y0 Z0\nG1F10X2\n",
+		},
+		{
+			name:          "syntax error",
+			gcode:         "G1 X\n",
+			options:       DefaultSimplifyOptions,
+			errorContains: "line 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := simplify(tc.gcode, tc.options)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, output)
+		})
+	}
+}
+
+// simplifyToolpath returns points along the toolpath of gcode, with arcs within tolerance.
+func simplifyToolpath(t *testing.T, gcode string, tolerance float64) []Vector {
+	parser := NewParser(strings.NewReader(gcode))
+	machine := NewMachine(MachineParameters{}, Vector{})
+	points := []Vector{}
+	for {
+		eof, _, segments, err := machine.Next(parser)
+		require.NoError(t, err)
+		for _, segment := range segments {
+			if len(points) == 0 {
+				points = append(points, segment.Start)
+			}
+			points = append(points, segment.Points(tolerance)...)
+		}
+		if eof {
+			return points
+		}
+	}
+}
+
+// simplifyMaxDistance returns the maximum distance from points to the path through other.
+func simplifyMaxDistance(points, other []Vector) float64 {
+	maxDistance := 0.0
+	for _, p := range points {
+		distance := math.Inf(1)
+		for i := 1; i < len(other); i++ {
+			distance = math.Min(distance, simplifyDistanceToSegment(p, other[i-1], other[i]))
+		}
+		maxDistance = math.Max(maxDistance, distance)
+	}
+	return maxDistance
+}
+
+func TestSimplifyTestData(t *testing.T) {
+	paths, err := filepath.Glob("testdata/*.nc")
+	require.NoError(t, err)
+	for _, path := range paths {
+		gcode, err := os.ReadFile(path)
+		require.NoError(t, err)
+		original := simplifyToolpath(t, string(gcode), 0.0001)
+		for _, arcs := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s arcs=%v", filepath.Base(path), arcs), func(t *testing.T) {
+				options := DefaultSimplifyOptions
+				options.Arcs = arcs
+				output, err := simplify(string(gcode), options)
+				require.NoError(t, err)
+				require.LessOrEqual(t, strings.Count(output, "\n"), strings.Count(string(gcode), "\n"))
+				simplified := simplifyToolpath(t, output, 0.0001)
+				// Tolerance, plus rounding to 4 decimal places.
+				maxDistance := options.Tolerance + 0.0002
+				require.Less(t, simplifyMaxDistance(original, simplified), maxDistance)
+				require.Less(t, simplifyMaxDistance(simplified, original), maxDistance)
+			})
+		}
+	}
+}
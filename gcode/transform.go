@@ -0,0 +1,94 @@
+package gcode
+
+import (
+	"fmt"
+	"io"
+)
+
+// Transform is a streaming G-Code transformation, such as RotateXY or LevelZ.
+type Transform interface {
+	// Next returns the next transformed line(s) of G-code, including line endings. Returns nil
+	// when the end of input is reached.
+	Next() (*string, error)
+}
+
+// blockTransformNext implements Transform.Next for transforms that replace each parsed block by
+// other blocks. transformBlock must return nil when the block is to be kept unchanged, in which case
+// the original line is returned, including comments and spacing.
+func blockTransformNext(parser *Parser, transformBlock func(*Block) ([]*Block, error)) (*string, error) {
+	lineNumber := parser.Lexer.Line
+	eof, block, tokens, err := parser.Next()
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		if eof && len(tokens) <= 1 {
+			return nil, nil
+		}
+		line := tokens.String()
+		return &line, nil
+	}
+
+	blocks, err := transformBlock(block)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+	}
+	if blocks == nil {
+		line := tokens.String()
+		return &line, nil
+	}
+
+	if len(blocks) > 0 && !blocksHaveComments(blocks) {
+		blocks[0].SetComments(block.LeadingComments(), blocks[0].TrailingComments())
+		last := blocks[len(blocks)-1]
+		last.SetComments(last.LeadingComments(), block.TrailingComments())
+	}
+
+	var line string
+	for _, b := range blocks {
+		line += b.String() + "\n"
+	}
+	return &line, nil
+}
+
+// blocksHaveComments returns true if any of the blocks has comments.
+func blocksHaveComments(blocks []*Block) bool {
+	for _, b := range blocks {
+		if len(b.Comments()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TransformReader is an io.Reader for the G-code produced by a Transform, so it can be streamed.
+type TransformReader struct {
+	transform Transform
+	buf       string
+	eof       bool
+}
+
+// NewTransformReader creates a new TransformReader.
+func NewTransformReader(transform Transform) *TransformReader {
+	return &TransformReader{transform: transform}
+}
+
+func (r *TransformReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		line, err := r.transform.Next()
+		if err != nil {
+			return 0, err
+		}
+		if line == nil {
+			r.eof = true
+			continue
+		}
+		r.buf = *line
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
@@ -0,0 +1,16 @@
+package gcode
+
+// transformString returns all the output of a Transform.
+func transformString(transform Transform) (string, error) {
+	var output string
+	for {
+		line, err := transform.Next()
+		if err != nil {
+			return "", err
+		}
+		if line == nil {
+			return output, nil
+		}
+		output += *line
+	}
+}
@@ -35,6 +35,10 @@ const GrblCommandEnableSleepMode = "$SLP"
 
 var ErrInvalidMessage = errors.New("invalid Grbl message")
 
+// defaultSerialRxBufferBytes is the serial RX buffer size of Grbl for AVR, used when the compile
+// time options are unknown.
+const defaultSerialRxBufferBytes = 128
+
 type Grbl struct {
 	grblMu                     sync.Mutex
 	portWriteMu                sync.Mutex
@@ -43,10 +47,16 @@ type Grbl struct {
 	workCoordinateOffset       *WorkCoordinateOffset
 	overrideValues             *OverrideValues
 	gcodeParameters            *GcodeParameters
+	gcodeState                 *GcodeStatePushMessage
 	accessoryState             *AccessoryState
+	compileTimeOptions         *CompileTimeOptionsPushMessage
+	statusReport               *StatusReportPushMessage
+	settings                   Settings
+	welcomeCh                  chan struct{}
 	receiveCtxCancel           context.CancelFunc
 	pushMessageCh              chan PushMessage
 	responseMessageCh          chan *ResponseMessage
+	streamAlarmCh              chan *AlarmPushMessage
 	messageReceiverWorkerErrCh chan error
 }
 
@@ -94,12 +104,19 @@ func (g *Grbl) receiveMessage(ctx context.Context) (PushMessage, *ResponseMessag
 			g.workCoordinateOffset = nil
 			g.overrideValues = nil
 			g.gcodeParameters = &GcodeParameters{}
+			g.gcodeState = nil
 			g.accessoryState = nil
+			g.statusReport = nil
+			if g.welcomeCh != nil {
+				close(g.welcomeCh)
+				g.welcomeCh = nil
+			}
 			g.grblMu.Unlock()
 		}
 
 		if statusReportPushMessage, ok := pushMessage.(*StatusReportPushMessage); ok {
 			g.grblMu.Lock()
+			g.statusReport = statusReportPushMessage
 			if statusReportPushMessage.WorkCoordinateOffset != nil {
 				g.workCoordinateOffset = statusReportPushMessage.WorkCoordinateOffset
 			}
@@ -115,6 +132,35 @@ func (g *Grbl) receiveMessage(ctx context.Context) (PushMessage, *ResponseMessag
 			g.gcodeParameters.Update(gcodeParamPushMessage)
 			g.grblMu.Unlock()
 		}
+
+		if gcodeStatePushMessage, ok := pushMessage.(*GcodeStatePushMessage); ok {
+			g.grblMu.Lock()
+			g.gcodeState = gcodeStatePushMessage
+			g.grblMu.Unlock()
+		}
+
+		if settingPushMessage, ok := pushMessage.(*SettingPushMessage); ok {
+			g.grblMu.Lock()
+			g.settings.Update(settingPushMessage)
+			g.grblMu.Unlock()
+		}
+
+		if compileTimeOptionsPushMessage, ok := pushMessage.(*CompileTimeOptionsPushMessage); ok {
+			g.grblMu.Lock()
+			g.compileTimeOptions = compileTimeOptionsPushMessage
+			g.grblMu.Unlock()
+		}
+
+		if alarmPushMessage, ok := pushMessage.(*AlarmPushMessage); ok {
+			g.grblMu.Lock()
+			if g.streamAlarmCh != nil {
+				select {
+				case g.streamAlarmCh <- alarmPushMessage:
+				default:
+				}
+			}
+			g.grblMu.Unlock()
+		}
 		return pushMessage, nil, nil
 	}
 
@@ -191,11 +237,12 @@ func (g *Grbl) waitForWelcomeMessage(ctx context.Context) error {
 	}
 }
 
-// Connect opens the serial connection and waits for Grbl welcome push message before returning.
-// On success, it returns a channel where push messages received from Grbl are sent to: this channel
-// must be read from in a loop to process the push messages. On read errors, the push messages
-// channel will be closed, Disconnect() must be called in this case, and it'll return the error.
-// Disconnect() must be called when the connection isn't needed anymore.
+// Connect opens the serial connection and waits for Grbl welcome push message, then queries build
+// info ($I) for the compile time options, before returning. On success, it returns a channel where
+// push messages received from Grbl are sent to: this channel must be read from in a loop to process
+// the push messages. On read errors, the push messages channel will be closed, Disconnect() must be
+// called in this case, and it'll return the error. Disconnect() must be called when the connection
+// isn't needed anymore.
 //
 //gocyclo:ignore
 func (g *Grbl) Connect(ctx context.Context) (<-chan PushMessage, error) {
@@ -229,7 +276,11 @@ func (g *Grbl) Connect(ctx context.Context) (<-chan PushMessage, error) {
 	g.workCoordinateOffset = nil
 	g.overrideValues = nil
 	g.gcodeParameters = &GcodeParameters{}
+	g.gcodeState = nil
 	g.accessoryState = nil
+	g.compileTimeOptions = nil
+	g.statusReport = nil
+	g.settings = Settings{}
 
 	var receiveCtx context.Context
 	receiveCtx, g.receiveCtxCancel = context.WithCancel(ctx)
@@ -244,6 +295,12 @@ func (g *Grbl) Connect(ctx context.Context) (<-chan PushMessage, error) {
 		return nil, errors.Join(err, g.Disconnect(ctx))
 	}
 
+	buildInfoCtx, buildInfoCtxCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer buildInfoCtxCancel()
+	if err := g.SendGrblCommandViewBuildInfo(buildInfoCtx); err != nil {
+		logger.Warn("Failed to query build info", "err", err)
+	}
+
 	return g.pushMessageCh, nil
 }
 
@@ -271,6 +328,43 @@ func (g *Grbl) GetLastGcodeParameters() *GcodeParameters {
 	return g.gcodeParameters
 }
 
+// GetLastGcodeState returns the newest value received via a push message gcode state ($G).
+// Returns nil if no previous message was received.
+func (g *Grbl) GetLastGcodeState() *GcodeStatePushMessage {
+	g.grblMu.Lock()
+	defer g.grblMu.Unlock()
+	return g.gcodeState
+}
+
+// GetLastCompileTimeOptions returns the newest value received via a push message compile time
+// options ($I). Returns nil if no previous message was received. It is kept across soft resets, as
+// compile time options don't change.
+func (g *Grbl) GetLastCompileTimeOptions() *CompileTimeOptionsPushMessage {
+	g.grblMu.Lock()
+	defer g.grblMu.Unlock()
+	return g.compileTimeOptions
+}
+
+// GetLastSettings returns a copy of the settings received via setting push messages ($$). They are
+// kept across soft resets, as settings are stored in EEPROM.
+func (g *Grbl) GetLastSettings() Settings {
+	g.grblMu.Lock()
+	defer g.grblMu.Unlock()
+	settings := Settings{}
+	for key, value := range g.settings {
+		settings[key] = value
+	}
+	return settings
+}
+
+// GetLastStatusReport returns the newest status report push message.
+// Returns nil if no previous message was received.
+func (g *Grbl) GetLastStatusReport() *StatusReportPushMessage {
+	g.grblMu.Lock()
+	defer g.grblMu.Unlock()
+	return g.statusReport
+}
+
 // GetLastAccessoryState returns the newest value received via a push message status report.
 // Returns nil if no previous message was received.
 func (g *Grbl) GetLastAccessoryState() *AccessoryState {
@@ -297,6 +391,25 @@ func (g *Grbl) SendRealTimeCommand(cmd RealTimeCommand) error {
 	return nil
 }
 
+// SoftReset issues a soft reset real time command, and waits for the welcome message.
+func (g *Grbl) SoftReset(ctx context.Context) error {
+	welcomeCh := make(chan struct{})
+	g.grblMu.Lock()
+	g.welcomeCh = welcomeCh
+	g.grblMu.Unlock()
+
+	if err := g.SendRealTimeCommand(RealTimeCommandSoftReset); err != nil {
+		return err
+	}
+
+	select {
+	case <-welcomeCh:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("soft reset: %w", ctx.Err())
+	}
+}
+
 // If a previous command context is cancelled / deadline was exceeded before the response
 // message is processed, it'll still be in the buffer. This ensures the buffer is empty before
 // we send the next command, ensuring the response message we get, is related to this command,
@@ -363,7 +476,9 @@ func (g *Grbl) SendCommand(ctx context.Context, command string) error {
 	return responseMessage.Error()
 }
 
-func (g *Grbl) StreamProgram(ctx context.Context, programReader io.Reader) error {
+// StreamProgram streams the program from programReader to Grbl, configured by options. Error
+// responses from Grbl are returned as *ProgramLineError, and alarms as *StreamAlarmError.
+func (g *Grbl) StreamProgram(ctx context.Context, programReader io.Reader, options StreamOptions) error {
 	g.portWriteMu.Lock()
 	defer g.portWriteMu.Unlock()
 
@@ -371,9 +486,28 @@ func (g *Grbl) StreamProgram(ctx context.Context, programReader io.Reader) error
 		return err
 	}
 
-	// TODO call $I to check [OPT: response to fetch serial RX buffer bytes
-	const maxSerialRxBufferBytes = 128
-	return NewProgramStreamer(g.port, g.responseMessageCh, maxSerialRxBufferBytes).Run(ctx, programReader)
+	maxSerialRxBufferBytes := defaultSerialRxBufferBytes
+	if compileTimeOptions := g.GetLastCompileTimeOptions(); compileTimeOptions != nil && compileTimeOptions.SerialRxBufferBytes > 0 {
+		maxSerialRxBufferBytes = int(compileTimeOptions.SerialRxBufferBytes)
+	} else {
+		log.MustLogger(ctx).Warn("Compile time options unknown, using default serial RX buffer size", "bytes", maxSerialRxBufferBytes)
+	}
+
+	// On alarms, Grbl resets and flushes its serial RX buffer: response messages for sent lines
+	// never arrive.
+	alarmCh := make(chan *AlarmPushMessage, 1)
+	g.grblMu.Lock()
+	g.streamAlarmCh = alarmCh
+	g.grblMu.Unlock()
+	defer func() {
+		g.grblMu.Lock()
+		g.streamAlarmCh = nil
+		g.grblMu.Unlock()
+	}()
+
+	return NewProgramStreamer(
+		g.port, g.responseMessageCh, alarmCh, maxSerialRxBufferBytes, g.GetLastOverrideValues, options,
+	).Run(ctx, programReader)
 }
 
 // Disconnect will stop all goroutines and close the serial port.
@@ -397,6 +531,7 @@ func (g *Grbl) Disconnect(ctx context.Context) (err error) {
 	g.workCoordinateOffset = nil
 	g.overrideValues = nil
 	g.gcodeParameters = &GcodeParameters{}
+	g.gcodeState = nil
 	g.accessoryState = nil
 	g.receiveCtxCancel = nil
 	g.pushMessageCh = nil
@@ -2,6 +2,7 @@ package grbl
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 )
 
@@ -151,3 +152,50 @@ func (h *HeightMap) GetInterpolatedValue(x, y float64) *float64 {
 
 	return &z
 }
+
+type heightMapJSON struct {
+	X0          float64     `json:"x0"`
+	Y0          float64     `json:"y0"`
+	X1          float64     `json:"x1"`
+	Y1          float64     `json:"y1"`
+	MaxDistance float64     `json:"max_distance"`
+	Z           [][]float64 `json:"z"`
+}
+
+// MarshalJSON implements json.Marshaler, enabling a probed height map to be saved.
+func (h *HeightMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&heightMapJSON{
+		X0:          h.x0,
+		Y0:          h.y0,
+		X1:          h.x1,
+		Y1:          h.y1,
+		MaxDistance: h.maxDistance,
+		Z:           h.z,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, enabling a previously saved height map to be loaded.
+func (h *HeightMap) UnmarshalJSON(data []byte) error {
+	var hj heightMapJSON
+	if err := json.Unmarshal(data, &hj); err != nil {
+		return err
+	}
+
+	nh, err := NewHeightMap(hj.X0, hj.Y0, hj.X1, hj.Y1, hj.MaxDistance)
+	if err != nil {
+		return err
+	}
+
+	if len(hj.Z) != len(nh.z) {
+		return fmt.Errorf("expected %d x probe points, got %d", len(nh.z), len(hj.Z))
+	}
+	for i := range hj.Z {
+		if len(hj.Z[i]) != len(nh.z[i]) {
+			return fmt.Errorf("expected %d y probe points at x index %d, got %d", len(nh.z[i]), i, len(hj.Z[i]))
+		}
+	}
+	nh.z = hj.Z
+
+	*h = *nh
+	return nil
+}
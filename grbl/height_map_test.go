@@ -2,6 +2,7 @@ package grbl
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"slices"
 	"testing"
@@ -94,3 +95,25 @@ func TestHeightMap(t *testing.T) {
 	}
 	require.Equal(t, expectedProbeCount, probeCount)
 }
+
+func TestHeightMapJSON(t *testing.T) {
+	hm, err := NewHeightMap(0, 0, 2, 2, 1)
+	require.NoError(t, err)
+	err = hm.Probe(t.Context(), func(ctx context.Context, x, y float64) (float64, error) {
+		return x*0.1 - y*0.2, nil
+	})
+	require.NoError(t, err)
+
+	data, err := json.Marshal(hm)
+	require.NoError(t, err)
+
+	var loaded HeightMap
+	require.NoError(t, json.Unmarshal(data, &loaded))
+	require.Equal(t, hm, &loaded)
+
+	t.Run("mismatched probe points", func(t *testing.T) {
+		var hm HeightMap
+		err := json.Unmarshal([]byte(`{"x0":0,"y0":0,"x1":2,"y1":2,"max_distance":1,"z":[[0,0,0]]}`), &hm)
+		require.ErrorContains(t, err, "expected 3 x probe points, got 1")
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/fornellas/slogxt/log"
 
@@ -14,25 +15,175 @@ import (
 
 var ErrEEPROMCommandNotSupported = errors.New("EEPROM related commands can not be streamed")
 
+var ErrToolChangeNotSupported = errors.New("tool change (M6) requires a tool change function")
+
+// ResponseErrorPolicy is what ProgramStreamer does when Grbl responds with an error to a line.
+type ResponseErrorPolicy int
+
+const (
+	// Send a feed hold, wait for the response messages of sent lines, up to abortDrainTimeout, and
+	// return the error.
+	ResponseErrorPolicyAbort ResponseErrorPolicy = iota
+	// Send a feed hold and wait for operator confirmation, then resume with a cycle start.
+	ResponseErrorPolicyPause
+	// Log the error and keep streaming.
+	ResponseErrorPolicyContinue
+)
+
+var responseErrorPolicyNames = map[ResponseErrorPolicy]string{
+	ResponseErrorPolicyAbort:    "abort",
+	ResponseErrorPolicyPause:    "pause",
+	ResponseErrorPolicyContinue: "continue",
+}
+
+func (p ResponseErrorPolicy) String() string {
+	name, ok := responseErrorPolicyNames[p]
+	if !ok {
+		return fmt.Sprintf("unknown response error policy (%d)", int(p))
+	}
+	return name
+}
+
+// NewResponseErrorPolicy returns the ResponseErrorPolicy for given name ("abort", "pause" or
+// "continue").
+func NewResponseErrorPolicy(name string) (ResponseErrorPolicy, error) {
+	for policy, policyName := range responseErrorPolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown response error policy: %#v", name)
+}
+
+// ProgramLineError is an error response message from Grbl to a streamed program line. It wraps
+// ErrResponseMessage.
+type ProgramLineError struct {
+	// Line number at the program, starting at 1, as mapped by StreamOptions SourceLineFn.
+	Line uint
+	// Block is the line as sent to Grbl.
+	Block string
+	Err   error
+}
+
+func (e *ProgramLineError) Error() string {
+	return fmt.Sprintf("line %d: %s: %s", e.Line, e.Block, e.Err)
+}
+
+func (e *ProgramLineError) Unwrap() error {
+	return e.Err
+}
+
+// StreamAlarmError is returned when Grbl raises an alarm while streaming a program.
+type StreamAlarmError struct {
+	Alarm *AlarmPushMessage
+}
+
+func (e *StreamAlarmError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Alarm, e.Alarm.Error())
+}
+
+// sentLine is a line sent to Grbl, pending its response message.
+type sentLine struct {
+	bytes int
+	// Line number at the streamed program.
+	line  uint
+	block string
+	// Sent with SendCommand, so not part of the program progress.
+	command bool
+}
+
+// abortDrainTimeout is how long to wait for the response messages of sent lines when aborting: in
+// feed hold, Grbl stops acknowledging lines once its planner buffer is full.
+var abortDrainTimeout = 5 * time.Second
+
+// syncCommand is a command whose response message is only received after all previous motion
+// is complete.
+const syncCommand = "G4 P0.01"
+
+// StreamOptions configures program streaming.
+type StreamOptions struct {
+	// ToolChangeFn is called for tool changes (M6), and may be nil if programs have none.
+	ToolChangeFn ToolChangeFn
+	// ErrorPolicy sets what to do when Grbl responds with an error to a line.
+	ErrorPolicy ResponseErrorPolicy
+	// ConfirmFn is called to wait for the operator with ResponseErrorPolicyPause, and must return
+	// once the operator confirms, or an error to abort. May be nil with other policies.
+	ConfirmFn func(ctx context.Context, message string) error
+	// Estimate of the program, to report progress percents and ETA. May be nil.
+	Estimate *ProgramEstimate
+	// ProgressFn is called whenever lines are sent or acknowledged, and may be nil. It is called
+	// from the streaming goroutine, so it must not block.
+	ProgressFn func(progress StreamProgress)
+	// Controller to pause, resume or abort the stream. May be nil.
+	Controller *StreamController
+	// SourceLineFn maps lines of the streamed program to the lines of the program it was generated
+	// from, such as gcode.Resume SourceLine, so that errors, progress and aborts report the latter.
+	// The estimate is for the streamed program. May be nil.
+	SourceLineFn func(line uint) uint
+}
+
 type ProgramStreamer struct {
 	port                   io.Writer
 	responseMessageCh      chan *ResponseMessage
+	alarmCh                <-chan *AlarmPushMessage
 	maxSerialRxBufferBytes int
+	overrideValuesFn       func() *OverrideValues
+	options                StreamOptions
 
 	availableBufferBytes int
-	sentChunkBytes       []int
+	sentLines            []sentLine
+	// Line number at the streamed program of the block being streamed.
+	line uint
+	// Line number at the streamed program of the last acknowledged line.
+	acknowledgedLine uint
+	tool             *float64
+	aborting         bool
+	start            time.Time
+	progress         StreamProgress
 }
 
+// NewProgramStreamer creates a new ProgramStreamer. Alarms received from alarmCh fail the stream
+// with StreamAlarmError, as response messages for sent lines stop arriving; it may be nil.
+// overrideValuesFn returns the current override values, used to compute the progress ETA, and may
+// be nil.
 func NewProgramStreamer(
 	port io.Writer,
 	responseMessageCh chan *ResponseMessage,
+	alarmCh <-chan *AlarmPushMessage,
 	maxSerialRxBufferBytes int,
+	overrideValuesFn func() *OverrideValues,
+	options StreamOptions,
 ) *ProgramStreamer {
 	return &ProgramStreamer{
 		port:                   port,
 		responseMessageCh:      responseMessageCh,
+		alarmCh:                alarmCh,
 		maxSerialRxBufferBytes: maxSerialRxBufferBytes,
+		overrideValuesFn:       overrideValuesFn,
+		options:                options,
+	}
+}
+
+// sourceLine maps a line of the streamed program with StreamOptions SourceLineFn.
+func (s *ProgramStreamer) sourceLine(line uint) uint {
+	if s.options.SourceLineFn == nil {
+		return line
+	}
+	return s.options.SourceLineFn(line)
+}
+
+// reportProgress calls the progress function, if set.
+func (s *ProgramStreamer) reportProgress() {
+	if s.options.ProgressFn == nil {
+		return
 	}
+	s.progress.Elapsed = time.Since(s.start)
+	var overrideValues *OverrideValues
+	if s.overrideValuesFn != nil {
+		overrideValues = s.overrideValuesFn()
+	}
+	s.progress.update(s.acknowledgedLine, overrideValues)
+	s.options.ProgressFn(s.progress)
 }
 
 func (s *ProgramStreamer) writeChunk(chunk []byte) error {
@@ -46,62 +197,252 @@ func (s *ProgramStreamer) writeChunk(chunk []byte) error {
 	return nil
 }
 
-func (s *ProgramStreamer) waitForResponseMessage(ctx context.Context, warnIfEmpty bool) error {
+func (s *ProgramStreamer) waitForResponseMessage(ctx context.Context, warnIfEmpty bool) (*ResponseMessage, sentLine, error) {
 	logger := log.MustLogger(ctx)
+	var responseMessage *ResponseMessage
 	var ok bool
 	select {
-	case _, ok = <-s.responseMessageCh:
+	case responseMessage, ok = <-s.responseMessageCh:
 		if !ok {
-			return fmt.Errorf("stream program: response message channel is closed")
+			return nil, sentLine{}, fmt.Errorf("stream program: response message channel is closed")
 		}
+	case alarm := <-s.alarmCh:
+		return nil, sentLine{}, fmt.Errorf("stream program: %w", &StreamAlarmError{Alarm: alarm})
 	case <-ctx.Done():
-		return fmt.Errorf("stream program: %w", ctx.Err())
+		return nil, sentLine{}, fmt.Errorf("stream program: %w", ctx.Err())
+	}
+	if len(s.sentLines) == 0 {
+		return nil, sentLine{}, fmt.Errorf("stream program: unexpected response message: %s", responseMessage)
 	}
-	s.availableBufferBytes += s.sentChunkBytes[0]
+	sent := s.sentLines[0]
+	s.availableBufferBytes += sent.bytes
 	if s.availableBufferBytes == s.maxSerialRxBufferBytes && warnIfEmpty {
 		logger.Warn("Grbl serial RX buffer empty")
 	}
-	s.sentChunkBytes = s.sentChunkBytes[1:]
-	return nil
+	s.sentLines = s.sentLines[1:]
+	if sent.command {
+		return responseMessage, sent, nil
+	}
+	s.progress.AcknowledgedLines++
+	s.progress.AcknowledgedBytes += sent.bytes
+	s.acknowledgedLine = sent.line
+	s.progress.Line = s.sourceLine(sent.line)
+	if s.options.Controller != nil {
+		s.options.Controller.queued(s.progress.Line)
+	}
+	s.reportProgress()
+	return responseMessage, sent, nil
 }
 
-func (s *ProgramStreamer) writeLine(ctx context.Context, line []byte) error {
-	sent := 0
-	for sent < len(line) {
-		for s.availableBufferBytes == 0 {
-			if err := s.waitForResponseMessage(ctx, true); err != nil {
-				return err
-			}
+// processResponseMessage waits for the response message of the oldest sent line, and applies the
+// error policy if it is an error.
+func (s *ProgramStreamer) processResponseMessage(ctx context.Context, warnIfEmpty bool) error {
+	responseMessage, sent, err := s.waitForResponseMessage(ctx, warnIfEmpty)
+	if err != nil {
+		return err
+	}
+	err = responseMessage.Error()
+	if err == nil {
+		return nil
+	}
+	lineErr := &ProgramLineError{Line: s.sourceLine(sent.line), Block: sent.block, Err: err}
+
+	logger := log.MustLogger(ctx)
+	if s.aborting {
+		logger.Error("Error response while aborting", "err", lineErr)
+		return nil
+	}
+	switch s.options.ErrorPolicy {
+	case ResponseErrorPolicyContinue:
+		logger.Error("Error response, continuing", "err", lineErr)
+		return nil
+	case ResponseErrorPolicyPause:
+		logger.Error("Error response, pausing", "err", lineErr)
+		if err := s.writeChunk([]byte{byte(RealTimeCommandFeedHold)}); err != nil {
+			return errors.Join(lineErr, err)
+		}
+		if err := s.options.ConfirmFn(ctx, fmt.Sprintf("Grbl error at %s. Continue?", lineErr)); err != nil {
+			return s.abort(ctx, errors.Join(lineErr, err))
 		}
+		return s.writeChunk([]byte{byte(RealTimeCommandCycleStartResume)})
+	default:
+		logger.Error("Error response, aborting", "err", lineErr)
+		return s.abort(ctx, lineErr)
+	}
+}
 
-		end := min(sent+s.availableBufferBytes, len(line))
-		chunk := line[sent:end]
+// abort sends a feed hold, waits for the response messages of sent lines, up to
+// abortDrainTimeout, and returns err. Lines left unacknowledged remain at Grbl, until a soft reset.
+func (s *ProgramStreamer) abort(ctx context.Context, err error) error {
+	s.aborting = true
+	if writeErr := s.writeChunk([]byte{byte(RealTimeCommandFeedHold)}); writeErr != nil {
+		return errors.Join(err, writeErr)
+	}
+	drainCtx, cancel := context.WithTimeout(ctx, abortDrainTimeout)
+	defer cancel()
+	if drainErr := s.drain(drainCtx); drainErr != nil {
+		return errors.Join(err, fmt.Errorf("%d line(s) not acknowledged, soft reset required: %w", len(s.sentLines), drainErr))
+	}
+	return err
+}
 
-		err := s.writeChunk(chunk)
-		if err != nil {
+// drain waits for the response messages of all sent lines.
+func (s *ProgramStreamer) drain(ctx context.Context) error {
+	for len(s.sentLines) > 0 {
+		if err := s.processResponseMessage(ctx, false); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		sent += len(chunk)
-		s.availableBufferBytes -= len(chunk)
+// writeLine waits for room at Grbl's serial RX buffer for the whole line, and writes it: lines are
+// never split, so that Grbl's character counting matches sentLines.
+func (s *ProgramStreamer) writeLine(ctx context.Context, line []byte, command bool) error {
+	if len(line) > s.maxSerialRxBufferBytes {
+		return fmt.Errorf(
+			"stream program: line %d: %#v is %d bytes long, longer than Grbl's serial RX buffer of %d bytes",
+			s.sourceLine(s.line), strings.TrimSuffix(string(line), "\n"), len(line), s.maxSerialRxBufferBytes,
+		)
 	}
+	for s.availableBufferBytes < len(line) {
+		if err := s.processResponseMessage(ctx, true); err != nil {
+			return err
+		}
+	}
+	if err := s.writeChunk(line); err != nil {
+		return err
+	}
+	s.availableBufferBytes -= len(line)
 
-	s.sentChunkBytes = append(s.sentChunkBytes, len(line))
+	s.sentLines = append(s.sentLines, sentLine{
+		bytes:   len(line),
+		line:    s.line,
+		block:   strings.TrimSuffix(string(line), "\n"),
+		command: command,
+	})
+	if command {
+		return nil
+	}
+	s.progress.SentLines++
+	s.progress.SentBytes += len(line)
+	s.reportProgress()
 	return nil
 }
 
+// SendCommand sends a command once all previously sent lines were acknowledged, and waits for its
+// response message. It implements CommandSender, so that a ToolChangeFn can send commands while the
+// program is being streamed.
+func (s *ProgramStreamer) SendCommand(ctx context.Context, command string) error {
+	if strings.Contains(command, "\n") {
+		return fmt.Errorf("command must be single line string: %#v", command)
+	}
+	if err := s.drain(ctx); err != nil {
+		return err
+	}
+	if err := s.writeLine(ctx, []byte(command+"\n"), true); err != nil {
+		return err
+	}
+	log.MustLogger(ctx).Debug("Sent", "line", command)
+	responseMessage, _, err := s.waitForResponseMessage(ctx, false)
+	if err != nil {
+		return err
+	}
+	return responseMessage.Error()
+}
+
+// removeToolChange returns given block without the tool change command (nil if it has no other
+// words), and whether it had a tool change.
+func removeToolChange(block *gcode.Block) (*gcode.Block, bool) {
+	if !block.IsCommand() {
+		return block, false
+	}
+	var toolChange bool
+	words := []*gcode.Word{}
+	for _, word := range block.Words() {
+		if word.NormalizedString() == "M6" {
+			toolChange = true
+			continue
+		}
+		words = append(words, word)
+	}
+	if !toolChange {
+		return block, false
+	}
+	if len(words) == 0 {
+		return nil, true
+	}
+	return gcode.NewBlockCommand(words...), true
+}
+
+// splitToolChange returns given block without the tool change command (nil if it has no other
+// words), and whether it had a tool change. The tool selected with T is tracked.
+func (s *ProgramStreamer) splitToolChange(block *gcode.Block) (*gcode.Block, bool, error) {
+	if !block.IsCommand() {
+		return block, false, nil
+	}
+	tool, err := block.GetArgumentNumber('T')
+	if err != nil {
+		return nil, false, err
+	}
+	if tool != nil {
+		s.tool = tool
+	}
+	block, toolChange := removeToolChange(block)
+	return block, toolChange, nil
+}
+
+// toolChange stops streaming until all previous motion is complete, then calls the tool change
+// function.
+func (s *ProgramStreamer) toolChange(ctx context.Context) error {
+	if s.options.ToolChangeFn == nil {
+		return ErrToolChangeNotSupported
+	}
+	if err := s.SendCommand(ctx, syncCommand); err != nil {
+		return fmt.Errorf("tool change: %w", err)
+	}
+	return s.options.ToolChangeFn(ctx, s, s.tool)
+}
+
+// Run streams the program from programReader. If the stream is aborted with the controller, it
+// returns ErrStreamAborted.
 func (s *ProgramStreamer) Run(ctx context.Context, programReader io.Reader) error {
+	if s.options.Controller == nil {
+		return s.run(ctx, programReader)
+	}
+	ctx, cancel := s.options.Controller.start(ctx)
+	defer cancel()
+	err := s.run(ctx, programReader)
+	if s.options.Controller.isAborted() {
+		return ErrStreamAborted
+	}
+	return err
+}
+
+func (s *ProgramStreamer) run(ctx context.Context, programReader io.Reader) error {
 	ctx, logger := log.MustWithGroup(ctx, "Program Streamer")
 
+	if s.options.ErrorPolicy == ResponseErrorPolicyPause && s.options.ConfirmFn == nil {
+		return fmt.Errorf("stream program: %s response error policy requires a confirmation function", s.options.ErrorPolicy)
+	}
+
 	s.availableBufferBytes = s.maxSerialRxBufferBytes
-	s.sentChunkBytes = []int{}
+	s.sentLines = []sentLine{}
+	s.line = 0
+	s.acknowledgedLine = 0
+	s.tool = nil
+	s.aborting = false
+	s.start = time.Now()
+	s.progress = StreamProgress{Estimate: s.options.Estimate}
+	s.reportProgress()
 
 	parser := gcode.NewParser(programReader)
 
 	for {
+		s.line = parser.Lexer.Line
 		eof, block, _, err := parser.Next()
 		if err != nil {
-			return fmt.Errorf("gcode parse error: %w", err)
+			return fmt.Errorf("gcode parse error: line %d: %w", s.sourceLine(s.line), err)
 		}
 
 		if block == nil || block.Empty() {
@@ -115,30 +456,47 @@ func (s *ProgramStreamer) Run(ctx context.Context, programReader io.Reader) erro
 			return fmt.Errorf("%w: %s", ErrEEPROMCommandNotSupported, block.NormalizedString())
 		}
 
-		line := []byte(block.NormalizedString() + "\n")
+		block, toolChange, err := s.splitToolChange(block)
+		if err != nil {
+			return fmt.Errorf("gcode parse error: %w", err)
+		}
 
-		if err := s.writeLine(ctx, line); err != nil {
-			return err
+		if s.options.Controller != nil {
+			if err := s.options.Controller.wait(ctx); err != nil {
+				return fmt.Errorf("stream program: %w", err)
+			}
 		}
 
-		logger.Debug("Sent", "line", strings.TrimSuffix(string(line), "\n"))
+		if block != nil {
+			line := []byte(block.NormalizedString() + "\n")
+
+			if err := s.writeLine(ctx, line, false); err != nil {
+				return err
+			}
+
+			logger.Debug("Sent", "line", strings.TrimSuffix(string(line), "\n"))
+		}
+
+		if toolChange {
+			if err := s.toolChange(ctx); err != nil {
+				return err
+			}
+		}
 
 		if eof {
 			break
 		}
 	}
 
-	for len(s.sentChunkBytes) > 0 {
-		if err := s.waitForResponseMessage(ctx, false); err != nil {
-			return err
-		}
+	if err := s.drain(ctx); err != nil {
+		return err
 	}
 
 	if s.availableBufferBytes != s.maxSerialRxBufferBytes {
 		panic(fmt.Errorf("bug: final availableBufferBytes %d differs from maxSerialRxBufferBytes %d", s.availableBufferBytes, s.maxSerialRxBufferBytes))
 	}
-	if len(s.sentChunkBytes) > 0 {
-		panic(fmt.Errorf("bug: sentChunkBytes not empty: %#v", s.sentChunkBytes))
+	if len(s.sentLines) > 0 {
+		panic(fmt.Errorf("bug: sentLines not empty: %#v", s.sentLines))
 	}
 
 	return nil
@@ -0,0 +1,260 @@
+package grbl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+func TestProgramStreamerResponseErrorPolicy(t *testing.T) {
+	program := "G0 X1\n\nG2 X2 I1\nG0 X3\n"
+	responses := []string{"ok", "error:33", "ok"}
+
+	for _, tc := range []struct {
+		policy        ResponseErrorPolicy
+		confirmErr    error
+		expectedPort  string
+		expectConfirm bool
+		expectErr     bool
+	}{
+		{
+			policy:       ResponseErrorPolicyAbort,
+			expectedPort: "G0X1\nG2X2I1\nG0X3\n!",
+			expectErr:    true,
+		},
+		{
+			policy:        ResponseErrorPolicyPause,
+			expectedPort:  "G0X1\nG2X2I1\nG0X3\n!~",
+			expectConfirm: true,
+		},
+		{
+			policy:        ResponseErrorPolicyPause,
+			confirmErr:    errors.New("cancelled"),
+			expectedPort:  "G0X1\nG2X2I1\nG0X3\n!!",
+			expectConfirm: true,
+			expectErr:     true,
+		},
+		{
+			policy:       ResponseErrorPolicyContinue,
+			expectedPort: "G0X1\nG2X2I1\nG0X3\n",
+		},
+	} {
+		t.Run(tc.policy.String(), func(t *testing.T) {
+			ctx := log.WithTestLogger(t.Context())
+
+			responseMessageCh := make(chan *ResponseMessage, len(responses))
+			for _, response := range responses {
+				responseMessage, err := NewMessageResponse(response)
+				require.NoError(t, err)
+				responseMessageCh <- responseMessage
+			}
+
+			var confirmMessage string
+			confirmFn := func(ctx context.Context, message string) error {
+				confirmMessage = message
+				return tc.confirmErr
+			}
+
+			port := &bytes.Buffer{}
+			err := NewProgramStreamer(
+				port, responseMessageCh, nil, 128, nil, StreamOptions{
+					ErrorPolicy: tc.policy,
+					ConfirmFn:   confirmFn,
+				},
+			).Run(ctx, strings.NewReader(program))
+
+			require.Equal(t, tc.expectedPort, port.String())
+			if tc.expectConfirm {
+				require.Contains(t, confirmMessage, "line 3: G2X2I1")
+			} else {
+				require.Empty(t, confirmMessage)
+			}
+
+			if !tc.expectErr {
+				require.NoError(t, err)
+				return
+			}
+			var lineErr *ProgramLineError
+			require.ErrorAs(t, err, &lineErr)
+			require.Equal(t, uint(3), lineErr.Line)
+			require.Equal(t, "G2X2I1", lineErr.Block)
+			require.ErrorIs(t, err, ErrResponseMessage(33))
+			if tc.confirmErr != nil {
+				require.ErrorIs(t, err, tc.confirmErr)
+			}
+		})
+	}
+}
+
+func TestProgramStreamerAbortNoResponses(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+
+	abortDrainTimeout = 10 * time.Millisecond
+	t.Cleanup(func() { abortDrainTimeout = 5 * time.Second })
+
+	// Responses stop arriving after the hold, as when Grbl's planner buffer is full.
+	responseMessageCh := make(chan *ResponseMessage, 2)
+	responseMessageCh <- &ResponseMessage{Message: "ok"}
+	responseMessageCh <- &ResponseMessage{Message: "error:33"}
+
+	port := &bytes.Buffer{}
+	err := NewProgramStreamer(
+		port, responseMessageCh, nil, 128, nil, StreamOptions{ErrorPolicy: ResponseErrorPolicyAbort},
+	).Run(ctx, strings.NewReader("G0 X1\nG2 X2 I1\nG0 X3\nG0 X4\n"))
+
+	require.Equal(t, "G0X1\nG2X2I1\nG0X3\nG0X4\n!", port.String())
+	var lineErr *ProgramLineError
+	require.ErrorAs(t, err, &lineErr)
+	require.Equal(t, uint(2), lineErr.Line)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, err, "2 line(s) not acknowledged")
+}
+
+func TestProgramStreamerProgress(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+	program := "G0 X10\nG1 X20 F600\n\nG4 P1\n"
+
+	estimate, err := NewProgramEstimate(strings.NewReader(program), testPlannerSettings, gcode.NewMachine(gcode.MachineParameters{}, gcode.Vector{}))
+	require.NoError(t, err)
+
+	responseMessageCh := make(chan *ResponseMessage, 3)
+	for range 3 {
+		responseMessageCh <- &ResponseMessage{Message: "ok"}
+	}
+
+	progresses := []StreamProgress{}
+	err = NewProgramStreamer(
+		&bytes.Buffer{}, responseMessageCh, nil, 128, nil, StreamOptions{
+			Estimate: estimate,
+			ProgressFn: func(progress StreamProgress) {
+				progresses = append(progresses, progress)
+			},
+		},
+	).Run(ctx, strings.NewReader(program))
+	require.NoError(t, err)
+
+	first := progresses[0]
+	require.Equal(t, 0, first.SentLines)
+	require.Equal(t, 0.0, first.TimePercent)
+	require.Equal(t, estimate.Total, first.ETA)
+
+	last := progresses[len(progresses)-1]
+	require.Equal(t, 3, last.SentLines)
+	require.Equal(t, estimate.Bytes, last.SentBytes)
+	require.Equal(t, 3, last.AcknowledgedLines)
+	require.Equal(t, estimate.Bytes, last.AcknowledgedBytes)
+	require.Equal(t, uint(4), last.Line)
+	require.Equal(t, 100.0, last.BytesPercent)
+	require.Equal(t, 100.0, last.TimePercent)
+	require.Equal(t, time.Duration(0), last.ETA)
+}
+
+func TestProgramStreamerProgressToolChange(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+	program := "G0 X10\nT1 M6\nG0 X20\n"
+
+	estimate, err := NewProgramEstimate(strings.NewReader(program), testPlannerSettings, gcode.NewMachine(gcode.MachineParameters{}, gcode.Vector{}))
+	require.NoError(t, err)
+	require.Equal(t, len("G0X10\nT1\nG0X20\n"), estimate.Bytes)
+
+	// Program lines, the sync command and the tool change command.
+	responseMessageCh := make(chan *ResponseMessage, 5)
+	for range 5 {
+		responseMessageCh <- &ResponseMessage{Message: "ok"}
+	}
+
+	port := &bytes.Buffer{}
+	var last StreamProgress
+	err = NewProgramStreamer(
+		port, responseMessageCh, nil, 128, nil, StreamOptions{
+			ToolChangeFn: func(ctx context.Context, sender CommandSender, tool *float64) error {
+				return sender.SendCommand(ctx, "G0 Z10")
+			},
+			Estimate: estimate,
+			ProgressFn: func(progress StreamProgress) {
+				last = progress
+			},
+		},
+	).Run(ctx, strings.NewReader(program))
+	require.NoError(t, err)
+	require.Equal(t, "G0X10\nT1\nG4 P0.01\nG0 Z10\nG0X20\n", port.String())
+
+	require.Equal(t, 3, last.SentLines)
+	require.Equal(t, estimate.Bytes, last.SentBytes)
+	require.Equal(t, 3, last.AcknowledgedLines)
+	require.Equal(t, estimate.Bytes, last.AcknowledgedBytes)
+	require.Equal(t, 100.0, last.BytesPercent)
+}
+
+func TestProgramStreamerSourceLine(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+
+	responseMessageCh := make(chan *ResponseMessage, 2)
+	responseMessageCh <- &ResponseMessage{Message: "ok"}
+	responseMessageCh <- &ResponseMessage{Message: "error:33"}
+
+	progresses := []StreamProgress{}
+	err := NewProgramStreamer(
+		&bytes.Buffer{}, responseMessageCh, nil, 128, nil, StreamOptions{
+			ProgressFn: func(progress StreamProgress) {
+				progresses = append(progresses, progress)
+			},
+			SourceLineFn: func(line uint) uint { return line + 10 },
+		},
+	).Run(ctx, strings.NewReader("G0 X1\nG2 X2 I1\n"))
+
+	var lineErr *ProgramLineError
+	require.ErrorAs(t, err, &lineErr)
+	require.Equal(t, uint(12), lineErr.Line)
+	require.Equal(t, uint(12), progresses[len(progresses)-1].Line)
+}
+
+func TestProgramStreamerUnexpectedResponseMessage(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+
+	responseMessageCh := make(chan *ResponseMessage, 1)
+	responseMessageCh <- &ResponseMessage{Message: "ok"}
+
+	streamer := NewProgramStreamer(&bytes.Buffer{}, responseMessageCh, nil, 128, nil, StreamOptions{})
+	err := streamer.SendCommand(ctx, "")
+	require.ErrorContains(t, err, "unexpected response message: ok")
+}
+
+func TestProgramStreamerLineLongerThanRxBuffer(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+
+	responseMessageCh := make(chan *ResponseMessage, 1)
+	responseMessageCh <- &ResponseMessage{Message: "ok"}
+
+	port := &bytes.Buffer{}
+	err := NewProgramStreamer(port, responseMessageCh, nil, 8, nil, StreamOptions{}).
+		Run(ctx, strings.NewReader("G0 X1\nG0 X1 Y2 Z3\n"))
+	require.ErrorContains(t, err, "line 2")
+	require.ErrorContains(t, err, "longer than Grbl's serial RX buffer of 8 bytes")
+	require.Equal(t, "G0X1\n", port.String())
+}
+
+func TestProgramStreamerAlarm(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+
+	// Grbl resets on alarms, so the response messages of sent lines never arrive.
+	responseMessageCh := make(chan *ResponseMessage)
+	alarmCh := make(chan *AlarmPushMessage, 1)
+	alarmCh <- &AlarmPushMessage{Message: "ALARM:1"}
+
+	port := &bytes.Buffer{}
+	err := NewProgramStreamer(port, responseMessageCh, alarmCh, 8, nil, StreamOptions{}).
+		Run(ctx, strings.NewReader("G0 X1\nG0 X2\nG0 X3\n"))
+	var alarmErr *StreamAlarmError
+	require.ErrorAs(t, err, &alarmErr)
+	require.Equal(t, "ALARM:1", alarmErr.Alarm.Message)
+	require.Equal(t, "G0X1\n", port.String())
+}
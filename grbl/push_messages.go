@@ -301,6 +301,33 @@ func (g *GcodeParameters) Update(gcodeParamPushMessage *GcodeParamPushMessage) {
 	}
 }
 
+// MachineParameters returns the parameters for gcode.Machine. Parameters not yet reported are zero.
+func (g *GcodeParameters) MachineParameters() gcode.MachineParameters {
+	vector := func(c *Coordinates) gcode.Vector {
+		if c == nil {
+			return gcode.Vector{}
+		}
+		return gcode.Vector{X: c.X, Y: c.Y, Z: c.Z}
+	}
+	machineParameters := gcode.MachineParameters{
+		CoordinateSystems: [6]gcode.Vector{
+			vector(g.CoordinateSystem1),
+			vector(g.CoordinateSystem2),
+			vector(g.CoordinateSystem3),
+			vector(g.CoordinateSystem4),
+			vector(g.CoordinateSystem5),
+			vector(g.CoordinateSystem6),
+		},
+		PrimaryPreDefinedPosition:   vector(g.PrimaryPreDefinedPosition),
+		SecondaryPreDefinedPosition: vector(g.SecondaryPreDefinedPosition),
+		CoordinateOffset:            vector(g.CoordinateOffset),
+	}
+	if g.ToolLengthOffset != nil {
+		machineParameters.ToolLengthOffset = *g.ToolLengthOffset
+	}
+	return machineParameters
+}
+
 func (g *GcodeParameters) HasCoordinateSystem() bool {
 	if g.CoordinateSystem1 != nil {
 		return true
@@ -476,12 +503,22 @@ func (m *VersionPushMessage) String() string {
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
 type CompileTimeOptionsPushMessage struct {
-	Message             string
+	Message string
+	// OptionCodes has one character for each enabled option, such as 'V' for variable spindle.
+	OptionCodes         string
 	CompileTimeOptions  []string
 	PlannerBlocks       uint64
 	SerialRxBufferBytes uint64
 }
 
+// Compile time option codes.
+const (
+	CompileTimeOptionVariableSpindle rune = 'V'
+	CompileTimeOptionLineNumbers     rune = 'N'
+	CompileTimeOptionMistCoolant     rune = 'M'
+	CompileTimeOptionDualAxis        rune = '2'
+)
+
 var buildOptionDescription = map[rune]string{
 	'V': "Variable spindle",
 	'N': "Line numbers",
@@ -519,7 +556,8 @@ func NewCompileTimeOptionsPushMessage(message string) (*CompileTimeOptionsPushMe
 	}
 	text := strings.TrimSuffix(strings.TrimPrefix(message, prefix), suffix)
 	parts := strings.Split(text, sep)
-	if len(parts) != 3 {
+	// grblHAL adds extra fields after the serial RX buffer bytes.
+	if len(parts) < 3 {
 		return nil, fmt.Errorf("message format unknown: %#v", message)
 	}
 	compileTimeOptions := []string{}
@@ -542,6 +580,7 @@ func NewCompileTimeOptionsPushMessage(message string) (*CompileTimeOptionsPushMe
 	}
 	return &CompileTimeOptionsPushMessage{
 		Message:             message,
+		OptionCodes:         parts[0],
 		CompileTimeOptions:  compileTimeOptions,
 		PlannerBlocks:       plannerBlocks,
 		SerialRxBufferBytes: serialRxBufferBytes,
@@ -552,6 +591,12 @@ func (m *CompileTimeOptionsPushMessage) String() string {
 	return m.Message
 }
 
+// HasOption returns whether the option with given code (eg: CompileTimeOptionVariableSpindle) is
+// enabled.
+func (m *CompileTimeOptionsPushMessage) HasOption(code rune) bool {
+	return strings.ContainsRune(m.OptionCodes, code)
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 // StartupLineExecution
 ////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,67 @@
+package grbl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCompileTimeOptionsPushMessage(t *testing.T) {
+	for _, tc := range []struct {
+		message             string
+		compileTimeOptions  []string
+		plannerBlocks       uint64
+		serialRxBufferBytes uint64
+		variableSpindle     bool
+		lineNumbers         bool
+		dualAxis            bool
+		errorContains       string
+	}{
+		{
+			message:             "[OPT:VNM,15,128]",
+			compileTimeOptions:  []string{"Variable spindle", "Line numbers", "Mist coolant M7"},
+			plannerBlocks:       15,
+			serialRxBufferBytes: 128,
+			variableSpindle:     true,
+			lineNumbers:         true,
+		},
+		{
+			message:             "[OPT:2,15,128]",
+			compileTimeOptions:  []string{"Dual axis motors"},
+			plannerBlocks:       15,
+			serialRxBufferBytes: 128,
+			dualAxis:            true,
+		},
+		{
+			message:             "[OPT:VN,35,1024,3,0]",
+			compileTimeOptions:  []string{"Variable spindle", "Line numbers"},
+			plannerBlocks:       35,
+			serialRxBufferBytes: 1024,
+			variableSpindle:     true,
+			lineNumbers:         true,
+		},
+		{
+			message:       "[OPT:V,15]",
+			errorContains: "message format unknown",
+		},
+		{
+			message:       "[OPT:V,15,x]",
+			errorContains: "unable to parse serial RX buffer bytes",
+		},
+	} {
+		t.Run(tc.message, func(t *testing.T) {
+			m, err := NewCompileTimeOptionsPushMessage(tc.message)
+			if tc.errorContains != "" {
+				require.ErrorContains(t, err, tc.errorContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.compileTimeOptions, m.CompileTimeOptions)
+			require.Equal(t, tc.plannerBlocks, m.PlannerBlocks)
+			require.Equal(t, tc.serialRxBufferBytes, m.SerialRxBufferBytes)
+			require.Equal(t, tc.variableSpindle, m.HasOption(CompileTimeOptionVariableSpindle))
+			require.Equal(t, tc.lineNumbers, m.HasOption(CompileTimeOptionLineNumbers))
+			require.Equal(t, tc.dualAxis, m.HasOption(CompileTimeOptionDualAxis))
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package grbl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+// Settings holds Grbl settings, as reported by $$, by key (eg: "110" for $110).
+type Settings map[string]string
+
+// Update Settings from given SettingPushMessage.
+func (s Settings) Update(settingPushMessage *SettingPushMessage) {
+	s[settingPushMessage.Key] = settingPushMessage.Value
+}
+
+// ReadSettings reads settings from r, with one $x=val setting per line, such as the output of $$.
+// Other lines (eg: G-code) are ignored.
+func ReadSettings(r io.Reader) (Settings, error) {
+	settings := Settings{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, _, ok := strings.Cut(strings.TrimPrefix(line, "$"), "=")
+		if !strings.HasPrefix(line, "$") || !ok {
+			continue
+		}
+		if _, err := strconv.Atoi(key); err != nil {
+			continue
+		}
+		settingPushMessage, err := NewSettingPushMessage(line)
+		if err != nil {
+			return nil, err
+		}
+		settings.Update(settingPushMessage)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetFloat returns the value for given setting key as a float.
+func (s Settings) GetFloat(key string) (float64, error) {
+	value, ok := s[key]
+	if !ok {
+		return 0, fmt.Errorf("setting $%s is missing", key)
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("setting $%s: %w", key, err)
+	}
+	return f, nil
+}
+
+// GetVector returns the X, Y and Z values for given setting keys as a gcode.Vector.
+func (s Settings) GetVector(xKey, yKey, zKey string) (gcode.Vector, error) {
+	var vector gcode.Vector
+	var err error
+	if vector.X, err = s.GetFloat(xKey); err != nil {
+		return vector, err
+	}
+	if vector.Y, err = s.GetFloat(yKey); err != nil {
+		return vector, err
+	}
+	if vector.Z, err = s.GetFloat(zKey); err != nil {
+		return vector, err
+	}
+	return vector, nil
+}
+
+// PlannerSettings returns the motion planner settings: $11, $12, $110-$112 and $120-$122.
+func (s Settings) PlannerSettings() (gcode.PlannerSettings, error) {
+	var plannerSettings gcode.PlannerSettings
+	var err error
+	if plannerSettings.JunctionDeviation, err = s.GetFloat("11"); err != nil {
+		return plannerSettings, err
+	}
+	if plannerSettings.ArcTolerance, err = s.GetFloat("12"); err != nil {
+		return plannerSettings, err
+	}
+	if plannerSettings.MaxRate, err = s.GetVector("110", "111", "112"); err != nil {
+		return plannerSettings, err
+	}
+	if plannerSettings.Acceleration, err = s.GetVector("120", "121", "122"); err != nil {
+		return plannerSettings, err
+	}
+	return plannerSettings, nil
+}
+
+// Limits returns the machine travel limits, as enforced by Grbl soft limits, from $130-$132 max
+// travel, $23 homing direction invert mask and $27 homing pull-off. homingForceSetOrigin must match
+// Grbl's HOMING_FORCE_SET_ORIGIN compile time option (reported by $I as option "Z").
+func (s Settings) Limits(homingForceSetOrigin bool) (gcode.Limits, error) {
+	var limits gcode.Limits
+	maxTravel, err := s.GetVector("130", "131", "132")
+	if err != nil {
+		return limits, err
+	}
+	homingDirInvertMask, err := s.GetFloat("23")
+	if err != nil {
+		return limits, err
+	}
+	homingPullOff, err := s.GetFloat("27")
+	if err != nil {
+		return limits, err
+	}
+	var mins, maxs, homes [3]float64
+	for i, travel := range [3]float64{maxTravel.X, maxTravel.Y, maxTravel.Z} {
+		negativeDir := int(homingDirInvertMask)&(1<<i) != 0
+		// Same as Grbl's system_check_travel_limits and limits_go_home.
+		mins[i], maxs[i], homes[i] = -travel, 0, -homingPullOff
+		switch {
+		case homingForceSetOrigin && negativeDir:
+			mins[i], maxs[i], homes[i] = 0, travel, 0
+		case homingForceSetOrigin:
+			homes[i] = 0
+		case negativeDir:
+			homes[i] = -travel + homingPullOff
+		}
+	}
+	limits.Min = gcode.Vector{X: mins[0], Y: mins[1], Z: mins[2]}
+	limits.Max = gcode.Vector{X: maxs[0], Y: maxs[1], Z: maxs[2]}
+	limits.Home = gcode.Vector{X: homes[0], Y: homes[1], Z: homes[2]}
+	return limits, nil
+}
@@ -0,0 +1,210 @@
+package grbl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrStreamAborted = errors.New("program stream aborted")
+
+// streamControllerPollInterval is the interval to query status reports while aborting.
+var streamControllerPollInterval = 100 * time.Millisecond
+
+// StreamAbortReport is where a program stream was aborted.
+type StreamAbortReport struct {
+	// Line at the program, as mapped by StreamOptions SourceLineFn, of the last line queued at
+	// Grbl's planner, as acknowledged by Grbl. This is not where motion stopped: lines up to it may
+	// not have been executed, but lines after it were not.
+	LastQueuedLine uint
+	// Line number (N) of the block being executed, as reported by Grbl, when the program has line
+	// numbers and Grbl was compiled with them. Nil otherwise.
+	LineNumber *LineNumber
+}
+
+// StreamController controls a program being streamed with StreamProgram, with StreamOptions
+// Controller set to it. It can be used for a single stream.
+type StreamController struct {
+	grbl *Grbl
+
+	mu             sync.Mutex
+	paused         bool
+	resumeCh       chan struct{}
+	aborted        bool
+	cancel         context.CancelFunc
+	lastQueuedLine uint
+}
+
+// NewStreamController creates a new StreamController.
+func NewStreamController(grbl *Grbl) *StreamController {
+	return &StreamController{
+		grbl: grbl,
+	}
+}
+
+// start returns a context for streaming, that is cancelled by Abort.
+func (c *StreamController) start(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cancel = cancel
+	if c.aborted {
+		cancel()
+	}
+	return ctx, cancel
+}
+
+// wait blocks while paused.
+func (c *StreamController) wait(ctx context.Context) error {
+	c.mu.Lock()
+	resumeCh := c.resumeCh
+	paused := c.paused
+	c.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumeCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// queued records the line of the last line acknowledged by Grbl, which is queued at its planner.
+func (c *StreamController) queued(line uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastQueuedLine = line
+}
+
+// isAborted returns whether Abort was called.
+func (c *StreamController) isAborted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.aborted
+}
+
+// Paused returns whether the stream is paused.
+func (c *StreamController) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Pause sends a feed hold and stops sending lines to Grbl, until Resume is called.
+func (c *StreamController) Pause() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aborted {
+		return ErrStreamAborted
+	}
+	if err := c.grbl.SendRealTimeCommand(RealTimeCommandFeedHold); err != nil {
+		return err
+	}
+	if !c.paused {
+		c.paused = true
+		c.resumeCh = make(chan struct{})
+	}
+	return nil
+}
+
+// Resume sends a cycle start and resumes sending lines to Grbl.
+func (c *StreamController) Resume() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.aborted {
+		return ErrStreamAborted
+	}
+	if err := c.grbl.SendRealTimeCommand(RealTimeCommandCycleStartResume); err != nil {
+		return err
+	}
+	if c.paused {
+		c.paused = false
+		close(c.resumeCh)
+	}
+	return nil
+}
+
+// waitForHoldComplete sends status report queries until Grbl reports that motion stopped: hold
+// complete (Hold:0), or a state without motion.
+func (c *StreamController) waitForHoldComplete(ctx context.Context) (*StatusReportPushMessage, error) {
+	previous := c.grbl.GetLastStatusReport()
+	for {
+		if err := c.grbl.SendRealTimeCommand(RealTimeCommandStatusReportQuery); err != nil {
+			return nil, err
+		}
+		select {
+		case <-time.After(streamControllerPollInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for hold to complete: %w", ctx.Err())
+		}
+		statusReport := c.grbl.GetLastStatusReport()
+		if statusReport == nil || statusReport == previous {
+			continue
+		}
+		machineState := statusReport.MachineState
+		switch machineState.State {
+		case StateHold:
+			if machineState.SubState != nil && *machineState.SubState == 0 {
+				return statusReport, nil
+			}
+		case StateIdle, StateAlarm, StateCheck, StateSleep:
+			return statusReport, nil
+		}
+	}
+}
+
+// Abort stops the stream: it sends a feed hold, waits for the hold to complete, stops sending lines
+// and does a soft reset, which clears Grbl's planner without losing position. The G-code parser
+// state ($G) and parameters ($#) are queried again, as the reset restores defaults. StreamProgram
+// returns ErrStreamAborted.
+func (c *StreamController) Abort(ctx context.Context) (*StreamAbortReport, error) {
+	c.mu.Lock()
+	if c.aborted {
+		c.mu.Unlock()
+		return nil, ErrStreamAborted
+	}
+	c.aborted = true
+	// Stop sending lines: the stream is cancelled once the hold is complete.
+	if !c.paused {
+		c.paused = true
+		c.resumeCh = make(chan struct{})
+	}
+	c.mu.Unlock()
+
+	if err := c.grbl.SendRealTimeCommand(RealTimeCommandFeedHold); err != nil {
+		return nil, err
+	}
+	statusReport, err := c.waitForHoldComplete(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	report := &StreamAbortReport{
+		LastQueuedLine: c.lastQueuedLine,
+		LineNumber:     statusReport.LineNumber,
+	}
+	c.mu.Unlock()
+
+	if err := c.grbl.SoftReset(ctx); err != nil {
+		return report, err
+	}
+
+	for _, command := range []string{
+		GrblCommandViewGcodeParserState,
+		GrblCommandViewGcodeParameters,
+	} {
+		if err := c.grbl.SendCommand(ctx, command); err != nil {
+			return report, fmt.Errorf("%s: %w", command, err)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,188 @@
+package grbl
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fornellas/slogxt/log"
+	"github.com/stretchr/testify/require"
+	"go.bug.st/serial"
+)
+
+// fakePort is a serial.Port that emulates Grbl responses to streamed lines, real time commands and
+// the commands sent by Connect and StreamController.
+type fakePort struct {
+	mu      sync.Mutex
+	written strings.Builder
+	line    strings.Builder
+	output  []byte
+	hold    bool
+}
+
+func newFakePort() *fakePort {
+	return &fakePort{output: []byte("Grbl 1.1h ['$' for help]\r\n")}
+}
+
+func (p *fakePort) respond(message string) {
+	p.output = append(p.output, []byte(message+"\r\n")...)
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written.Write(b)
+	for _, c := range b {
+		switch RealTimeCommand(c) {
+		case RealTimeCommandFeedHold:
+			p.hold = true
+			continue
+		case RealTimeCommandCycleStartResume:
+			p.hold = false
+			continue
+		case RealTimeCommandStatusReportQuery:
+			if p.hold {
+				p.respond("<Hold:0|MPos:0.000,0.000,0.000|FS:0,0>")
+			} else {
+				p.respond("<Idle|MPos:0.000,0.000,0.000|FS:0,0>")
+			}
+			continue
+		case RealTimeCommandSoftReset:
+			p.hold = false
+			p.line.Reset()
+			p.respond("Grbl 1.1h ['$' for help]")
+			continue
+		}
+		if c != '\n' {
+			p.line.WriteByte(c)
+			continue
+		}
+		switch p.line.String() {
+		case GrblCommandViewBuildInfo:
+			p.respond("[VER:1.1h.20190825:]")
+			// Room for a single line at a time.
+			p.respond("[OPT:V,15,6]")
+		case GrblCommandViewGcodeParserState:
+			p.respond("[GC:G0 G54 G17 G21 G90 G94 M5 M9 T0 F0 S0]")
+		case GrblCommandViewGcodeParameters:
+			p.respond("[G54:0.000,0.000,0.000]")
+		}
+		p.respond("ok")
+		p.line.Reset()
+	}
+	return len(b), nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	n := copy(b, p.output)
+	p.output = p.output[n:]
+	p.mu.Unlock()
+	if n == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return n, nil
+}
+
+func (p *fakePort) getWritten() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.written.String()
+}
+
+func (p *fakePort) SetMode(mode *serial.Mode) error { return nil }
+func (p *fakePort) Drain() error                    { return nil }
+func (p *fakePort) ResetInputBuffer() error         { return nil }
+func (p *fakePort) ResetOutputBuffer() error        { return nil }
+func (p *fakePort) SetDTR(dtr bool) error           { return nil }
+func (p *fakePort) SetRTS(rts bool) error           { return nil }
+func (p *fakePort) GetModemStatusBits() (*serial.ModemStatusBits, error) {
+	return &serial.ModemStatusBits{}, nil
+}
+func (p *fakePort) SetReadTimeout(t time.Duration) error { return nil }
+func (p *fakePort) Close() error                         { return nil }
+func (p *fakePort) Break(time.Duration) error            { return nil }
+
+// connectFakeGrbl returns a Grbl connected to a fakePort, reading its push messages until the test
+// ends.
+func connectFakeGrbl(ctx context.Context, t *testing.T) (*Grbl, *fakePort) {
+	port := newFakePort()
+	grbl := NewGrbl(func(context.Context, *serial.Mode) (serial.Port, error) {
+		return port, nil
+	})
+	pushMessageCh, err := grbl.Connect(ctx)
+	require.NoError(t, err)
+	go func() {
+		for range pushMessageCh {
+		}
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, grbl.Disconnect(ctx))
+	})
+	return grbl, port
+}
+
+func TestStreamControllerPauseResume(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+	grbl, port := connectFakeGrbl(ctx, t)
+
+	controller := NewStreamController(grbl)
+	require.NoError(t, controller.Pause())
+	require.True(t, controller.Paused())
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- grbl.StreamProgram(ctx, strings.NewReader("G0 X1\nG0 X2\n"), StreamOptions{Controller: controller})
+	}()
+	require.Never(t, func() bool {
+		return strings.Contains(port.getWritten(), "G0X1")
+	}, 50*time.Millisecond, time.Millisecond)
+
+	require.NoError(t, controller.Resume())
+	require.False(t, controller.Paused())
+	require.NoError(t, <-errCh)
+	require.Equal(t, "$I\n!~G0X1\nG0X2\n", port.getWritten())
+}
+
+func TestStreamControllerAbort(t *testing.T) {
+	ctx := log.WithTestLogger(t.Context())
+	streamControllerPollInterval = time.Millisecond
+	t.Cleanup(func() { streamControllerPollInterval = 100 * time.Millisecond })
+
+	grbl, port := connectFakeGrbl(ctx, t)
+
+	controller := NewStreamController(grbl)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- grbl.StreamProgram(ctx, strings.NewReader("G0 X1\nG0 X2\nG0 X3\n"), StreamOptions{
+			Controller: controller,
+			ProgressFn: func(progress StreamProgress) {
+				// Lines after the first acknowledged one are not sent.
+				if progress.AcknowledgedLines == 1 && !controller.Paused() {
+					if err := controller.Pause(); err != nil {
+						t.Error(err)
+					}
+				}
+			},
+		})
+	}()
+	require.Eventually(t, func() bool {
+		return controller.Paused() && strings.HasSuffix(port.getWritten(), "0X2\n")
+	}, time.Second, time.Millisecond)
+
+	report, err := controller.Abort(ctx)
+	require.NoError(t, err)
+	require.Equal(t, &StreamAbortReport{LastQueuedLine: 1}, report)
+	require.ErrorIs(t, <-errCh, ErrStreamAborted)
+
+	written := port.getWritten()
+	require.NotContains(t, written, "G0X3")
+	require.Regexp(t, `^\$I\nG0X1\n!G0X2\n!\?+\x18\$G\n\$#\n$`, written)
+
+	_, err = controller.Abort(ctx)
+	require.ErrorIs(t, err, ErrStreamAborted)
+	require.ErrorIs(t, controller.Pause(), ErrStreamAborted)
+	require.ErrorIs(t, controller.Resume(), ErrStreamAborted)
+}
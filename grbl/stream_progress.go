@@ -0,0 +1,220 @@
+package grbl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+// lineEstimate is the estimated execution time of program lines.
+type lineEstimate struct {
+	// Time for motion at feed rate, affected by the feed override.
+	feed time.Duration
+	// Time for motion at rapid rate, affected by the rapid override.
+	rapid time.Duration
+	// Time for dwell (G4), not affected by overrides.
+	dwell time.Duration
+}
+
+func (e lineEstimate) add(o lineEstimate) lineEstimate {
+	return lineEstimate{
+		feed:  e.feed + o.feed,
+		rapid: e.rapid + o.rapid,
+		dwell: e.dwell + o.dwell,
+	}
+}
+
+func (e lineEstimate) sub(o lineEstimate) lineEstimate {
+	return lineEstimate{
+		feed:  e.feed - o.feed,
+		rapid: e.rapid - o.rapid,
+		dwell: e.dwell - o.dwell,
+	}
+}
+
+// duration returns the total time, with the feed and rapid overrides (percent) applied.
+func (e lineEstimate) duration(feedOverride, rapidOverride float64) time.Duration {
+	return time.Duration(
+		float64(e.feed)*100/feedOverride +
+			float64(e.rapid)*100/rapidOverride +
+			float64(e.dwell),
+	)
+}
+
+// cumulativeLineEstimate is the estimated execution time from the start of the program, up to and
+// including a line.
+type cumulativeLineEstimate struct {
+	line uint
+	lineEstimate
+}
+
+// ProgramEstimate is the estimated size and execution time of a program, used to report progress
+// while streaming it.
+type ProgramEstimate struct {
+	// Bytes sent to Grbl when streaming the program.
+	Bytes int
+	// Total estimated execution time, without overrides.
+	Total time.Duration
+	total lineEstimate
+	// Sorted by line.
+	cumulative []cumulativeLineEstimate
+}
+
+// NewProgramEstimate estimates the program from programReader, executed with given Machine.
+func NewProgramEstimate(
+	programReader io.Reader,
+	settings gcode.PlannerSettings,
+	machine *gcode.Machine,
+) (*ProgramEstimate, error) {
+	parser := gcode.NewParser(programReader)
+	estimator := gcode.NewEstimator(settings)
+	lines := map[uint]lineEstimate{}
+	var bytes int
+	for {
+		line := parser.Lexer.Line
+		eof, block, segments, err := machine.Next(parser)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if block != nil && !block.Empty() {
+			// Tool changes are not sent to Grbl.
+			if streamedBlock, _ := removeToolChange(block); streamedBlock != nil {
+				bytes += len(streamedBlock.NormalizedString()) + 1
+			}
+			if err := estimator.Add(block, segments); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			if block.IsCommand() {
+				for _, word := range block.Commands() {
+					if word.NormalizedString() != "G4" {
+						continue
+					}
+					p, err := block.GetArgumentNumber('P')
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %w", line, err)
+					}
+					if p != nil {
+						lineEstimate := lines[line]
+						lineEstimate.dwell += time.Duration(*p * float64(time.Second))
+						lines[line] = lineEstimate
+					}
+				}
+			}
+		}
+		if eof {
+			break
+		}
+	}
+
+	estimate := estimator.Estimate()
+	for _, segmentEstimate := range estimate.Segments {
+		lineEstimate := lines[segmentEstimate.Segment.Line]
+		if segmentEstimate.Segment.Type == gcode.SegmentTypeRapid {
+			lineEstimate.rapid += segmentEstimate.Duration
+		} else {
+			lineEstimate.feed += segmentEstimate.Duration
+		}
+		lines[segmentEstimate.Segment.Line] = lineEstimate
+	}
+
+	programEstimate := &ProgramEstimate{
+		Bytes: bytes,
+		Total: estimate.Total,
+	}
+	for line, lineEstimate := range lines {
+		programEstimate.cumulative = append(programEstimate.cumulative, cumulativeLineEstimate{
+			line:         line,
+			lineEstimate: lineEstimate,
+		})
+	}
+	sort.Slice(programEstimate.cumulative, func(i, j int) bool {
+		return programEstimate.cumulative[i].line < programEstimate.cumulative[j].line
+	})
+	for i := range programEstimate.cumulative {
+		programEstimate.total = programEstimate.total.add(programEstimate.cumulative[i].lineEstimate)
+		programEstimate.cumulative[i].lineEstimate = programEstimate.total
+	}
+	return programEstimate, nil
+}
+
+// done returns the estimated execution time from the start of the program, up to and including
+// given line.
+func (e *ProgramEstimate) done(line uint) lineEstimate {
+	i := sort.Search(len(e.cumulative), func(i int) bool {
+		return e.cumulative[i].line > line
+	})
+	if i == 0 {
+		return lineEstimate{}
+	}
+	return e.cumulative[i-1].lineEstimate
+}
+
+// StreamProgress is the progress of a program being streamed. Lines and bytes are for the program
+// only: commands sent for tool changes are not counted.
+type StreamProgress struct {
+	// Lines sent to Grbl.
+	SentLines int
+	// Bytes sent to Grbl.
+	SentBytes int
+	// Lines acknowledged by Grbl. These are queued at Grbl's planner, so they are slightly ahead of
+	// the motion being executed.
+	AcknowledgedLines int
+	// Bytes acknowledged by Grbl.
+	AcknowledgedBytes int
+	// Line at the program of the last acknowledged line, as mapped by StreamOptions SourceLineFn, 0
+	// if none.
+	Line uint
+	// Time since streaming started.
+	Elapsed time.Duration
+	// Program estimate, nil if unknown.
+	Estimate *ProgramEstimate
+	// Percent of program bytes acknowledged, 0 if the estimate is unknown.
+	BytesPercent float64
+	// Percent of estimated program time acknowledged, 0 if the estimate is unknown.
+	TimePercent float64
+	// Estimated remaining time, with the current feed and rapid overrides, 0 if the estimate is
+	// unknown.
+	ETA time.Duration
+}
+
+// update sets the percents and ETA from the estimate, for the last acknowledged line of the streamed
+// program, with given overrides (nil if unknown).
+func (p *StreamProgress) update(line uint, overrideValues *OverrideValues) {
+	if p.Estimate == nil {
+		return
+	}
+	if p.Estimate.Bytes > 0 {
+		p.BytesPercent = min(float64(p.AcknowledgedBytes)/float64(p.Estimate.Bytes)*100, 100)
+	}
+	done := p.Estimate.done(line)
+	if p.Estimate.total.duration(100, 100) > 0 {
+		p.TimePercent = float64(done.duration(100, 100)) / float64(p.Estimate.total.duration(100, 100)) * 100
+	}
+	feedOverride, rapidOverride := 100.0, 100.0
+	if overrideValues != nil {
+		if overrideValues.Feed > 0 {
+			feedOverride = overrideValues.Feed
+		}
+		if overrideValues.Rapids > 0 {
+			rapidOverride = overrideValues.Rapids
+		}
+	}
+	p.ETA = p.Estimate.total.sub(done).duration(feedOverride, rapidOverride)
+}
+
+func (p StreamProgress) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Line %d, %d/%d lines acknowledged", p.Line, p.AcknowledgedLines, p.SentLines)
+	if p.Estimate != nil {
+		fmt.Fprintf(&b, ", %.1f%% bytes, %.1f%% time", p.BytesPercent, p.TimePercent)
+	}
+	fmt.Fprintf(&b, ", elapsed %s", p.Elapsed.Round(time.Second))
+	if p.Estimate != nil {
+		fmt.Fprintf(&b, ", ETA %s", p.ETA.Round(time.Second))
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+package grbl
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fornellas/cgs/gcode"
+)
+
+// testPlannerSettings has very high acceleration, so motion time is length / feed rate.
+var testPlannerSettings = gcode.PlannerSettings{
+	MaxRate:           gcode.Vector{X: 1200, Y: 1200, Z: 1200},
+	Acceleration:      gcode.Vector{X: 1e9, Y: 1e9, Z: 1e9},
+	JunctionDeviation: 0.01,
+	ArcTolerance:      0.002,
+}
+
+func TestStreamProgress(t *testing.T) {
+	program := "G1 X10 F600\n" +
+		"G0 X30\n" +
+		"G4 P2\n"
+	estimate, err := NewProgramEstimate(strings.NewReader(program), testPlannerSettings, gcode.NewMachine(gcode.MachineParameters{}, gcode.Vector{}))
+	require.NoError(t, err)
+	require.Equal(t, len("G1X10F600\nG0X30\nG4P2\n"), estimate.Bytes)
+	require.InDelta(t, float64(4*time.Second), float64(estimate.Total), float64(time.Millisecond))
+
+	for _, tc := range []struct {
+		name           string
+		line           uint
+		overrideValues *OverrideValues
+		timePercent    float64
+		eta            time.Duration
+	}{
+		{
+			name: "start",
+			eta:  4 * time.Second,
+		},
+		{
+			name:           "start with overrides",
+			overrideValues: &OverrideValues{Feed: 200, Rapids: 50, Spindle: 100},
+			eta:            500*time.Millisecond + 2*time.Second + 2*time.Second,
+		},
+		{
+			name:        "feed done",
+			line:        1,
+			timePercent: 25,
+			eta:         3 * time.Second,
+		},
+		{
+			name:        "done",
+			line:        3,
+			timePercent: 100,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			progress := StreamProgress{Line: tc.line, Estimate: estimate}
+			progress.update(tc.line, tc.overrideValues)
+			require.InDelta(t, tc.timePercent, progress.TimePercent, 0.01)
+			require.InDelta(t, float64(tc.eta), float64(progress.ETA), float64(time.Millisecond))
+		})
+	}
+}
@@ -0,0 +1,260 @@
+package grbl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fornellas/slogxt/log"
+
+	iFmt "github.com/fornellas/cgs/internal/fmt"
+)
+
+// CommandSender sends a command to Grbl and waits for its response message.
+type CommandSender interface {
+	SendCommand(ctx context.Context, command string) error
+}
+
+// CommandSenderFunc adapts a function to CommandSender.
+type CommandSenderFunc func(ctx context.Context, command string) error
+
+func (f CommandSenderFunc) SendCommand(ctx context.Context, command string) error {
+	return f(ctx, command)
+}
+
+// ToolChangeFn is called to change to given tool (nil if no tool was selected with T), using sender
+// to send commands to Grbl. It is called only after all previous commands were executed.
+type ToolChangeFn func(ctx context.Context, sender CommandSender, tool *float64) error
+
+// ToolLengthMode is how a tool length measured during a tool change is applied.
+type ToolLengthMode int
+
+const (
+	// Apply the tool length difference as a dynamic tool length offset (G43.1).
+	ToolLengthModeOffset ToolLengthMode = iota
+	// Apply the tool length difference to the Z of the active coordinate system (G10 L2).
+	ToolLengthModeZero
+)
+
+var toolLengthModeNames = map[ToolLengthMode]string{
+	ToolLengthModeOffset: "offset",
+	ToolLengthModeZero:   "zero",
+}
+
+func (m ToolLengthMode) String() string {
+	name, ok := toolLengthModeNames[m]
+	if !ok {
+		return fmt.Sprintf("unknown tool length mode (%d)", int(m))
+	}
+	return name
+}
+
+// NewToolLengthMode returns the ToolLengthMode for given name ("offset" or "zero").
+func NewToolLengthMode(name string) (ToolLengthMode, error) {
+	for mode, modeName := range toolLengthModeNames {
+		if modeName == name {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tool length mode: %#v", name)
+}
+
+// Tool change macro directives.
+const (
+	// Waits for operator confirmation, showing the rest of the line as a message.
+	ToolChangeDirectiveWait = "@wait"
+	// Probes the tool length with the probe command at the rest of the line, to be used as the
+	// reference for later tool length measurements. Skipped if a reference is already known.
+	ToolChangeDirectiveReference = "@reference"
+	// Probes the tool length with the probe command at the rest of the line, and applies the
+	// difference from the reference tool length.
+	ToolChangeDirectiveProbe = "@probe"
+)
+
+// ToolChangeMacroToolPlaceholder is replaced at macro lines by the tool number.
+const ToolChangeMacroToolPlaceholder = "{tool}"
+
+// ReadToolChangeMacro reads a tool change macro from r. Each line is either a command, sent as is,
+// or a directive (@wait, @reference or @probe). Empty lines and lines starting with "#" are
+// ignored.
+func ReadToolChangeMacro(r io.Reader) ([]string, error) {
+	macro := []string{}
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "@") {
+			directive, arg, _ := strings.Cut(line, " ")
+			switch directive {
+			case ToolChangeDirectiveWait:
+			case ToolChangeDirectiveReference, ToolChangeDirectiveProbe:
+				if strings.TrimSpace(arg) == "" {
+					return nil, fmt.Errorf("line %d: %s requires a probe command", lineNumber, directive)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unknown directive: %s", lineNumber, directive)
+			}
+		}
+		macro = append(macro, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return macro, nil
+}
+
+// ToolChange runs a tool change macro, such as moving to a tool change position, waiting for the
+// operator to change the tool and measuring its length with a tool setter. Tool lengths are
+// measured as the machine Z where the probe triggers, relative to the one of a reference tool:
+// the tool in use when the work coordinates were set. Probe positions and coordinate system offsets
+// are expected in millimeters ($13=0).
+type ToolChange struct {
+	grbl      *Grbl
+	macro     []string
+	mode      ToolLengthMode
+	confirmFn func(ctx context.Context, message string) error
+	// Machine Z where the probe triggers for the reference tool, or nil if not known.
+	referenceZ *float64
+}
+
+// NewToolChange creates a new ToolChange for given macro (see ReadToolChangeMacro). confirmFn is
+// called for @wait directives, and must return once the operator confirms, or an error to abort.
+func NewToolChange(
+	grbl *Grbl,
+	macro []string,
+	mode ToolLengthMode,
+	confirmFn func(ctx context.Context, message string) error,
+) *ToolChange {
+	return &ToolChange{
+		grbl:      grbl,
+		macro:     macro,
+		mode:      mode,
+		confirmFn: confirmFn,
+	}
+}
+
+// probe sends given probe command, returning the machine Z where the probe triggered.
+func (t *ToolChange) probe(ctx context.Context, sender CommandSender, command string) (float64, error) {
+	if err := sender.SendCommand(ctx, command); err != nil {
+		return 0, err
+	}
+	// Grbl reports the probe position before the response message.
+	probe := t.grbl.GetLastGcodeParameters().Probe
+	if probe == nil {
+		return 0, fmt.Errorf("tool change: %s: probe position not reported", command)
+	}
+	if !probe.Successful {
+		return 0, fmt.Errorf("tool change: %s: probe failed", command)
+	}
+	return probe.Coordinates.Z, nil
+}
+
+// applyToolLength applies the difference in tool length (in millimeters) from the reference tool.
+func (t *ToolChange) applyToolLength(ctx context.Context, sender CommandSender, delta float64) error {
+	if err := sender.SendCommand(ctx, GrblCommandViewGcodeParserState); err != nil {
+		return err
+	}
+	gcodeState := t.grbl.GetLastGcodeState()
+	if gcodeState == nil {
+		return fmt.Errorf("tool change: G-code parser state not reported")
+	}
+	modalGroup := gcodeState.ModalGroup
+	scale := 1.0
+	if modalGroup.Units.NormalizedString() == "G20" {
+		scale = 1 / 25.4
+	}
+
+	var command string
+	switch t.mode {
+	case ToolLengthModeOffset:
+		command = fmt.Sprintf("G43.1 Z%s", iFmt.SprintFloat(delta*scale, 4))
+	case ToolLengthModeZero:
+		if err := sender.SendCommand(ctx, GrblCommandViewGcodeParameters); err != nil {
+			return err
+		}
+		gcodeParameters := t.grbl.GetLastGcodeParameters()
+		coordinateSystems := []*Coordinates{
+			gcodeParameters.CoordinateSystem1,
+			gcodeParameters.CoordinateSystem2,
+			gcodeParameters.CoordinateSystem3,
+			gcodeParameters.CoordinateSystem4,
+			gcodeParameters.CoordinateSystem5,
+			gcodeParameters.CoordinateSystem6,
+		}
+		index := int(modalGroup.CoordinateSystemSelect.Number()) - 54
+		if index < 0 || index >= len(coordinateSystems) {
+			return fmt.Errorf("tool change: unexpected coordinate system: %s", modalGroup.CoordinateSystemSelect)
+		}
+		coordinateSystem := coordinateSystems[index]
+		if coordinateSystem == nil {
+			return fmt.Errorf("tool change: coordinate system %s not reported", modalGroup.CoordinateSystemSelect)
+		}
+		command = fmt.Sprintf("G10 L2 P%d Z%s", index+1, iFmt.SprintFloat((coordinateSystem.Z+delta)*scale, 4))
+	default:
+		panic(fmt.Sprintf("bug: unexpected tool length mode: %s", t.mode))
+	}
+	return sender.SendCommand(ctx, command)
+}
+
+// Run runs the tool change macro for given tool, sending commands with sender. It implements
+// ToolChangeFn.
+func (t *ToolChange) Run(ctx context.Context, sender CommandSender, tool *float64) error {
+	toolStr := ""
+	if tool != nil {
+		toolStr = iFmt.SprintFloat(*tool, 0)
+	}
+	ctx, logger := log.MustWithGroupAttrs(ctx, "Tool Change", "tool", toolStr)
+
+	logger.Info("Running")
+	for _, line := range t.macro {
+		line = strings.ReplaceAll(line, ToolChangeMacroToolPlaceholder, toolStr)
+		directive, arg, _ := strings.Cut(line, " ")
+		arg = strings.TrimSpace(arg)
+		switch directive {
+		case ToolChangeDirectiveWait:
+			logger.Info("Waiting for confirmation", "message", arg)
+			if err := t.confirmFn(ctx, arg); err != nil {
+				return fmt.Errorf("tool change: %w", err)
+			}
+		case ToolChangeDirectiveReference:
+			if t.referenceZ != nil {
+				continue
+			}
+			z, err := t.probe(ctx, sender, arg)
+			if err != nil {
+				return err
+			}
+			logger.Info("Reference tool measured", "z", z)
+			t.referenceZ = &z
+		case ToolChangeDirectiveProbe:
+			z, err := t.probe(ctx, sender, arg)
+			if err != nil {
+				return err
+			}
+			if t.referenceZ == nil {
+				return fmt.Errorf("tool change: %s: reference tool length unknown: %s must be used before", line, ToolChangeDirectiveReference)
+			}
+			delta := z - *t.referenceZ
+			logger.Info("Tool measured", "z", z, "delta", delta)
+			if err := t.applyToolLength(ctx, sender, delta); err != nil {
+				return err
+			}
+			if t.mode == ToolLengthModeZero {
+				// Work coordinates now match the new tool.
+				t.referenceZ = &z
+			}
+		default:
+			if err := sender.SendCommand(ctx, line); err != nil {
+				return err
+			}
+		}
+	}
+	logger.Info("Complete")
+	return nil
+}
@@ -14,5 +14,9 @@ func SprintFloat(value float64, decimal uint) string {
 	} else {
 		floatStr = fmt.Sprintf("%.0f", value)
 	}
+	// Negative values that round to zero, common after rotating or mirroring, must not print as -0.
+	if floatStr == "-0" {
+		return "0"
+	}
 	return floatStr
 }
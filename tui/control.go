@@ -20,6 +20,10 @@ import (
 type TuiOptions struct {
 	DisplayStatusComms bool
 	AppLogger          *slog.Logger
+	// Tool change (M6) macro, see grblMod.ReadToolChangeMacro. Tool changes are not supported when
+	// nil.
+	ToolChangeMacro []string
+	ToolLengthMode  grblMod.ToolLengthMode
 }
 
 type Tui struct {
@@ -118,6 +122,8 @@ func (t *Tui) Run(ctx context.Context) (err error) {
 	controlPrimitive := NewControlPrimitive(
 		appCtx, t.grbl, app, stateTracker,
 		!t.options.DisplayStatusComms,
+		t.options.ToolChangeMacro,
+		t.options.ToolLengthMode,
 	)
 	workerManager.AddWorker("ControlPrimitive.Worker", func(ctx context.Context) error {
 		return controlPrimitive.Worker(
@@ -164,10 +170,11 @@ func (t *Tui) Run(ctx context.Context) (err error) {
 			stateTracker.Subscribe("HeightMapPrimitive", subscriberChSize),
 		)
 	})
-	streamPrimitive := NewStreamPrimitive(appCtx, app, controlPrimitive, heightMapPrimitive)
+	streamPrimitive := NewStreamPrimitive(appCtx, app, t.grbl, controlPrimitive, heightMapPrimitive)
 	workerManager.AddWorker("StreamPrimitive", func(ctx context.Context) error {
 		return streamPrimitive.Worker(
 			ctx,
+			pushMessageBroker.Subscribe("StreamPrimitive", subscriberChSize),
 			stateTracker.Subscribe("StreamPrimitive", subscriberChSize),
 		)
 	})
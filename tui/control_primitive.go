@@ -182,6 +182,10 @@ type ControlPrimitive struct {
 	commandInputHistory    []string
 	commandInputHistoryIdx int
 
+	toolChange              *grblMod.ToolChange
+	toolChangeConfirmButton *tview.Button
+	toolChangeConfirmCh     chan struct{}
+
 	disableCommandInput bool
 
 	state grblMod.State
@@ -200,6 +204,8 @@ func NewControlPrimitive(
 	app *tview.Application,
 	stateTracker *StateTracker,
 	quietStatusComms bool,
+	toolChangeMacro []string,
+	toolLengthMode grblMod.ToolLengthMode,
 ) *ControlPrimitive {
 	cp := &ControlPrimitive{
 		grbl:                   grbl,
@@ -210,8 +216,12 @@ func NewControlPrimitive(
 		sendCommandCh:          make(chan *queuedCommandType, 10),
 		sendRealTimeCommandCh:  make(chan grblMod.RealTimeCommand, 10),
 		commandInputHistoryIdx: -1,
+		toolChangeConfirmCh:    make(chan struct{}, 1),
 		state:                  grblMod.StateUnknown,
 	}
+	if toolChangeMacro != nil {
+		cp.toolChange = grblMod.NewToolChange(grbl, toolChangeMacro, toolLengthMode, cp.confirmToolChange)
+	}
 
 	cp.newGcodeParser()
 	cp.newGcodeParams()
@@ -978,6 +988,17 @@ func (cp *ControlPrimitive) newCommand() {
 		return event
 	})
 	cp.commandInputField = commandInputField
+
+	toolChangeConfirmButton := tview.NewButton("Continue")
+	toolChangeConfirmButton.SetSelectedFunc(func() {
+		select {
+		case cp.toolChangeConfirmCh <- struct{}{}:
+		default:
+		}
+		toolChangeConfirmButton.SetDisabled(true)
+	})
+	toolChangeConfirmButton.SetDisabled(true)
+	cp.toolChangeConfirmButton = toolChangeConfirmButton
 }
 
 func (cp *ControlPrimitive) newControl() {
@@ -991,13 +1012,18 @@ func (cp *ControlPrimitive) newControl() {
 	commsFlex.AddItem(cp.commandsTextView, 0, 1, false)
 	commsFlex.AddItem(cp.pushMessagesTextView, 0, 1, false)
 
+	commandFlex := tview.NewFlex()
+	commandFlex.SetDirection(tview.FlexColumn)
+	commandFlex.AddItem(cp.commandInputField, 0, 1, true)
+	commandFlex.AddItem(cp.toolChangeConfirmButton, len("Continue")+2, 0, false)
+
 	controlFlex := tview.NewFlex()
 	controlFlex.SetBorder(true)
 	controlFlex.SetTitle("Contrtol")
 	controlFlex.SetDirection(tview.FlexRow)
 	controlFlex.AddItem(gcodeFlex, 20, 0, false)
 	controlFlex.AddItem(commsFlex, 0, 1, false)
-	controlFlex.AddItem(cp.commandInputField, 1, 0, true)
+	controlFlex.AddItem(commandFlex, 1, 0, true)
 	cp.Flex = controlFlex
 }
 
@@ -1177,10 +1203,11 @@ func (cp *ControlPrimitive) getStatusCmdsAndTimeout(block *gcode.Block) (map[str
 	statusCommands := map[string]bool{}
 	var timeout *time.Duration
 	if block.IsSystem() {
-		if _, ok := allStatusCommands[block.String()]; ok && cp.quietStatusComms {
-			fmt.Fprintf(cp.pushMessagesTextView, "\n[%s](push messages from %s omitted, results at Control panel)[-]", tcell.ColorYellow, block.String())
+		system := block.StringWithoutComments()
+		if _, ok := allStatusCommands[system]; ok && cp.quietStatusComms {
+			fmt.Fprintf(cp.pushMessagesTextView, "\n[%s](push messages from %s omitted, results at Control panel)[-]", tcell.ColorYellow, system)
 		}
-		switch block.String() {
+		switch system {
 		case grblMod.GrblCommandRestoreGcodeParametersToDefaults:
 			statusCommands[grblMod.GrblCommandViewGcodeParameters] = true
 		case grblMod.GrblCommandRestoreAllToDefaults:
@@ -1191,21 +1218,21 @@ func (cp *ControlPrimitive) getStatusCmdsAndTimeout(block *gcode.Block) (map[str
 		case grblMod.GrblCommandRestoreGrblSettingsToDefaults:
 			statusCommands[grblMod.GrblCommandViewStartupBlocks] = true
 		}
-		if strings.HasPrefix(block.String(), grblMod.GrblCommandRunHomingCyclePrefix) {
+		if strings.HasPrefix(system, grblMod.GrblCommandRunHomingCyclePrefix) {
 			timeout = &homeCommandTimeout
 			cp.stateTracker.HomeOverride(true)
 		}
-		matched, err := regexp.MatchString(`^\$[0-9]+=`, block.String())
+		matched, err := regexp.MatchString(`^\$[0-9]+=`, system)
 		if err != nil {
 			panic(err)
 		}
 		if matched {
 			statusCommands[grblMod.GrblCommandViewGrblSettings] = true
 		}
-		if strings.HasPrefix(block.String(), grblMod.GrblCommandWriteBuildInfoPrefix) {
+		if strings.HasPrefix(system, grblMod.GrblCommandWriteBuildInfoPrefix) {
 			statusCommands[grblMod.GrblCommandViewBuildInfo] = true
 		}
-		if strings.HasPrefix(block.String(), grblMod.GrblCommandSaveStartupBlockPrefix) {
+		if strings.HasPrefix(system, grblMod.GrblCommandSaveStartupBlockPrefix) {
 			statusCommands[grblMod.GrblCommandViewStartupBlocks] = true
 		}
 	} else if block.IsCommand() {
@@ -1249,6 +1276,9 @@ func (cp *ControlPrimitive) processCommand(ctx context.Context, command string)
 	if len(blocks) > 1 {
 		panic("bug: expected single block")
 	}
+	if len(blocks) > 0 && isToolChange(blocks[0]) {
+		return cp.processToolChange(ctx, blocks[0])
+	}
 
 	commandParameter := &commandParameterType{
 		command: command,
@@ -1268,6 +1298,11 @@ func (cp *ControlPrimitive) processCommand(ctx context.Context, command string)
 	defer cp.DisableCommandInput(false)
 
 	err = cp.sendCommand(ctx, commandParameter)
+	if err == nil {
+		for _, message := range commandMessages(command) {
+			fmt.Fprintf(cp.commandsTextView, "\n[%s]MSG: %s[-]", tcell.ColorYellow, tview.Escape(message))
+		}
+	}
 	if len(statusCommands) > 0 {
 		cp.sendCommand(ctx, syncCommand)
 	}
@@ -1282,6 +1317,118 @@ func (cp *ControlPrimitive) processCommand(ctx context.Context, command string)
 	return err
 }
 
+// commandMessages returns the messages from "(MSG, ...)" comments of command, including lines
+// with comments only.
+func commandMessages(command string) []string {
+	var messages []string
+	parser := gcode.NewParser(strings.NewReader(command))
+	for {
+		eof, _, tokens, err := parser.Next()
+		if err != nil {
+			return messages
+		}
+		for _, comment := range tokens.Comments() {
+			if message, ok := comment.Message(); ok {
+				messages = append(messages, message)
+			}
+		}
+		if eof {
+			return messages
+		}
+	}
+}
+
+// isToolChange returns whether block has a tool change command (M6).
+func isToolChange(block *gcode.Block) bool {
+	if !block.IsCommand() {
+		return false
+	}
+	for _, word := range block.Commands() {
+		if word.NormalizedString() == "M6" {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmToolChange shows message and waits for the operator to press the continue button.
+func (cp *ControlPrimitive) confirmToolChange(ctx context.Context, message string) error {
+	select {
+	case <-cp.toolChangeConfirmCh:
+	default:
+	}
+	cp.app.QueueUpdateDraw(func() {
+		fmt.Fprintf(cp.commandsTextView, "\n[%s]%s[-]", tcell.ColorYellow, tview.Escape(message))
+		cp.toolChangeConfirmButton.SetDisabled(false)
+		cp.app.SetFocus(cp.toolChangeConfirmButton)
+	})
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-cp.toolChangeConfirmCh:
+		return nil
+	}
+}
+
+// processToolChange sends block without the tool change command (M6), then runs the tool change
+// macro.
+func (cp *ControlPrimitive) processToolChange(ctx context.Context, block *gcode.Block) error {
+	if cp.toolChange == nil {
+		err := errors.New("tool change (M6) requires a tool change macro")
+		fmt.Fprintf(cp.commandsTextView, "\n[%s]%s[-]", tcell.ColorRed, tview.Escape(err.Error()))
+		return err
+	}
+
+	tool, err := block.GetArgumentNumber('T')
+	if err != nil {
+		fmt.Fprintf(cp.commandsTextView, "\n[%s]%s[-]", tcell.ColorRed, tview.Escape(err.Error()))
+		return err
+	}
+	if tool == nil {
+		if gcodeState := cp.grbl.GetLastGcodeState(); gcodeState != nil {
+			tool = gcodeState.Tool
+		}
+	}
+
+	cp.DisableCommandInput(true)
+	defer cp.DisableCommandInput(false)
+
+	words := []*gcode.Word{}
+	for _, word := range block.Words() {
+		if word.NormalizedString() != "M6" {
+			words = append(words, word)
+		}
+	}
+	if len(words) > 0 {
+		if err := cp.sendCommand(ctx, &commandParameterType{
+			command: gcode.NewBlockCommand(words...).String(),
+			timeout: defaultCommandTimeout,
+		}); err != nil {
+			return err
+		}
+	}
+
+	err = cp.toolChange.Run(ctx, grblMod.CommandSenderFunc(func(ctx context.Context, command string) error {
+		return cp.sendCommand(ctx, &commandParameterType{command: command})
+	}), tool)
+	if err != nil {
+		fmt.Fprintf(cp.commandsTextView, "\n[%s]Tool change failed: %s[-]", tcell.ColorRed, tview.Escape(err.Error()))
+	}
+	cp.app.QueueUpdateDraw(func() { cp.toolChangeConfirmButton.SetDisabled(true) })
+
+	for _, command := range []string{
+		grblMod.GrblCommandViewGcodeParserState,
+		grblMod.GrblCommandViewGcodeParameters,
+	} {
+		cp.sendCommand(ctx, &commandParameterType{
+			command: command,
+			timeout: defaultCommandTimeout,
+			quiet:   cp.quietStatusComms,
+		})
+	}
+	return err
+}
+
 func (cp *ControlPrimitive) queueStatusCommand(command string) {
 	cp.sendStatusCommandCh <- command
 }
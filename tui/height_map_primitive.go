@@ -2,7 +2,9 @@ package tui
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 
 	"github.com/gdamore/tcell/v2"
@@ -29,6 +31,8 @@ type HeightMapPrimitive struct {
 	maxZDeviationInputField *tview.InputField
 	probeFeedRateInputField *tview.InputField
 	probeButton             *tview.Button
+	savePathInputField      *tview.InputField
+	saveButton              *tview.Button
 	statusTextView          *tview.TextView
 	heightMapTable          *tview.Table
 	heightMapTableCellMap   map[float64]map[float64]*tview.TableCell
@@ -100,6 +104,19 @@ func NewHeightMapPrimitive(
 		go hm.probe()
 	})
 
+	hm.savePathInputField = tview.NewInputField()
+	hm.savePathInputField.SetLabel("Save path")
+
+	hm.saveButton = tview.NewButton("Save")
+	hm.saveButton.SetSelectedFunc(func() {
+		hm.save()
+	})
+
+	saveFlex := tview.NewFlex()
+	saveFlex.SetDirection(tview.FlexColumn)
+	saveFlex.AddItem(hm.savePathInputField, 0, 1, false)
+	saveFlex.AddItem(hm.saveButton, 6, 0, false)
+
 	hm.statusTextView = tview.NewTextView()
 	hm.statusTextView.SetDynamicColors(true)
 
@@ -118,6 +135,7 @@ func NewHeightMapPrimitive(
 	rootFlex.AddItem(hm.maxZDeviationInputField, 1, 0, false)
 	rootFlex.AddItem(hm.probeFeedRateInputField, 1, 0, false)
 	rootFlex.AddItem(hm.probeButton, 3, 0, false)
+	rootFlex.AddItem(saveFlex, 1, 0, false)
 	rootFlex.AddItem(hm.statusTextView, 1, 0, false)
 	rootFlex.AddItem(hm.heightMapTable, 0, 1, false)
 
@@ -402,6 +420,33 @@ func (hm *HeightMapPrimitive) probe() {
 	})
 }
 
+// save writes the height map to the save path, so it can be used later (eg: cgs level).
+func (hm *HeightMapPrimitive) save() {
+	if hm.heightMap == nil {
+		hm.statusTextView.SetText(fmt.Sprintf("[%s]No height map to save[-]", tcell.ColorRed))
+		return
+	}
+
+	path := hm.savePathInputField.GetText()
+	if path == "" {
+		hm.statusTextView.SetText(fmt.Sprintf("[%s]Save path is empty[-]", tcell.ColorRed))
+		return
+	}
+
+	data, err := json.Marshal(hm.heightMap)
+	if err != nil {
+		hm.statusTextView.SetText(fmt.Sprintf("[%s]%s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+		return
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		hm.statusTextView.SetText(fmt.Sprintf("[%s]%s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+		return
+	}
+
+	hm.statusTextView.SetText(fmt.Sprintf("[%s]Saved[-]", tcell.ColorGreen))
+}
+
 func (hm *HeightMapPrimitive) Worker(
 	ctx context.Context,
 	trackedStateCh <-chan *TrackedState,
@@ -1,33 +1,174 @@
 package tui
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
+
+	"github.com/fornellas/cgs/gcode"
+	grblMod "github.com/fornellas/cgs/grbl"
 )
 
 type StreamPrimitive struct {
 	*tview.Flex
+	ctx              context.Context
 	app              *tview.Application
+	grbl             *grblMod.Grbl
 	controlPrimitive *ControlPrimitive
+
+	pathInputField      *tview.InputField
+	loadButton          *tview.Button
+	startLineInputField *tview.InputField
+	errorPolicyDropDown *tview.DropDown
+	streamButton        *tview.Button
+	fileTextView        *tview.TextView
+	progressTextView    *tview.TextView
+	pauseButton         *tview.Button
+	resumeButton        *tview.Button
+	abortButton         *tview.Button
+	// controller of the program being streamed, nil when not streaming.
+	controller        *grblMod.StreamController
+	toolpathPrimitive *ToolpathPrimitive
 }
 
 func NewStreamPrimitive(
 	ctx context.Context,
 	app *tview.Application,
+	grbl *grblMod.Grbl,
 	controlPrimitive *ControlPrimitive,
 	heightMapPrimitive *HeightMapPrimitive,
 ) *StreamPrimitive {
 	sp := &StreamPrimitive{
+		ctx:              ctx,
 		app:              app,
+		grbl:             grbl,
 		controlPrimitive: controlPrimitive,
 	}
 
 	// File
+	sp.pathInputField = tview.NewInputField()
+	sp.pathInputField.SetLabel("Path")
+	sp.pathInputField.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			go sp.load(sp.pathInputField.GetText())
+		}
+	})
+
+	sp.loadButton = tview.NewButton("Load")
+	sp.loadButton.SetSelectedFunc(func() {
+		go sp.load(sp.pathInputField.GetText())
+	})
+
+	sp.startLineInputField = tview.NewInputField()
+	sp.startLineInputField.SetLabel("Start at line")
+	sp.startLineInputField.SetText("1")
+	sp.startLineInputField.SetAcceptanceFunc(tview.InputFieldInteger)
+
+	errorPolicyOptions := []string{}
+	for _, policy := range []grblMod.ResponseErrorPolicy{
+		grblMod.ResponseErrorPolicyAbort,
+		grblMod.ResponseErrorPolicyPause,
+		grblMod.ResponseErrorPolicyContinue,
+	} {
+		errorPolicyOptions = append(errorPolicyOptions, policy.String())
+	}
+	sp.errorPolicyDropDown = tview.NewDropDown()
+	sp.errorPolicyDropDown.SetLabel("On error")
+	sp.errorPolicyDropDown.SetOptions(errorPolicyOptions, nil)
+	sp.errorPolicyDropDown.SetCurrentOption(0)
+
+	sp.streamButton = tview.NewButton("Stream")
+	sp.streamButton.SetSelectedFunc(func() {
+		line, err := strconv.ParseUint(sp.startLineInputField.GetText(), 10, 0)
+		if err != nil || line == 0 {
+			sp.fileTextView.SetText(fmt.Sprintf("[%s]Invalid start line[-]", tcell.ColorRed))
+			return
+		}
+		_, policyName := sp.errorPolicyDropDown.GetCurrentOption()
+		errorPolicy, err := grblMod.NewResponseErrorPolicy(policyName)
+		if err != nil {
+			sp.fileTextView.SetText(fmt.Sprintf("[%s]%s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+			return
+		}
+		sp.controller = grblMod.NewStreamController(sp.grbl)
+		sp.setStreaming(true)
+		go sp.stream(sp.pathInputField.GetText(), uint(line), errorPolicy, sp.controller)
+	})
+
+	pathFlex := tview.NewFlex()
+	pathFlex.SetDirection(tview.FlexColumn)
+	pathFlex.AddItem(sp.pathInputField, 0, 1, false)
+	pathFlex.AddItem(sp.loadButton, 6, 0, false)
+	pathFlex.AddItem(nil, 1, 0, false)
+	pathFlex.AddItem(sp.startLineInputField, 21, 0, false)
+	pathFlex.AddItem(nil, 1, 0, false)
+	pathFlex.AddItem(sp.errorPolicyDropDown, 18, 0, false)
+	pathFlex.AddItem(sp.streamButton, 8, 0, false)
+
+	sp.fileTextView = tview.NewTextView()
+	sp.fileTextView.SetDynamicColors(true)
+
 	fileFlex := tview.NewFlex()
 	fileFlex.SetBorder(true)
 	fileFlex.SetTitle("File")
+	fileFlex.SetDirection(tview.FlexRow)
+	fileFlex.AddItem(pathFlex, 1, 0, false)
+	fileFlex.AddItem(sp.fileTextView, 1, 0, false)
+
+	sp.progressTextView = tview.NewTextView()
+
+	sp.pauseButton = tview.NewButton("Pause")
+	sp.pauseButton.SetSelectedFunc(func() {
+		if sp.controller == nil {
+			return
+		}
+		if err := sp.controller.Pause(); err != nil {
+			sp.fileTextView.SetText(fmt.Sprintf("[%s]Pause failed: %s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+		}
+	})
+
+	sp.resumeButton = tview.NewButton("Resume")
+	sp.resumeButton.SetSelectedFunc(func() {
+		if sp.controller == nil {
+			return
+		}
+		if err := sp.controller.Resume(); err != nil {
+			sp.fileTextView.SetText(fmt.Sprintf("[%s]Resume failed: %s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+		}
+	})
+
+	sp.abortButton = tview.NewButton("Abort")
+	sp.abortButton.SetSelectedFunc(func() {
+		if sp.controller == nil {
+			return
+		}
+		sp.abortButton.SetDisabled(true)
+		go sp.abort(sp.controller)
+	})
+
+	progressFlex := tview.NewFlex()
+	progressFlex.SetDirection(tview.FlexColumn)
+	progressFlex.AddItem(sp.progressTextView, 0, 1, false)
+	progressFlex.AddItem(sp.pauseButton, 7, 0, false)
+	progressFlex.AddItem(nil, 1, 0, false)
+	progressFlex.AddItem(sp.resumeButton, 8, 0, false)
+	progressFlex.AddItem(nil, 1, 0, false)
+	progressFlex.AddItem(sp.abortButton, 7, 0, false)
+	fileFlex.AddItem(progressFlex, 1, 0, false)
+
+	sp.setStreaming(false)
+
+	// Toolpath
+	sp.toolpathPrimitive = NewToolpathPrimitive()
+	sp.toolpathPrimitive.SetBorder(true)
+	sp.toolpathPrimitive.SetTitle("Toolpath")
 
 	// Rotation
 	rotationFlex := tview.NewFlex()
@@ -38,7 +179,8 @@ func NewStreamPrimitive(
 	streamRootFlex.SetBorder(true)
 	streamRootFlex.SetTitle("Stream")
 	streamRootFlex.SetDirection(tview.FlexRow)
-	streamRootFlex.AddItem(fileFlex, 3, 0, false)
+	streamRootFlex.AddItem(fileFlex, 5, 0, false)
+	streamRootFlex.AddItem(sp.toolpathPrimitive, 0, 2, false)
 	streamRootFlex.AddItem(heightMapPrimitive, 0, 1, false)
 	streamRootFlex.AddItem(rotationFlex, 3, 0, false)
 	sp.Flex = streamRootFlex
@@ -48,13 +190,208 @@ func NewStreamPrimitive(
 	return sp
 }
 
+// readSegments returns the segments of the program at path, in work coordinates.
+func readSegments(path string) (segments []gcode.Segment, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+
+	parser := gcode.NewParser(f)
+	machine := gcode.NewMachineAtUnknownPosition(gcode.MachineParameters{})
+	for {
+		eof, _, blockSegments, err := machine.Next(parser)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, blockSegments...)
+		if eof {
+			return segments, nil
+		}
+	}
+}
+
+// load loads the program at path for preview.
+func (sp *StreamPrimitive) load(path string) {
+	segments, err := readSegments(path)
+	sp.app.QueueUpdateDraw(func() {
+		if err != nil {
+			sp.fileTextView.SetText(fmt.Sprintf("[%s]%s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+			return
+		}
+		sp.toolpathPrimitive.SetSegments(segments)
+		sp.fileTextView.SetText(fmt.Sprintf("Loaded %s: %d motion segments", tview.Escape(path), len(segments)))
+	})
+}
+
+// stream streams the program at path to Grbl, starting at given line.
+func (sp *StreamPrimitive) stream(
+	path string,
+	line uint,
+	errorPolicy grblMod.ResponseErrorPolicy,
+	controller *grblMod.StreamController,
+) {
+	sp.app.QueueUpdateDraw(func() {
+		sp.fileTextView.SetText(fmt.Sprintf("Streaming %s from line %d...", tview.Escape(path), line))
+		sp.progressTextView.Clear()
+	})
+	err := sp.streamProgram(path, line, errorPolicy, controller)
+	sp.app.QueueUpdateDraw(func() {
+		sp.controller = nil
+		sp.setStreaming(false)
+		if errors.Is(err, grblMod.ErrStreamAborted) {
+			return
+		}
+		if err != nil {
+			sp.fileTextView.SetText(fmt.Sprintf("[%s]%s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+			return
+		}
+		sp.fileTextView.SetText(fmt.Sprintf("Streamed %s", tview.Escape(path)))
+	})
+}
+
+// estimateProgram returns the estimate for program, from the machine position at the last status
+// report, or nil if Grbl settings required for it are unknown.
+func (sp *StreamPrimitive) estimateProgram(program []byte) (*grblMod.ProgramEstimate, error) {
+	plannerSettings, err := sp.grbl.GetLastSettings().PlannerSettings()
+	if err != nil {
+		return nil, nil
+	}
+	var parameters gcode.MachineParameters
+	if gcodeParameters := sp.grbl.GetLastGcodeParameters(); gcodeParameters != nil {
+		parameters = gcodeParameters.MachineParameters()
+	}
+	position := gcode.Vector{}
+	if statusReport := sp.grbl.GetLastStatusReport(); statusReport != nil {
+		if coordinates := statusReport.GetMachineCoordinates(sp.grbl); coordinates != nil {
+			position = gcode.Vector{X: coordinates.X, Y: coordinates.Y, Z: coordinates.Z}
+		}
+	}
+	return grblMod.NewProgramEstimate(bytes.NewReader(program), plannerSettings, gcode.NewMachine(parameters, position))
+}
+
+// resumeProgram returns the program at path, starting at given line, with a preamble that restores
+// the modal state and position at that line, and the function that maps its lines to lines at path.
+func resumeProgram(path string, line uint) (program []byte, sourceLineFn func(uint) uint, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+
+	options := gcode.DefaultResumeOptions
+	options.Line = line
+	resume := gcode.NewResume(gcode.NewParser(f), options)
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(gcode.NewTransformReader(resume)); err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), resume.SourceLine, nil
+}
+
+// setStreaming enables the stream button or the stream control buttons.
+func (sp *StreamPrimitive) setStreaming(streaming bool) {
+	sp.streamButton.SetDisabled(streaming)
+	sp.pauseButton.SetDisabled(!streaming)
+	sp.resumeButton.SetDisabled(!streaming)
+	sp.abortButton.SetDisabled(!streaming)
+}
+
+// abort aborts the program being streamed, and shows up to which line it was queued at Grbl.
+func (sp *StreamPrimitive) abort(controller *grblMod.StreamController) {
+	sp.app.QueueUpdateDraw(func() {
+		sp.fileTextView.SetText("Aborting...")
+	})
+	report, err := controller.Abort(sp.ctx)
+	sp.app.QueueUpdateDraw(func() {
+		if err != nil {
+			sp.fileTextView.SetText(fmt.Sprintf("[%s]Abort failed: %s[-]", tcell.ColorRed, tview.Escape(err.Error())))
+			return
+		}
+		text := fmt.Sprintf("Aborted: lines up to %d were queued, not necessarily executed", report.LastQueuedLine)
+		if report.LineNumber != nil {
+			text += fmt.Sprintf(", executing N%d", *report.LineNumber)
+		}
+		sp.fileTextView.SetText(fmt.Sprintf("[%s]%s[-]", tcell.ColorYellow, text))
+	})
+}
+
+// streamProgram streams the program at path to Grbl, starting at given line, showing its progress.
+// Error responses are handled according to errorPolicy, pausing for the operator at the control
+// primitive.
+func (sp *StreamPrimitive) streamProgram(
+	path string,
+	line uint,
+	errorPolicy grblMod.ResponseErrorPolicy,
+	controller *grblMod.StreamController,
+) error {
+	program, sourceLineFn, err := resumeProgram(path, line)
+	if err != nil {
+		return err
+	}
+
+	estimate, err := sp.estimateProgram(program)
+	if err != nil {
+		return err
+	}
+
+	var toolChangeFn grblMod.ToolChangeFn
+	if sp.controlPrimitive.toolChange != nil {
+		toolChangeFn = sp.controlPrimitive.toolChange.Run
+	}
+
+	var lastProgress grblMod.StreamProgress
+	var lastUpdate time.Time
+	updateProgress := func() {
+		text := tview.Escape(lastProgress.String())
+		sp.app.QueueUpdateDraw(func() {
+			sp.progressTextView.SetText(text)
+		})
+	}
+	defer updateProgress()
+
+	return sp.grbl.StreamProgram(sp.ctx, bytes.NewReader(program), grblMod.StreamOptions{
+		ToolChangeFn: toolChangeFn,
+		ErrorPolicy:  errorPolicy,
+		ConfirmFn:    sp.controlPrimitive.confirmToolChange,
+		Estimate:     estimate,
+		Controller:   controller,
+		SourceLineFn: sourceLineFn,
+		ProgressFn: func(progress grblMod.StreamProgress) {
+			lastProgress = progress
+			if time.Since(lastUpdate) < 250*time.Millisecond {
+				return
+			}
+			lastUpdate = time.Now()
+			updateProgress()
+		},
+	})
+}
+
 func (sp *StreamPrimitive) Worker(
-	ctx context.Context, trackedStateCh <-chan *TrackedState,
+	ctx context.Context,
+	pushMessageCh <-chan grblMod.PushMessage,
+	trackedStateCh <-chan *TrackedState,
 ) error {
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case pushMessage, ok := <-pushMessageCh:
+			if !ok {
+				return fmt.Errorf("push message channel closed")
+			}
+			if statusReportPushMessage, ok := pushMessage.(*grblMod.StatusReportPushMessage); ok {
+				workCoordinates := statusReportPushMessage.GetWorkCoordinates(sp.grbl)
+				if workCoordinates == nil {
+					continue
+				}
+				position := gcode.Vector{X: workCoordinates.X, Y: workCoordinates.Y, Z: workCoordinates.Z}
+				sp.app.QueueUpdateDraw(func() {
+					sp.toolpathPrimitive.SetPosition(position)
+				})
+			}
 		case _, ok := <-trackedStateCh:
 			if !ok {
 				return fmt.Errorf("tracked state channel closed")
@@ -0,0 +1,501 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+
+	"github.com/fornellas/cgs/gcode"
+	iFmt "github.com/fornellas/cgs/internal/fmt"
+)
+
+// toolpathTolerance is the chordal tolerance for drawing arcs, in millimeters.
+const toolpathTolerance = 0.01
+
+// toolpathPositionTolerance is the maximum distance from the tool position to a segment for it to
+// be considered the segment being executed, in millimeters.
+const toolpathPositionTolerance = 0.05
+
+// toolpathSearchSegments is the number of segments ahead of the last executed one that are
+// searched for the tool position.
+const toolpathSearchSegments = 1000
+
+// toolpathSideViewHeight is the height of the Z side view, in rows.
+const toolpathSideViewHeight = 5
+
+// toolpathZoomFactor is the zoom change for each zoom step.
+const toolpathZoomFactor = 1.5
+
+var toolpathRapidColor = tcell.ColorGray
+var toolpathCutColor = tcell.ColorWhite
+var toolpathExecutedColor = tcell.ColorGreen
+var toolpathToolColor = tcell.ColorRed
+
+// toolpathSegment is a motion from the program, in work coordinates.
+type toolpathSegment struct {
+	rapid bool
+	// points from the start to the end of the segment.
+	points []gcode.Vector
+}
+
+// distance returns the distance from v to the segment.
+func (s *toolpathSegment) distance(v gcode.Vector) float64 {
+	distance := math.Inf(1)
+	for i := 1; i < len(s.points); i++ {
+		a, b := s.points[i-1], s.points[i]
+		ab := b.Sub(a)
+		t := 0.0
+		if length := ab.X*ab.X + ab.Y*ab.Y + ab.Z*ab.Z; length > 0 {
+			av := v.Sub(a)
+			t = math.Max(0, math.Min(1, (av.X*ab.X+av.Y*ab.Y+av.Z*ab.Z)/length))
+		}
+		closest := a.Add(gcode.Vector{X: ab.X * t, Y: ab.Y * t, Z: ab.Z * t})
+		distance = math.Min(distance, v.Sub(closest).Length())
+	}
+	return distance
+}
+
+// brailleCanvas draws lines using braille characters, each with 2x4 dots.
+type brailleCanvas struct {
+	columns int
+	rows    int
+	dots    []rune
+	colors  []tcell.Color
+	// priorities for colors of each cell: the color drawn with the highest priority is kept.
+	priorities []int
+}
+
+func newBrailleCanvas(columns, rows int) *brailleCanvas {
+	return &brailleCanvas{
+		columns:    columns,
+		rows:       rows,
+		dots:       make([]rune, columns*rows),
+		colors:     make([]tcell.Color, columns*rows),
+		priorities: make([]int, columns*rows),
+	}
+}
+
+// brailleDots maps dot positions within a cell to braille bits.
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// width returns the width in dots.
+func (c *brailleCanvas) width() int {
+	return c.columns * 2
+}
+
+// height returns the height in dots.
+func (c *brailleCanvas) height() int {
+	return c.rows * 4
+}
+
+func (c *brailleCanvas) set(x, y int, color tcell.Color, priority int) {
+	if x < 0 || y < 0 || x >= c.width() || y >= c.height() {
+		return
+	}
+	i := y/4*c.columns + x/2
+	c.dots[i] |= brailleDots[y%4][x%2]
+	if priority >= c.priorities[i] {
+		c.colors[i] = color
+		c.priorities[i] = priority
+	}
+}
+
+// clipLine clips the line to the canvas with the Liang-Barsky algorithm. Returns false when the
+// line is outside the canvas.
+func (c *brailleCanvas) clipLine(x0, y0, x1, y1 float64) (float64, float64, float64, float64, bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := x1-x0, y1-y0
+	for _, edge := range [4][2]float64{
+		{-dx, x0},
+		{dx, float64(c.width()-1) - x0},
+		{-dy, y0},
+		{dy, float64(c.height()-1) - y0},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return 0, 0, 0, 0, false
+		}
+	}
+	return x0 + t0*dx, y0 + t0*dy, x0 + t1*dx, y0 + t1*dy, true
+}
+
+// line draws a line between given dot coordinates.
+func (c *brailleCanvas) line(x0, y0, x1, y1 float64, color tcell.Color, priority int) {
+	x0, y0, x1, y1, ok := c.clipLine(x0, y0, x1, y1)
+	if !ok {
+		return
+	}
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		c.set(int(math.Round(x0+(x1-x0)*t)), int(math.Round(y0+(y1-y0)*t)), color, priority)
+	}
+}
+
+// draw draws the canvas at the screen.
+func (c *brailleCanvas) draw(screen tcell.Screen, x, y int, style tcell.Style) {
+	for row := range c.rows {
+		for column := range c.columns {
+			i := row*c.columns + column
+			if c.dots[i] == 0 {
+				continue
+			}
+			screen.SetContent(x+column, y+row, 0x2800+c.dots[i], nil, style.Foreground(c.colors[i]))
+		}
+	}
+}
+
+// ToolpathPrimitive previews the XY toolpath of a program with a Z side view, along with the tool
+// position. Segments up to the one at the tool position are shown as executed. Zoom with +/- or
+// the mouse wheel, pan with the arrow keys or by dragging with the mouse, and reset with 0.
+// Methods must be called from the application goroutine (eg: via QueueUpdateDraw).
+type ToolpathPrimitive struct {
+	*tview.Box
+
+	segments    []toolpathSegment
+	boundingBox *gcode.BoundingBox
+	// executed is the index of the segment at the tool position: all segments before it are
+	// executed.
+	executed int
+	position *gcode.Vector
+
+	zoom float64
+	// scale of the last drawn XY view, in dots per millimeter.
+	scale float64
+	// pan is the offset from the bounding box center to the view center, in millimeters.
+	pan gcode.Vector
+
+	dragging bool
+	dragX    int
+	dragY    int
+}
+
+// NewToolpathPrimitive creates a new ToolpathPrimitive.
+func NewToolpathPrimitive() *ToolpathPrimitive {
+	return &ToolpathPrimitive{
+		Box:  tview.NewBox(),
+		zoom: 1,
+	}
+}
+
+// SetSegments sets the segments to preview, resetting executed segments and the view. Probing
+// motion, motion in machine coordinates (G28, G30, G53) and motion from an unknown position are not
+// shown.
+func (tp *ToolpathPrimitive) SetSegments(segments []gcode.Segment) {
+	tp.segments = nil
+	tp.boundingBox = nil
+	tp.executed = 0
+	tp.scale = 0
+	tp.resetView()
+	for _, segment := range segments {
+		if segment.Type == gcode.SegmentTypeProbe || isMachineCoordinatesBlock(segment.Block) || !segment.StartKnown() {
+			continue
+		}
+		wco := segment.WorkCoordinateOffset
+		toolpathSegment := toolpathSegment{
+			rapid:  segment.Type == gcode.SegmentTypeRapid,
+			points: []gcode.Vector{segment.Start.Sub(wco)},
+		}
+		for _, point := range segment.Points(toolpathTolerance) {
+			toolpathSegment.points = append(toolpathSegment.points, point.Sub(wco))
+		}
+		for _, point := range toolpathSegment.points {
+			tp.addToBoundingBox(point)
+		}
+		tp.segments = append(tp.segments, toolpathSegment)
+	}
+}
+
+// SetPosition sets the tool position, in work coordinates, and updates executed segments.
+func (tp *ToolpathPrimitive) SetPosition(position gcode.Vector) {
+	tp.position = &position
+	last := min(len(tp.segments), tp.executed+toolpathSearchSegments)
+	for i := tp.executed; i < last; i++ {
+		if tp.segments[i].distance(position) <= toolpathPositionTolerance {
+			tp.executed = i
+			return
+		}
+	}
+}
+
+// isMachineCoordinatesBlock returns whether block has motion in machine coordinates.
+func isMachineCoordinatesBlock(block *gcode.Block) bool {
+	if block == nil {
+		return false
+	}
+	for _, word := range block.Commands() {
+		switch word.NormalizedString() {
+		case "G28", "G30", "G53":
+			return true
+		}
+	}
+	return false
+}
+
+func (tp *ToolpathPrimitive) addToBoundingBox(v gcode.Vector) {
+	if tp.boundingBox == nil {
+		tp.boundingBox = &gcode.BoundingBox{Min: v, Max: v}
+		return
+	}
+	tp.boundingBox.Min = gcode.Vector{
+		X: math.Min(tp.boundingBox.Min.X, v.X),
+		Y: math.Min(tp.boundingBox.Min.Y, v.Y),
+		Z: math.Min(tp.boundingBox.Min.Z, v.Z),
+	}
+	tp.boundingBox.Max = gcode.Vector{
+		X: math.Max(tp.boundingBox.Max.X, v.X),
+		Y: math.Max(tp.boundingBox.Max.Y, v.Y),
+		Z: math.Max(tp.boundingBox.Max.Z, v.Z),
+	}
+}
+
+func (tp *ToolpathPrimitive) resetView() {
+	tp.zoom = 1
+	tp.pan = gcode.Vector{}
+}
+
+// getScale returns the dots per millimeter for a canvas of given size in dots.
+func (tp *ToolpathPrimitive) getScale(width, height int) float64 {
+	size := tp.boundingBox.Max.Sub(tp.boundingBox.Min)
+	scale := math.Inf(1)
+	if size.X > 0 {
+		scale = math.Min(scale, float64(width-1)/size.X)
+	}
+	if size.Y > 0 {
+		scale = math.Min(scale, float64(height-1)/size.Y)
+	}
+	if math.IsInf(scale, 1) {
+		scale = 1
+	}
+	return scale * tp.zoom
+}
+
+// center returns the XY work coordinates at the center of the view.
+func (tp *ToolpathPrimitive) center() gcode.Vector {
+	return gcode.Vector{
+		X: (tp.boundingBox.Min.X+tp.boundingBox.Max.X)/2 + tp.pan.X,
+		Y: (tp.boundingBox.Min.Y+tp.boundingBox.Max.Y)/2 + tp.pan.Y,
+	}
+}
+
+// segmentColor returns the color and priority for segment at index i.
+func (tp *ToolpathPrimitive) segmentColor(i int) (tcell.Color, int) {
+	switch {
+	case i < tp.executed:
+		return toolpathExecutedColor, 2
+	case tp.segments[i].rapid:
+		return toolpathRapidColor, 1
+	default:
+		return toolpathCutColor, 3
+	}
+}
+
+// drawXY draws the XY view.
+func (tp *ToolpathPrimitive) drawXY(canvas *brailleCanvas, scale float64, center gcode.Vector) (float64, float64) {
+	toDots := func(v gcode.Vector) (float64, float64) {
+		return (v.X-center.X)*scale + float64(canvas.width())/2, float64(canvas.height())/2 - (v.Y-center.Y)*scale
+	}
+	for i := range tp.segments {
+		color, priority := tp.segmentColor(i)
+		points := tp.segments[i].points
+		for j := 1; j < len(points); j++ {
+			x0, y0 := toDots(points[j-1])
+			x1, y1 := toDots(points[j])
+			canvas.line(x0, y0, x1, y1, color, priority)
+		}
+	}
+	if tp.position == nil {
+		return -1, -1
+	}
+	return toDots(*tp.position)
+}
+
+// drawXZ draws the XZ side view, with the same X scale as the XY view.
+func (tp *ToolpathPrimitive) drawXZ(canvas *brailleCanvas, scale float64, center gcode.Vector) (float64, float64) {
+	zMin, zMax := tp.boundingBox.Min.Z, tp.boundingBox.Max.Z
+	if tp.position != nil {
+		zMin = math.Min(zMin, tp.position.Z)
+		zMax = math.Max(zMax, tp.position.Z)
+	}
+	zScale := 1.0
+	if zMax > zMin {
+		zScale = float64(canvas.height()-1) / (zMax - zMin)
+	}
+	toDots := func(v gcode.Vector) (float64, float64) {
+		return (v.X-center.X)*scale + float64(canvas.width())/2, (zMax - v.Z) * zScale
+	}
+	for i := range tp.segments {
+		color, priority := tp.segmentColor(i)
+		points := tp.segments[i].points
+		for j := 1; j < len(points); j++ {
+			x0, y0 := toDots(points[j-1])
+			x1, y1 := toDots(points[j])
+			canvas.line(x0, y0, x1, y1, color, priority)
+		}
+	}
+	if tp.position == nil {
+		return -1, -1
+	}
+	return toDots(*tp.position)
+}
+
+// drawTool draws the tool marker at given dot coordinates of a canvas drawn at x, y.
+func (tp *ToolpathPrimitive) drawTool(screen tcell.Screen, x, y, columns, rows int, dotX, dotY float64) {
+	column := int(math.Floor(dotX / 2))
+	row := int(math.Floor(dotY / 4))
+	if column < 0 || row < 0 || column >= columns || row >= rows {
+		return
+	}
+	screen.SetContent(x+column, y+row, '+', nil, tcell.StyleDefault.Foreground(toolpathToolColor).Bold(true))
+}
+
+// Draw draws this primitive onto the screen.
+func (tp *ToolpathPrimitive) Draw(screen tcell.Screen) {
+	tp.Box.DrawForSubclass(screen, tp)
+	x, y, width, height := tp.GetInnerRect()
+	if width <= 0 || height <= 0 {
+		return
+	}
+
+	if tp.boundingBox == nil {
+		tview.Print(screen, "No program loaded", x, y+height/2, width, tview.AlignCenter, tcell.ColorGray)
+		return
+	}
+
+	info := fmt.Sprintf("Zoom %sx Executed %d/%d", iFmt.SprintFloat(tp.zoom, 2), tp.executed, len(tp.segments))
+	if tp.position != nil {
+		info += fmt.Sprintf(
+			" X%s Y%s Z%s",
+			iFmt.SprintFloat(tp.position.X, 3), iFmt.SprintFloat(tp.position.Y, 3), iFmt.SprintFloat(tp.position.Z, 3),
+		)
+	}
+	tview.Print(screen, info, x, y+height-1, width, tview.AlignLeft, tcell.ColorWhite)
+	height--
+
+	sideViewHeight := 0
+	if height > toolpathSideViewHeight*2 {
+		sideViewHeight = toolpathSideViewHeight
+	}
+	xyHeight := height - sideViewHeight
+	if xyHeight <= 0 {
+		return
+	}
+
+	xyCanvas := newBrailleCanvas(width, xyHeight)
+	scale := tp.getScale(xyCanvas.width(), xyCanvas.height())
+	tp.scale = scale
+	center := tp.center()
+	toolX, toolY := tp.drawXY(xyCanvas, scale, center)
+	xyCanvas.draw(screen, x, y, tcell.StyleDefault)
+	tp.drawTool(screen, x, y, width, xyHeight, toolX, toolY)
+
+	if sideViewHeight > 0 {
+		for column := range width {
+			screen.SetContent(x+column, y+xyHeight, tcell.RuneHLine, nil, tcell.StyleDefault.Foreground(tcell.ColorGray))
+		}
+		tview.Print(screen, "XZ", x, y+xyHeight, width, tview.AlignLeft, tcell.ColorGray)
+		xzCanvas := newBrailleCanvas(width, sideViewHeight-1)
+		toolX, toolZ := tp.drawXZ(xzCanvas, scale, center)
+		xzCanvas.draw(screen, x, y+xyHeight+1, tcell.StyleDefault)
+		tp.drawTool(screen, x, y+xyHeight+1, width, sideViewHeight-1, toolX, toolZ)
+	}
+}
+
+// panBy pans the view by given number of columns and rows.
+func (tp *ToolpathPrimitive) panBy(columns, rows int) {
+	if tp.scale == 0 {
+		return
+	}
+	tp.pan.X += float64(columns*2) / tp.scale
+	tp.pan.Y -= float64(rows*4) / tp.scale
+}
+
+// InputHandler returns a handler which receives key events when it has focus.
+func (tp *ToolpathPrimitive) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+	return tp.Box.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+		switch event.Key() {
+		case tcell.KeyLeft:
+			tp.panBy(-4, 0)
+		case tcell.KeyRight:
+			tp.panBy(4, 0)
+		case tcell.KeyUp:
+			tp.panBy(0, -2)
+		case tcell.KeyDown:
+			tp.panBy(0, 2)
+		case tcell.KeyRune:
+			switch event.Rune() {
+			case '+', '=':
+				tp.zoom *= toolpathZoomFactor
+			case '-':
+				tp.zoom /= toolpathZoomFactor
+			case '0':
+				tp.resetView()
+			}
+		}
+	})
+}
+
+// MouseHandler returns a handler which receives mouse events.
+func (tp *ToolpathPrimitive) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return tp.Box.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		mx, my := event.Position()
+		switch action {
+		case tview.MouseLeftDown:
+			if !tp.InRect(mx, my) {
+				return false, nil
+			}
+			setFocus(tp)
+			tp.dragging = true
+			tp.dragX, tp.dragY = mx, my
+			return true, tp
+		case tview.MouseMove:
+			if !tp.dragging {
+				return false, nil
+			}
+			tp.panBy(tp.dragX-mx, tp.dragY-my)
+			tp.dragX, tp.dragY = mx, my
+			return true, tp
+		case tview.MouseLeftUp:
+			if !tp.dragging {
+				return false, nil
+			}
+			tp.dragging = false
+			return true, nil
+		case tview.MouseScrollUp:
+			if !tp.InRect(mx, my) {
+				return false, nil
+			}
+			tp.zoom *= toolpathZoomFactor
+			return true, nil
+		case tview.MouseScrollDown:
+			if !tp.InRect(mx, my) {
+				return false, nil
+			}
+			tp.zoom /= toolpathZoomFactor
+			return true, nil
+		}
+		return false, nil
+	})
+}